@@ -27,6 +27,12 @@ var (
 // WRPCheckConfig drives the WRP Access control configuration when enabled
 type WRPCheckConfig struct {
 	Type string
+
+	// Policy is the default partner ID policy.
+	Policy WRPPartnerPolicyConfig
+
+	// Rules override the default policy for some messages.  The first rule that applies wins.
+	Rules []WRPPartnerRuleConfig
 }
 
 // wrpAccessAuthority describes behavior for authorizing WRP messages
@@ -35,16 +41,35 @@ type wrpAccessAuthority interface {
 	authorizeWRP(context.Context, *wrp.Message) (bool, error)
 }
 
+// wrpAccessAuthorities runs several authorities in order, stopping at the first failure.
+type wrpAccessAuthorities []wrpAccessAuthority
+
+func (as wrpAccessAuthorities) authorizeWRP(ctx context.Context, msg *wrp.Message) (bool, error) {
+	var modified bool
+	for _, a := range as {
+		m, err := a.authorizeWRP(ctx, msg)
+		modified = modified || m
+		if err != nil {
+			return modified, err
+		}
+	}
+
+	return modified, nil
+}
+
 //authorizeWRP should run the scytale partnerID checks against incoming WRP messages
 //It takes a pointer to the wrp message as it may modify it in some cases. It returns
 //true if such modification was made. An error is returned in cases the validator
 //check failed and they are go-kit HTTP response error encoder friendly
 
 // wrpPartnersAuthority defines the access policy for which WRP messages
-// are authorized against the partners credentials of the message creator
+// are authorized against the partners credentials of the message creator.
+// The zero policy is the built-in one.
 type wrpPartnersAccess struct {
 	strict                  bool
 	receivedWRPMessageCount metrics.Counter
+	policy                  wrpPartnersPolicy
+	rules                   []wrpPartnersRule
 }
 
 func (p *wrpPartnersAccess) withFailure(labelValues ...string) metrics.Counter {
@@ -76,8 +101,7 @@ func (p *wrpPartnersAccess) authorizeWRP(ctx context.Context, message *wrp.Messa
 		return false, nil
 	}
 
-	tt, isTyped := token.(tokenType)
-	if !isTyped || tt.TokenType() != jwtTokenType {
+	if !carriesClaims(token) {
 		p.withFailure(ClientIDLabel, satClientID, ReasonLabel, TokenTypeMismatch).Add(1)
 
 		if p.strict {
@@ -101,7 +125,8 @@ func (p *wrpPartnersAccess) authorizeWRP(ctx context.Context, message *wrp.Messa
 		return false, nil
 	}
 
-	partnerVal, ok := bascule.GetAttribute[any](accessor, partnerKeys...)
+	policy := p.policyFor(ctx, message)
+	partnerVal, ok := policyClaim(accessor, policy.claims)
 	if !ok {
 		p.withFailure(ClientIDLabel, satClientID, ReasonLabel, JWTPIDInvalid).Add(1)
 
@@ -124,14 +149,8 @@ func (p *wrpPartnersAccess) authorizeWRP(ctx context.Context, message *wrp.Messa
 	}
 
 	if len(message.PartnerIDs) < 1 {
-		p.withFailure(ClientIDLabel, satClientID, ReasonLabel, WRPPIDMissing).Add(1)
-
-		if p.strict {
-			return false, ErrPIDMissing
-		}
-
-		message.PartnerIDs = allowedPartners
-		return true, nil
+		return p.apply(policy.onMissing, message, allowedPartners, ErrPIDMissing,
+			ClientIDLabel, satClientID, ReasonLabel, WRPPIDMissing)
 	}
 
 	if contains(allowedPartners, "*") {
@@ -139,20 +158,68 @@ func (p *wrpPartnersAccess) authorizeWRP(ctx context.Context, message *wrp.Messa
 		return false, nil
 	}
 
-	if isSubset(message.PartnerIDs, allowedPartners) {
+	if policy.matches(message.PartnerIDs, allowedPartners) {
 		p.withSuccess(ClientIDLabel, satClientID, ReasonLabel, WRPPIDMatch).Add(1)
 		return false, nil
 	}
 
-	p.withFailure(ClientIDLabel, satClientID, ReasonLabel, WRPPIDMismatch).Add(1)
-	if p.strict {
-		return false, ErrPIDMismatch
+	return p.apply(policy.onMismatch, message, allowedPartners, ErrPIDMismatch,
+		ClientIDLabel, satClientID, ReasonLabel, WRPPIDMismatch)
+}
+
+// apply carries out the policy's action for a message whose PartnerIDs are missing or do not
+// match, and counts the outcome.  When the policy is not strictly enforced, messages that would
+// be rejected are counted as failures and sent as they are.
+func (p *wrpPartnersAccess) apply(action string, message *wrp.Message, allowedPartners []string, rejection error, labelValues ...string) (bool, error) {
+	switch action {
+	case policyRewrite:
+		message.PartnerIDs = allowedPartners
+	case policyStrip:
+		stripped := make([]string, 0, len(message.PartnerIDs))
+		for _, id := range message.PartnerIDs {
+			if contains(allowedPartners, id) {
+				stripped = append(stripped, id)
+			}
+		}
+
+		if len(stripped) == 0 {
+			return false, p.reject(rejection, labelValues...)
+		}
+
+		message.PartnerIDs = stripped
+	case policyAllow:
+		p.withSuccess(labelValues...).Add(1)
+		return false, nil
+	default:
+		return false, p.reject(rejection, labelValues...)
 	}
 
-	message.PartnerIDs = allowedPartners
+	p.withSuccess(labelValues...).Add(1)
 	return true, nil
 }
 
+// reject counts a message rejected by the policy and returns the rejection when the policy is
+// strictly enforced.
+func (p *wrpPartnersAccess) reject(rejection error, labelValues ...string) error {
+	p.withFailure(labelValues...).Add(1)
+	if p.strict {
+		return rejection
+	}
+
+	return nil
+}
+
+// policyClaim returns the value of the first of the claims present in the token.
+func policyClaim(accessor bascule.AttributesAccessor, claims [][]string) (any, bool) {
+	for _, claim := range claims {
+		if v, ok := bascule.GetAttribute[any](accessor, claim...); ok {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
 // returns true if list contains str
 func contains(list []string, str string) bool {
 	for _, e := range list {
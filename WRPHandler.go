@@ -4,9 +4,9 @@
 package main
 
 import (
+	"bytes"
 	"net/http"
 
-	gokithttp "github.com/go-kit/kit/transport/http"
 	"github.com/xmidt-org/webpa-common/v2/xhttp"
 	"github.com/xmidt-org/wrp-go/v3"
 	"github.com/xmidt-org/wrp-go/v3/wrpcontext"
@@ -37,13 +37,132 @@ func nonWRPResponseWriterFactory(w http.ResponseWriter, _ *wrphttp.Request) (wrp
 	}, nil
 }
 
+// deviceResponseWriter is the WRP response writer used for requests that wait for the device's
+// WRP reply.  The reply returned through the fanout is re-encoded in the caller's Accept format.
+type deviceResponseWriter struct {
+	wrphttp.ResponseWriter
+}
+
+// newWRPResponseWriterFactory returns the response writer factory for the send handler.  When
+// syncResponse is enabled, SimpleRequestResponse messages with a transaction UUID get a
+// deviceResponseWriter, while every other message keeps the send-and-forget behavior.  Messages
+// put in the queue are answered with the job's status, so they never wait for the device.
+func newWRPResponseWriterFactory(syncResponse bool, queue *asyncSendQueue) wrphttp.ResponseWriterFunc {
+	entityResponseWriterFactory := wrphttp.NewEntityResponseWriter(wrp.Msgpack)
+	return func(w http.ResponseWriter, r *wrphttp.Request) (wrphttp.ResponseWriter, error) {
+		if !syncResponse || !expectsDeviceResponse(&r.Entity.Message) || queue.queues(r.Original) {
+			return nonWRPResponseWriterFactory(w, r)
+		}
+
+		erw, err := entityResponseWriterFactory(w, r)
+		if err != nil {
+			return nil, err
+		}
+
+		return &deviceResponseWriter{ResponseWriter: erw}, nil
+	}
+}
+
+func expectsDeviceResponse(m *wrp.Message) bool {
+	return m.Type == wrp.SimpleRequestResponseMessageType && m.TransactionUUID != ""
+}
+
+// relay writes a buffered fanout response to the caller.  Successful responses are decoded as
+// WRP messages and written in the caller's format along with the WRP headers.  Anything else,
+// including bodies that are not WRP messages, is passed through as is.
+func (d *deviceResponseWriter) relay(response *bufferedResponse) {
+	for k, values := range response.header {
+		if k == "Content-Type" || k == "Content-Length" {
+			continue
+		}
+
+		for _, v := range values {
+			d.Header().Add(k, v)
+		}
+	}
+
+	body := response.body.Bytes()
+	if response.code >= http.StatusMultipleChoices || len(body) == 0 {
+		response.passThrough(d)
+		return
+	}
+
+	format, err := wrp.FormatFromContentType(response.header.Get("Content-Type"), wrp.Msgpack)
+	if err != nil {
+		response.passThrough(d)
+		return
+	}
+
+	entity := &wrphttp.Entity{Format: format, Bytes: body}
+	if err := wrp.NewDecoderBytes(body, format).Decode(&entity.Message); err != nil {
+		response.passThrough(d)
+		return
+	}
+
+	wrphttp.AddMessageHeaders(d.Header(), &entity.Message)
+	d.Header().Set("Content-Type", d.WRPFormat().ContentType())
+	d.WriteHeader(response.code)
+	// nolint:errcheck
+	d.WriteWRP(entity)
+}
+
+// bufferedResponse captures a fanout response so it can be inspected before being written.
+type bufferedResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{
+		header: make(http.Header),
+		code:   http.StatusOK,
+	}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	b.code = code
+}
+
+func (b *bufferedResponse) passThrough(w http.ResponseWriter) {
+	if ct := b.header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+
+	w.WriteHeader(b.code)
+	// nolint:errcheck
+	w.Write(b.body.Bytes())
+}
+
+// serveFanout runs the fanout handler, relaying the device's reply when the response writer
+// waits for one.
+func serveFanout(fanoutHandler http.Handler, w wrphttp.ResponseWriter, fanout *http.Request) {
+	d, ok := w.(*deviceResponseWriter)
+	if !ok {
+		fanoutHandler.ServeHTTP(w, fanout)
+		return
+	}
+
+	response := newBufferedResponse()
+	fanoutHandler.ServeHTTP(response, fanout)
+	d.relay(response)
+}
+
 func newWRPFanoutHandler(fanoutHandler http.Handler) wrphttp.HandlerFunc {
 	if fanoutHandler == nil {
 		panic("fanoutHandler must be defined")
 	}
 	return func(w wrphttp.ResponseWriter, r *wrphttp.Request) {
 		fanoutPrep(r.Original, r.Entity.Bytes, r.Entity)
-		fanoutHandler.ServeHTTP(w, r.Original)
+		serveFanout(fanoutHandler, w, r.Original)
 	}
 }
 
@@ -52,8 +171,6 @@ func newWRPFanoutHandlerWithPIDCheck(fanoutHandler http.Handler, p wrpAccessAuth
 		panic("fanoutHandler and partnersAuthority arguments must be defined")
 	}
 
-	encodeError := gokithttp.DefaultErrorEncoder
-
 	return func(w wrphttp.ResponseWriter, r *wrphttp.Request) {
 		var (
 			ctx        = r.Context()
@@ -78,7 +195,7 @@ func newWRPFanoutHandlerWithPIDCheck(fanoutHandler http.Handler, p wrpAccessAuth
 		}
 
 		fanoutPrep(fanout, fanoutBody, entity)
-		fanoutHandler.ServeHTTP(w, fanout)
+		serveFanout(fanoutHandler, w, fanout)
 	}
 }
 
@@ -116,3 +116,160 @@ func TestNonWRPResponseWriterFactory(t *testing.T) {
 	c := w.WRPFormat()
 	assert.Equal(wrp.Msgpack, c)
 }
+
+func TestNewWRPResponseWriterFactory(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		SyncResponse bool
+		Target       string
+		DeliverAt    string
+		Message      wrp.Message
+		ExpectDevice bool
+	}{
+		{
+			Name:         "sync response disabled",
+			SyncResponse: false,
+			Message:      wrp.Message{Type: wrp.SimpleRequestResponseMessageType, TransactionUUID: "DEADBEEF"},
+		},
+		{
+			Name:         "simple request response",
+			SyncResponse: true,
+			Message:      wrp.Message{Type: wrp.SimpleRequestResponseMessageType, TransactionUUID: "DEADBEEF"},
+			ExpectDevice: true,
+		},
+		{
+			Name:         "missing transaction uuid",
+			SyncResponse: true,
+			Message:      wrp.Message{Type: wrp.SimpleRequestResponseMessageType},
+		},
+		{
+			Name:         "simple event",
+			SyncResponse: true,
+			Message:      wrp.Message{Type: wrp.SimpleEventMessageType, TransactionUUID: "DEADBEEF"},
+		},
+		{
+			Name:         "async send",
+			SyncResponse: true,
+			Target:       "http://localhost?async=true",
+			Message:      wrp.Message{Type: wrp.SimpleRequestResponseMessageType, TransactionUUID: "DEADBEEF"},
+		},
+		{
+			Name:         "scheduled send",
+			SyncResponse: true,
+			DeliverAt:    "2026-01-01T01:00:00Z",
+			Message:      wrp.Message{Type: wrp.SimpleRequestResponseMessageType, TransactionUUID: "DEADBEEF"},
+		},
+		{
+			Name:         "async not requested",
+			SyncResponse: true,
+			Target:       "http://localhost?async=false",
+			Message:      wrp.Message{Type: wrp.SimpleRequestResponseMessageType, TransactionUUID: "DEADBEEF"},
+			ExpectDevice: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			assert := assert.New(t)
+			queue, _, _ := newTestAsyncSendQueue(t, AsyncSendConfig{}, http.StatusOK)
+			target := testCase.Target
+			if len(target) == 0 {
+				target = "http://localhost"
+			}
+
+			r := httptest.NewRequest(http.MethodPost, target, nil)
+			r.Header.Set("Accept", wrp.JSON.ContentType())
+			if len(testCase.DeliverAt) > 0 {
+				r.Header.Set(deliverAtHeader, testCase.DeliverAt)
+			}
+
+			w, err := newWRPResponseWriterFactory(testCase.SyncResponse, queue)(httptest.NewRecorder(), &wrphttp.Request{
+				Original: r,
+				Entity:   &wrphttp.Entity{Message: testCase.Message},
+			})
+			require.NoError(t, err)
+
+			d, isDevice := w.(*deviceResponseWriter)
+			assert.Equal(testCase.ExpectDevice, isDevice)
+			if isDevice {
+				assert.Equal(wrp.JSON, d.WRPFormat())
+			}
+		})
+	}
+}
+
+func TestServeFanoutDeviceResponse(t *testing.T) {
+	reply := wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "mac:112233445566/config",
+		Destination:     "dns:scytale.example.com",
+		TransactionUUID: "DEADBEEF",
+		Payload:         []byte(`{"parameters":[]}`),
+	}
+
+	testCases := []struct {
+		Name                string
+		Code                int
+		ContentType         string
+		Body                []byte
+		ExpectedContentType string
+		ExpectedBody        []byte
+	}{
+		{
+			Name:                "device reply is re-encoded",
+			Code:                http.StatusOK,
+			ContentType:         wrp.Msgpack.ContentType(),
+			Body:                wrp.MustEncode(reply, wrp.Msgpack),
+			ExpectedContentType: wrp.JSON.ContentType(),
+			ExpectedBody:        wrp.MustEncode(reply, wrp.JSON),
+		},
+		{
+			Name:                "fanout failure is passed through",
+			Code:                http.StatusNotFound,
+			ContentType:         "text/plain; charset=utf-8",
+			Body:                []byte("device not found"),
+			ExpectedContentType: "text/plain; charset=utf-8",
+			ExpectedBody:        []byte("device not found"),
+		},
+		{
+			Name:                "non wrp reply is passed through",
+			Code:                http.StatusOK,
+			ContentType:         wrp.Msgpack.ContentType(),
+			Body:                []byte("not a wrp message"),
+			ExpectedContentType: wrp.Msgpack.ContentType(),
+			ExpectedBody:        []byte("not a wrp message"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			assert := assert.New(t)
+			fanoutHandler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", testCase.ContentType)
+				w.Header().Set("X-Xmidt-Span", "talaria")
+				w.WriteHeader(testCase.Code)
+				w.Write(testCase.Body) // nolint:errcheck
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "http://localhost", nil)
+			r.Header.Set("Accept", wrp.JSON.ContentType())
+			recorder := httptest.NewRecorder()
+			w, err := newWRPResponseWriterFactory(true, nil)(recorder, &wrphttp.Request{
+				Original: r,
+				Entity:   &wrphttp.Entity{Message: wrp.Message{Type: wrp.SimpleRequestResponseMessageType, TransactionUUID: "DEADBEEF"}},
+			})
+			require.NoError(t, err)
+
+			serveFanout(fanoutHandler, w, r)
+
+			assert.Equal(testCase.Code, recorder.Code)
+			assert.Equal(testCase.ExpectedContentType, recorder.Header().Get("Content-Type"))
+			assert.Equal(testCase.ExpectedBody, recorder.Body.Bytes())
+			assert.Equal("talaria", recorder.Header().Get("X-Xmidt-Span"))
+			if testCase.Code == http.StatusOK && testCase.ExpectedContentType == wrp.JSON.ContentType() {
+				assert.Equal(reply.TransactionUUID, recorder.Header().Get(wrphttp.TransactionUuidHeader))
+				assert.Equal(reply.Source, recorder.Header().Get(wrphttp.SourceHeader))
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/xmidt-org/webpa-common/v2/device"
+	"github.com/xmidt-org/webpa-common/v2/xhttp/fanout"
+)
+
+// failedTalariasHeader lists the talarias whose responses could not be aggregated.
+const failedTalariasHeader = "X-Xmidt-Failed-Talarias"
+
+// deviceIDKeys are the object fields used to dedupe list entries by device ID.
+var deviceIDKeys = []string{"id", "deviceID", "device_id"}
+
+// aggregateResult is the response of a single talaria to an aggregated request.
+type aggregateResult struct {
+	talaria string
+	body    interface{}
+	err     error
+}
+
+// aggregateHandler sends a request to every talaria and merges their JSON responses, so that
+// region wide questions can be answered through one call.  The count fields are summed, lists are
+// concatenated with entries for the same device ID dropped, and objects are merged field by field.
+type aggregateHandler struct {
+	endpoints     fanout.Endpoints
+	transactor    fanout.Transactor
+	authorization string
+	sumFields     sumFields
+	upstreamPath  func(*http.Request) string
+}
+
+// sumFields is the set of names of the object fields whose numbers are summed.
+type sumFields map[string]bool
+
+func newSumFields(names []string) sumFields {
+	fields := make(sumFields, len(names))
+	for _, name := range names {
+		fields[name] = true
+	}
+
+	return fields
+}
+
+func (h *aggregateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urls, err := h.endpoints.FanoutURLs(r)
+	if err != nil {
+		writeRequestError(w, r, errorStatusCode(err), err.Error())
+		return
+	}
+
+	var body []byte
+	if r.Body != nil {
+		if body, err = io.ReadAll(r.Body); err != nil {
+			writeRequestError(w, r, http.StatusBadRequest, fmt.Sprintf("failed to read request body: %s", err))
+			return
+		}
+	}
+
+	var (
+		results = make([]aggregateResult, len(urls))
+		wg      sync.WaitGroup
+	)
+
+	for i, u := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			target := *u
+			target.Path = h.upstreamPath(r)
+			target.RawPath = ""
+			target.RawQuery = r.URL.RawQuery
+
+			results[i] = h.query(r, target.String(), body)
+			results[i].talaria = u.Host
+		}()
+	}
+
+	wg.Wait()
+
+	var (
+		merged interface{}
+		failed []string
+	)
+
+	for _, result := range results {
+		if result.err != nil {
+			failed = append(failed, result.talaria)
+			continue
+		}
+
+		merged = h.sumFields.merge(merged, result.body, false)
+	}
+
+	if len(failed) > 0 {
+		w.Header().Set(failedTalariasHeader, strings.Join(failed, ","))
+	}
+
+	if len(failed) == len(results) {
+		writeRequestError(w, r, http.StatusBadGateway, "no talaria returned a response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	// nolint:errchkjson
+	_ = json.NewEncoder(w).Encode(merged)
+}
+
+// query sends the request to a single talaria and decodes its JSON response.
+func (h *aggregateHandler) query(original *http.Request, target string, body []byte) aggregateResult {
+	request, err := http.NewRequestWithContext(original.Context(), original.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return aggregateResult{err: err}
+	}
+
+	for _, name := range []string{"Content-Type", "Accept"} {
+		if v, ok := original.Header[name]; ok {
+			request.Header[name] = v
+		}
+	}
+
+	if len(h.authorization) > 0 {
+		request.Header.Set("Authorization", "Basic "+h.authorization)
+	}
+
+	// nolint:bodyclose
+	response, err := h.transactor(request)
+	if err != nil {
+		return aggregateResult{err: err}
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return aggregateResult{err: fmt.Errorf("talaria returned status %d", response.StatusCode)}
+	}
+
+	var result aggregateResult
+	if err := json.NewDecoder(response.Body).Decode(&result.body); err != nil {
+		result.err = fmt.Errorf("failed to decode talaria response: %w", err)
+	}
+
+	return result
+}
+
+// merge merges two decoded JSON values.  Only the numbers of the configured count fields are
+// summed; summed reports whether a and b are the values of such a field.  Lists are concatenated
+// without duplicate device IDs, and objects are merged recursively.  For any other values, the
+// first wins.
+func (f sumFields) merge(a, b interface{}, summed bool) interface{} {
+	if a == nil {
+		return b
+	}
+
+	switch av := a.(type) {
+	case float64:
+		if bv, ok := b.(float64); ok && summed {
+			return av + bv
+		}
+	case []interface{}:
+		if bv, ok := b.([]interface{}); ok {
+			return mergeJSONLists(av, bv)
+		}
+	case map[string]interface{}:
+		if bv, ok := b.(map[string]interface{}); ok {
+			for k, v := range bv {
+				av[k] = f.merge(av[k], v, f[k])
+			}
+
+			return av
+		}
+	}
+
+	return a
+}
+
+func mergeJSONLists(a, b []interface{}) []interface{} {
+	seen := make(map[device.ID]bool, len(a)+len(b))
+	merged := make([]interface{}, 0, len(a)+len(b))
+	for _, v := range append(a, b...) {
+		if id, ok := jsonDeviceID(v); ok {
+			if seen[id] {
+				continue
+			}
+
+			seen[id] = true
+		}
+
+		merged = append(merged, v)
+	}
+
+	return merged
+}
+
+// jsonDeviceID returns the device ID of a list entry, which is either a device ID string or an
+// object with a device ID field.
+func jsonDeviceID(v interface{}) (device.ID, bool) {
+	switch vv := v.(type) {
+	case string:
+		id, err := device.ParseID(vv)
+		return id, err == nil
+	case map[string]interface{}:
+		for _, k := range deviceIDKeys {
+			if s, ok := vv[k].(string); ok {
+				if id, err := device.ParseID(s); err == nil {
+					return id, true
+				}
+			}
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/webpa-common/v2/xhttp/fanout"
+)
+
+func TestSumFieldsMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        string
+		b        string
+		expected string
+	}{
+		{
+			name:     "first value",
+			a:        `null`,
+			b:        `{"count": 1}`,
+			expected: `{"count": 1}`,
+		},
+		{
+			name:     "counts are summed",
+			a:        `{"count": 1, "stats": {"bytes": 10}}`,
+			b:        `{"count": 2, "stats": {"bytes": 5, "messages": 3}}`,
+			expected: `{"count": 3, "stats": {"bytes": 15, "messages": 3}}`,
+		},
+		{
+			name:     "other numbers keep the first",
+			a:        `{"count": 1, "uptime": 3600, "version": 2, "stats": {"bytes": 10, "ratio": 0.5}}`,
+			b:        `{"count": 2, "uptime": 60, "version": 2, "stats": {"bytes": 5, "ratio": 0.25}}`,
+			expected: `{"count": 3, "uptime": 3600, "version": 2, "stats": {"bytes": 15, "ratio": 0.5}}`,
+		},
+		{
+			name:     "top level numbers keep the first",
+			a:        `1`,
+			b:        `2`,
+			expected: `1`,
+		},
+		{
+			name:     "lists are concatenated and deduped by device id",
+			a:        `{"devices": [{"id": "mac:112233445566"}, {"id": "mac:112233445577"}, "unknown"]}`,
+			b:        `{"devices": [{"id": "mac:112233445566"}, {"deviceID": "uuid:1234"}, "unknown"]}`,
+			expected: `{"devices": [{"id": "mac:112233445566"}, {"id": "mac:112233445577"}, "unknown", {"deviceID": "uuid:1234"}, "unknown"]}`,
+		},
+		{
+			name:     "device id strings are deduped",
+			a:        `["mac:112233445566"]`,
+			b:        `["mac:112233445566", "mac:112233445577"]`,
+			expected: `["mac:112233445566", "mac:112233445577"]`,
+		},
+		{
+			name:     "first string wins",
+			a:        `{"region": "east"}`,
+			b:        `{"region": "west"}`,
+			expected: `{"region": "east"}`,
+		},
+		{
+			name:     "mismatched types keep the first",
+			a:        `{"count": 1}`,
+			b:        `{"count": "2"}`,
+			expected: `{"count": 1}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var a, b interface{}
+			require.NoError(t, json.Unmarshal([]byte(tt.a), &a))
+			require.NoError(t, json.Unmarshal([]byte(tt.b), &b))
+
+			merged, err := json.Marshal(newSumFields([]string{"count", "bytes"}).merge(a, b, false))
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.expected, string(merged))
+		})
+	}
+}
+
+func newTestTalaria(t *testing.T, code int, body string) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/devices", r.URL.Path)
+		assert.Equal(t, "Basic dXNlcjpwYXNz", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(body))
+	}))
+
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestAggregateHandler(t *testing.T) {
+	var (
+		talaria0 = newTestTalaria(t, http.StatusOK, `{"count": 1, "devices": [{"id": "mac:112233445566"}]}`)
+		talaria1 = newTestTalaria(t, http.StatusOK, `{"count": 2, "devices": [{"id": "mac:112233445577"}, {"id": "mac:112233445566"}]}`)
+		failing  = newTestTalaria(t, http.StatusInternalServerError, `{}`)
+		invalid  = newTestTalaria(t, http.StatusOK, `not json`)
+	)
+
+	host := func(s *httptest.Server) string {
+		u, err := url.Parse(s.URL)
+		require.NoError(t, err)
+		return u.Host
+	}
+
+	tests := []struct {
+		name           string
+		talarias       []*httptest.Server
+		expectedCode   int
+		expectedBody   string
+		expectedFailed string
+	}{
+		{
+			name:         "all talarias respond",
+			talarias:     []*httptest.Server{talaria0, talaria1},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"count": 3, "devices": [{"id": "mac:112233445566"}, {"id": "mac:112233445577"}]}`,
+		},
+		{
+			name:           "some talarias fail",
+			talarias:       []*httptest.Server{talaria0, failing, invalid},
+			expectedCode:   http.StatusOK,
+			expectedBody:   `{"count": 1, "devices": [{"id": "mac:112233445566"}]}`,
+			expectedFailed: host(failing) + "," + host(invalid),
+		},
+		{
+			name:           "every talaria fails",
+			talarias:       []*httptest.Server{failing},
+			expectedCode:   http.StatusBadGateway,
+			expectedFailed: host(failing),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			urls := make([]string, 0, len(tt.talarias))
+			for _, s := range tt.talarias {
+				urls = append(urls, s.URL)
+			}
+
+			endpoints, err := fanout.ParseURLs(urls...)
+			require.NoError(t, err)
+
+			handler := &aggregateHandler{
+				endpoints:     endpoints,
+				transactor:    http.DefaultClient.Do,
+				authorization: "dXNlcjpwYXNz",
+				sumFields:     newSumFields(defaultSumFields),
+				upstreamPath: func(*http.Request) string {
+					return "/api/v2/devices"
+				},
+			}
+
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/v3/devices", nil))
+
+			assert.Equal(tt.expectedCode, response.Code)
+			assert.Equal(tt.expectedFailed, response.Header().Get(failedTalariasHeader))
+			if len(tt.expectedBody) > 0 {
+				assert.JSONEq(tt.expectedBody, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestAggregateHandlerNoTalarias(t *testing.T) {
+	handler := &aggregateHandler{endpoints: newAllEndpoints(nil)}
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/v3/devices", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+}
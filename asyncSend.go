@@ -0,0 +1,665 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/xmidt-org/webpa-common/v2/device"
+	"github.com/xmidt-org/webpa-common/v2/xhttp"
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	asyncSendConfigKey = "asyncSend"
+
+	defaultAsyncSendExpiry         = 24 * time.Hour
+	defaultAsyncSendRetention      = time.Hour
+	defaultAsyncSendMaxJobs        = 100000
+	defaultAsyncSendWorkers        = 10
+	defaultAsyncSendInitialBackoff = time.Second
+	defaultAsyncSendMaxBackoff     = 5 * time.Minute
+	defaultMaxDeliveryDelay        = 7 * 24 * time.Hour
+
+	asyncSendPollInterval = time.Second
+)
+
+// The statuses of a job.
+const (
+	jobPending   = "pending"
+	jobDelivered = "delivered"
+	jobFailed    = "failed"
+	jobExpired   = "expired"
+	jobCanceled  = "canceled"
+)
+
+// AsyncSendConfig drives the asynchronous sends through the asyncSend configuration.
+type AsyncSendConfig struct {
+	// Directory is where the queued jobs are stored.  Asynchronous sends are disabled when it
+	// is not set.
+	Directory string
+
+	// Expiry is how long a job is retried before it expires.
+	Expiry time.Duration
+
+	// Retention is how long a job is kept, so its final status can be read, once it is done.
+	Retention time.Duration
+
+	// MaxJobs is the maximum number of jobs kept, whether pending or done.
+	MaxJobs int
+
+	// Workers is the number of jobs delivered at a time.
+	Workers int
+
+	// InitialBackoff is the wait before the first retry.  It doubles for each retry after that,
+	// up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// MaxDeliveryDelay is how far in the future a delivery can be scheduled.
+	MaxDeliveryDelay time.Duration
+}
+
+// sendAttempt is the outcome of one attempt at delivering a job.
+type sendAttempt struct {
+	Time   time.Time `json:"time"`
+	Status int       `json:"status"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// sendJobStatus is what is reported about a job.
+type sendJobStatus struct {
+	ID              string        `json:"id"`
+	Status          string        `json:"status"`
+	Destination     string        `json:"destination"`
+	TransactionUUID string        `json:"transaction_uuid,omitempty"`
+	Created         time.Time     `json:"created"`
+	DeliverAt       time.Time     `json:"deliver_at,omitzero"`
+	Expires         time.Time     `json:"expires"`
+	NextAttempt     time.Time     `json:"next_attempt,omitzero"`
+	Finished        time.Time     `json:"finished,omitzero"`
+	Attempts        []sendAttempt `json:"attempts"`
+}
+
+// sendJob is a message queued for delivery, along with what is needed to deliver it.
+type sendJob struct {
+	sendJobStatus
+
+	Principal   string         `json:"principal"`
+	Path        string         `json:"path"`
+	ContentType string         `json:"content_type"`
+	Body        []byte         `json:"body"`
+	Values      *ContextValues `json:"values,omitempty"`
+
+	delivering bool
+}
+
+func (j *sendJob) snapshot() *sendJob {
+	c := *j
+	c.Attempts = slices.Clone(j.Attempts)
+	return &c
+}
+
+// scheduled reports whether the job is a scheduled delivery.
+func (j *sendJob) scheduled() bool {
+	return !j.DeliverAt.IsZero()
+}
+
+func (j *sendJob) finish(status string, now time.Time) {
+	j.Status = status
+	j.Finished = now
+	j.NextAttempt = time.Time{}
+}
+
+// fileJobStore keeps each job in its own JSON file within a directory.  Jobs are written to a
+// temporary file that is then renamed, so a crash never leaves a partially written job behind.
+type fileJobStore struct {
+	dir string
+}
+
+func newFileJobStore(dir string) (*fileJobStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create job directory %s: %w", dir, err)
+	}
+
+	return &fileJobStore{dir: dir}, nil
+}
+
+func (s *fileJobStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *fileJobStore) save(job *sendJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(s.dir, job.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	// nolint:errcheck
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path(job.ID))
+}
+
+func (s *fileJobStore) remove(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// loadAll reads every stored job.  Jobs that cannot be read are reported in the error, while the
+// others are still returned.
+func (s *fileJobStore) loadAll() ([]*sendJob, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		jobs []*sendJob
+		errs []error
+	)
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		job := new(sendJob)
+		if err := json.Unmarshal(data, job); err != nil {
+			errs = append(errs, fmt.Errorf("failed to read job %s: %w", e.Name(), err))
+			continue
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, errors.Join(errs...)
+}
+
+// asyncQueueFullError is returned when a message is sent asynchronously while the queue is full.
+type asyncQueueFullError struct{}
+
+func (asyncQueueFullError) Error() string {
+	return "too many asynchronous sends queued"
+}
+
+func (asyncQueueFullError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// asyncSendQueue stores messages sent with async=true, or scheduled for a later delivery, and
+// delivers them in the background, retrying with backoff until the device takes the message,
+// the message is refused, or the job expires.
+type asyncSendQueue struct {
+	store          *fileJobStore
+	deliver        http.Handler
+	jobsPath       string
+	expiry         time.Duration
+	retention      time.Duration
+	maxJobs        int
+	workers        int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	maxDelay       time.Duration
+	pollInterval   time.Duration
+	now            func() time.Time
+	newID          func() string
+	logger         *zap.Logger
+	sends          metrics.Counter
+	scheduled      metrics.Counter
+	pending        metrics.Gauge
+
+	lock sync.Mutex
+	jobs map[string]*sendJob
+
+	// reserved counts the new jobs being stored, which count towards maxJobs
+	reserved int
+
+	work     chan *sendJob
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// newAsyncSendQueue creates the asyncSendQueue for the configuration, or nil if it is disabled.
+// Jobs are delivered through deliver, and their status is read under jobsPath.  Jobs stored
+// by an earlier run are picked up again.  Scheduled deliveries are counted in scheduled, and
+// those waiting for their time in pending.
+func newAsyncSendQueue(cfg AsyncSendConfig, deliver http.Handler, jobsPath string, logger *zap.Logger, sends, scheduled metrics.Counter, pending metrics.Gauge) (*asyncSendQueue, error) {
+	if len(cfg.Directory) == 0 {
+		return nil, nil
+	}
+
+	if cfg.Expiry < 0 || cfg.Retention < 0 || cfg.MaxJobs < 0 || cfg.Workers < 0 || cfg.InitialBackoff < 0 || cfg.MaxBackoff < 0 || cfg.MaxDeliveryDelay < 0 {
+		return nil, errors.New("asynchronous send settings cannot be negative")
+	}
+
+	store, err := newFileJobStore(cfg.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &asyncSendQueue{
+		store:          store,
+		deliver:        deliver,
+		jobsPath:       jobsPath,
+		expiry:         orDefault(cfg.Expiry, defaultAsyncSendExpiry),
+		retention:      orDefault(cfg.Retention, defaultAsyncSendRetention),
+		maxJobs:        orDefault(cfg.MaxJobs, defaultAsyncSendMaxJobs),
+		workers:        orDefault(cfg.Workers, defaultAsyncSendWorkers),
+		initialBackoff: orDefault(cfg.InitialBackoff, defaultAsyncSendInitialBackoff),
+		maxBackoff:     orDefault(cfg.MaxBackoff, defaultAsyncSendMaxBackoff),
+		maxDelay:       orDefault(cfg.MaxDeliveryDelay, defaultMaxDeliveryDelay),
+		pollInterval:   asyncSendPollInterval,
+		now:            time.Now,
+		newID:          uuid.NewString,
+		logger:         logger,
+		sends:          sends,
+		scheduled:      scheduled,
+		pending:        pending,
+		jobs:           make(map[string]*sendJob),
+	}
+
+	q.work = make(chan *sendJob, q.workers)
+	jobs, err := store.loadAll()
+	if err != nil {
+		logger.Error("failed to load some queued jobs", zap.Error(err))
+	}
+
+	for _, job := range jobs {
+		q.jobs[job.ID] = job
+	}
+
+	return q, nil
+}
+
+func orDefault[T comparable](v, d T) T {
+	var zero T
+	if v == zero {
+		return d
+	}
+
+	return v
+}
+
+// start runs the scheduler and the workers that deliver the jobs.
+func (q *asyncSendQueue) start() {
+	if q == nil {
+		return
+	}
+
+	q.shutdown = make(chan struct{})
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for {
+				select {
+				case job := <-q.work:
+					q.attempt(job)
+				case <-q.shutdown:
+					return
+				}
+			}
+		}()
+	}
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		ticker := time.NewTicker(q.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				q.schedule()
+			case <-q.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// stop waits for the scheduler and the workers to finish.  Jobs not yet delivered stay queued.
+func (q *asyncSendQueue) stop() {
+	if q == nil {
+		return
+	}
+
+	close(q.shutdown)
+	q.wg.Wait()
+}
+
+// schedule hands the jobs that are due to the workers, expires the jobs that ran out of time,
+// and forgets the jobs that have been done for longer than the retention.
+func (q *asyncSendQueue) schedule() {
+	var (
+		now     = q.now()
+		expired []*sendJob
+		removed []string
+		fired   int
+		waiting int
+	)
+
+	q.lock.Lock()
+	for id, job := range q.jobs {
+		switch {
+		case job.Status != jobPending:
+			if now.Sub(job.Finished) >= q.retention {
+				delete(q.jobs, id)
+				removed = append(removed, id)
+			}
+		case job.delivering:
+		case !now.Before(job.Expires):
+			job.finish(jobExpired, now)
+			expired = append(expired, job.snapshot())
+		case !now.Before(job.NextAttempt):
+			select {
+			case q.work <- job:
+				job.delivering = true
+				if job.scheduled() && len(job.Attempts) == 0 {
+					fired++
+				}
+			default:
+				// the workers are busy, so the job waits for the next round
+				if job.scheduled() && len(job.Attempts) == 0 {
+					waiting++
+				}
+			}
+		case job.scheduled() && len(job.Attempts) == 0:
+			waiting++
+		}
+	}
+	q.lock.Unlock()
+
+	q.pending.Set(float64(waiting))
+	if fired > 0 {
+		q.scheduled.With(OutcomeLabel, Fired).Add(float64(fired))
+	}
+
+	for _, job := range expired {
+		q.sends.With(OutcomeLabel, jobExpired).Add(1)
+		if job.scheduled() && len(job.Attempts) == 0 {
+			q.scheduled.With(OutcomeLabel, jobExpired).Add(1)
+		}
+
+		q.save(job)
+	}
+
+	for _, id := range removed {
+		if err := q.store.remove(id); err != nil {
+			q.logger.Error("failed to remove job", zap.String("id", id), zap.Error(err))
+		}
+	}
+}
+
+// retryable reports whether a delivery that failed with the status code is tried again.
+func retryable(code int) bool {
+	return code == http.StatusNotFound ||
+		code == http.StatusRequestTimeout ||
+		code == http.StatusTooManyRequests ||
+		code >= http.StatusInternalServerError
+}
+
+func (q *asyncSendQueue) backoff(attempts int) time.Duration {
+	backoff := q.initialBackoff
+	for i := 1; i < attempts && backoff < q.maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, q.maxBackoff)
+}
+
+// attempt delivers the job once and records the outcome.
+func (q *asyncSendQueue) attempt(job *sendJob) {
+	code, message := q.send(job)
+	now := q.now()
+
+	q.lock.Lock()
+	job.delivering = false
+	job.Attempts = append(job.Attempts, sendAttempt{Time: now, Status: code, Error: message})
+	switch {
+	case code < http.StatusMultipleChoices:
+		job.finish(jobDelivered, now)
+	case !retryable(code):
+		job.finish(jobFailed, now)
+	default:
+		next := now.Add(q.backoff(len(job.Attempts)))
+		if next.Before(job.Expires) {
+			job.NextAttempt = next
+		} else {
+			job.finish(jobExpired, now)
+		}
+	}
+
+	snapshot := job.snapshot()
+	q.lock.Unlock()
+
+	if snapshot.Status != jobPending {
+		q.sends.With(OutcomeLabel, snapshot.Status).Add(1)
+	}
+
+	q.save(snapshot)
+}
+
+// send runs the job's message through the delivery handler, as the original request would have.
+func (q *asyncSendQueue) send(job *sendJob) (int, string) {
+	ctx := context.Background()
+	if job.Values != nil {
+		ctx = NewContextWithValue(ctx, job.Values)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, job.Path, nil)
+	if err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+
+	request.Body, request.GetBody = xhttp.NewRewindBytes(job.Body)
+	request.ContentLength = int64(len(job.Body))
+	request.Header.Set("Content-Type", job.ContentType)
+	request.Header.Set(device.DeviceNameHeader, job.Destination)
+
+	response := newBufferedResponse()
+	q.deliver.ServeHTTP(response, request)
+	if response.code >= http.StatusBadRequest {
+		return response.code, response.header.Get("X-Xmidt-Error")
+	}
+
+	return response.code, ""
+}
+
+func (q *asyncSendQueue) save(job *sendJob) {
+	if err := q.store.save(job); err != nil {
+		q.logger.Error("failed to store job", zap.String("id", job.ID), zap.Error(err))
+	}
+}
+
+// enqueue stores the message of a fanout request, as prepared by fanoutPrep, as a new job.  The
+// job is first attempted at deliverAt, or straight away if deliverAt is zero.
+func (q *asyncSendQueue) enqueue(r *http.Request, deliverAt time.Time) (*sendJob, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	now := q.now()
+	first := now
+	if deliverAt.After(now) {
+		first = deliverAt
+	}
+
+	job := &sendJob{
+		sendJobStatus: sendJobStatus{
+			ID:          q.newID(),
+			Status:      jobPending,
+			Destination: r.Header.Get(device.DeviceNameHeader),
+			Created:     now,
+			DeliverAt:   deliverAt,
+			Expires:     first.Add(q.expiry),
+			NextAttempt: first,
+			Attempts:    []sendAttempt{},
+		},
+		Path:        r.URL.Path,
+		ContentType: r.Header.Get("Content-Type"),
+		Body:        body,
+	}
+
+	if format, err := wrp.FormatFromContentType(job.ContentType, wrp.Msgpack); err == nil {
+		var msg wrp.Message
+		if wrp.NewDecoderBytes(body, format).Decode(&msg) == nil {
+			job.TransactionUUID = msg.TransactionUUID
+		}
+	}
+
+	job.Principal, _ = requestClient(r)
+
+	if vals, ok := FromContext(r.Context()); ok {
+		job.Values = vals
+	}
+
+	// the job's place is reserved under the same lock as the check, so that concurrent sends
+	// cannot go over maxJobs while the job is stored
+	q.lock.Lock()
+	if len(q.jobs)+q.reserved >= q.maxJobs {
+		q.lock.Unlock()
+		return nil, asyncQueueFullError{}
+	}
+
+	q.reserved++
+	q.lock.Unlock()
+
+	err = q.store.save(job)
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.reserved--
+	if err != nil {
+		return nil, err
+	}
+
+	q.jobs[job.ID] = job
+	return job.snapshot(), nil
+}
+
+// queues reports whether a single send is handled by the queue rather than sent straight away,
+// because it asks for async=true or schedules a delivery with the deliverAtHeader.
+func (q *asyncSendQueue) queues(r *http.Request) bool {
+	if q == nil {
+		return false
+	}
+
+	async, _ := strconv.ParseBool(r.URL.Query().Get("async"))
+	return async || len(r.Header.Get(deliverAtHeader)) > 0
+}
+
+// then is middleware for single sends that queues the message instead of sending it when the
+// request asks for async=true or schedules a delivery with the deliverAtHeader.  The response
+// is a 202 with the job's status.  Scheduled deliveries are refused when there is no queue.
+func (q *asyncSendQueue) then(delegate http.Handler) http.Handler {
+	if q == nil {
+		return refuseScheduledDelivery(delegate)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !q.queues(r) {
+			delegate.ServeHTTP(w, r)
+			return
+		}
+
+		deliverAt, err := parseDeliverAt(r.Header.Get(deliverAtHeader), q.now(), q.maxDelay)
+		if err != nil {
+			writeRequestError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		job, err := q.enqueue(r, deliverAt)
+		if err != nil {
+			q.sends.With(OutcomeLabel, Rejected).Add(1)
+			encodeError(r.Context(), err, w)
+			return
+		}
+
+		q.sends.With(OutcomeLabel, Queued).Add(1)
+		if job.scheduled() {
+			q.scheduled.With(OutcomeLabel, Scheduled).Add(1)
+		}
+
+		w.Header().Set("Location", q.jobsPath+"/"+job.ID)
+		writeJobStatus(w, http.StatusAccepted, &job.sendJobStatus)
+	})
+}
+
+// get returns a copy of the job, if the principal queued it.
+func (q *asyncSendQueue) get(id, principal string) (*sendJob, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok || job.Principal != principal {
+		return nil, false
+	}
+
+	return job.snapshot(), true
+}
+
+// jobsHandler serves the status of a job.  Jobs are only visible to the principal that queued them.
+func (q *asyncSendQueue) jobsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := requestClient(r)
+		job, ok := q.get(mux.Vars(r)["id"], principal)
+		if !ok {
+			writeRequestError(w, r, http.StatusNotFound, "job not found")
+			return
+		}
+
+		writeJobStatus(w, http.StatusOK, &job.sendJobStatus)
+	})
+}
+
+func writeJobStatus(w http.ResponseWriter, code int, status *sendJobStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	// nolint:errchkjson
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,332 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.uber.org/zap"
+)
+
+// newTestAsyncSendQueue creates a queue in a temporary directory that delivers with the given
+// status codes, in order, and whose clock is moved by hand.
+func newTestAsyncSendQueue(t *testing.T, cfg AsyncSendConfig, codes ...int) (*asyncSendQueue, *time.Time, *[]*http.Request) {
+	if len(cfg.Directory) == 0 {
+		cfg.Directory = t.TempDir()
+	}
+
+	var delivered []*http.Request
+	deliver := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := codes[min(len(delivered), len(codes)-1)]
+		delivered = append(delivered, r)
+		if code >= http.StatusBadRequest {
+			w.Header().Set("X-Xmidt-Error", "device not found")
+		}
+
+		w.WriteHeader(code)
+	})
+
+	q, err := newAsyncSendQueue(cfg, deliver, "/api/v3/jobs", zap.NewNop(), newTestCounter(), newTestCounter(), generic.NewGauge("pending"))
+	require.NoError(t, err)
+	require.NotNil(t, q)
+
+	now := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	q.now = func() time.Time { return now }
+	return q, &now, &delivered
+}
+
+func newAsyncRequest(t *testing.T, principal string) *http.Request {
+	msg := wrp.Message{
+		Type:            wrp.SimpleEventMessageType,
+		Source:          "dns:test",
+		Destination:     "mac:112233445566/config",
+		TransactionUUID: "DEADBEEF",
+	}
+
+	request := newDedupRequest(t, principal, msg)
+	request.URL.RawQuery = "async=true"
+	return request
+}
+
+// runDue hands the jobs that are due to the workers and runs them.
+func runDue(q *asyncSendQueue) {
+	q.schedule()
+	for {
+		select {
+		case job := <-q.work:
+			q.attempt(job)
+		default:
+			return
+		}
+	}
+}
+
+func TestNewAsyncSendQueue(t *testing.T) {
+	q, err := newAsyncSendQueue(AsyncSendConfig{}, http.NotFoundHandler(), "/api/v3/jobs", zap.NewNop(), newTestCounter(), newTestCounter(), generic.NewGauge("pending"))
+	assert.NoError(t, err)
+	assert.Nil(t, q)
+
+	_, err = newAsyncSendQueue(AsyncSendConfig{Directory: t.TempDir(), Expiry: -time.Second}, http.NotFoundHandler(), "/api/v3/jobs", zap.NewNop(), newTestCounter(), newTestCounter(), generic.NewGauge("pending"))
+	assert.Error(t, err)
+}
+
+func TestAsyncSendQueueSync(t *testing.T) {
+	q, _, delivered := newTestAsyncSendQueue(t, AsyncSendConfig{}, http.StatusAccepted)
+
+	request := newDedupRequest(t, "client", wrp.Message{Destination: "mac:112233445566"})
+	response := httptest.NewRecorder()
+	q.then(q.deliver).ServeHTTP(response, request)
+
+	assert.Equal(t, http.StatusAccepted, response.Code)
+	assert.Len(t, *delivered, 1)
+	assert.Empty(t, q.jobs)
+}
+
+func TestAsyncSendQueueDelivery(t *testing.T) {
+	tests := []struct {
+		name             string
+		codes            []int
+		rounds           []time.Duration
+		expectedStatus   string
+		expectedAttempts int
+	}{
+		{
+			name:             "delivered",
+			codes:            []int{http.StatusAccepted},
+			rounds:           []time.Duration{0},
+			expectedStatus:   jobDelivered,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "delivered after retries",
+			codes:            []int{http.StatusNotFound, http.StatusServiceUnavailable, http.StatusOK},
+			rounds:           []time.Duration{0, time.Second, 2 * time.Second},
+			expectedStatus:   jobDelivered,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "not retried before backoff",
+			codes:            []int{http.StatusNotFound, http.StatusOK},
+			rounds:           []time.Duration{0, 500 * time.Millisecond},
+			expectedStatus:   jobPending,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "failed",
+			codes:            []int{http.StatusForbidden},
+			rounds:           []time.Duration{0, time.Second},
+			expectedStatus:   jobFailed,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "expired",
+			codes:            []int{http.StatusNotFound},
+			rounds:           []time.Duration{0, time.Second, 2 * time.Second},
+			expectedStatus:   jobExpired,
+			expectedAttempts: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			q, now, delivered := newTestAsyncSendQueue(t, AsyncSendConfig{Expiry: 5 * time.Second}, tt.codes...)
+
+			response := httptest.NewRecorder()
+			q.then(http.NotFoundHandler()).ServeHTTP(response, newAsyncRequest(t, "client"))
+			require.Equal(http.StatusAccepted, response.Code)
+
+			var status sendJobStatus
+			require.NoError(json.Unmarshal(response.Body.Bytes(), &status))
+			assert.Equal(jobPending, status.Status)
+			assert.Equal("DEADBEEF", status.TransactionUUID)
+			assert.Equal("/api/v3/jobs/"+status.ID, response.Header().Get("Location"))
+
+			for _, d := range tt.rounds {
+				*now = now.Add(d)
+				runDue(q)
+			}
+
+			job, ok := q.get(status.ID, "client")
+			require.True(ok)
+			assert.Equal(tt.expectedStatus, job.Status)
+			assert.Len(job.Attempts, tt.expectedAttempts)
+			assert.Len(*delivered, tt.expectedAttempts)
+
+			for _, r := range *delivered {
+				assert.Equal("mac:112233445566/config", r.Header.Get("X-Webpa-Device-Name"))
+				assert.Equal(wrp.Msgpack.ContentType(), r.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestAsyncSendQueueFull(t *testing.T) {
+	q, _, _ := newTestAsyncSendQueue(t, AsyncSendConfig{MaxJobs: 1}, http.StatusAccepted)
+	handler := q.then(http.NotFoundHandler())
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, newAsyncRequest(t, "client"))
+	assert.Equal(t, http.StatusAccepted, response.Code)
+
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, newAsyncRequest(t, "client"))
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+}
+
+func TestAsyncSendQueueFullConcurrent(t *testing.T) {
+	const maxJobs = 5
+	q, _, _ := newTestAsyncSendQueue(t, AsyncSendConfig{MaxJobs: maxJobs}, http.StatusAccepted)
+
+	var (
+		wg       sync.WaitGroup
+		accepted atomic.Int32
+		full     atomic.Int32
+		requests = make([]*http.Request, 4*maxJobs)
+	)
+
+	for i := range requests {
+		requests[i] = newAsyncRequest(t, "client")
+	}
+
+	for _, request := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := q.enqueue(request, time.Time{})
+			switch {
+			case err == nil:
+				accepted.Add(1)
+			case errors.As(err, new(asyncQueueFullError)):
+				full.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, int32(maxJobs), accepted.Load())
+	assert.Equal(t, int32(len(requests)-maxJobs), full.Load())
+	assert.Len(t, q.jobs, maxJobs)
+	assert.Zero(t, q.reserved)
+}
+
+func TestAsyncSendQueueRestart(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	q, _, _ := newTestAsyncSendQueue(t, AsyncSendConfig{Directory: dir}, http.StatusAccepted)
+
+	response := httptest.NewRecorder()
+	q.then(http.NotFoundHandler()).ServeHTTP(response, newAsyncRequest(t, "client"))
+	require.Equal(http.StatusAccepted, response.Code)
+
+	// a file that is not a job is reported but does not stop the others from loading
+	require.NoError(os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0o600))
+
+	restarted, now, delivered := newTestAsyncSendQueue(t, AsyncSendConfig{Directory: dir, Retention: time.Minute}, http.StatusAccepted)
+	require.Len(restarted.jobs, 1)
+
+	runDue(restarted)
+	assert.Len(*delivered, 1)
+
+	// done jobs are forgotten after the retention
+	*now = now.Add(time.Minute)
+	restarted.schedule()
+	assert.Empty(restarted.jobs)
+
+	jobs, err := restarted.store.loadAll()
+	assert.Error(err)
+	assert.Empty(jobs)
+}
+
+func TestAsyncSendQueueJobsHandler(t *testing.T) {
+	q, _, _ := newTestAsyncSendQueue(t, AsyncSendConfig{}, http.StatusAccepted)
+
+	response := httptest.NewRecorder()
+	q.then(http.NotFoundHandler()).ServeHTTP(response, newAsyncRequest(t, "client"))
+
+	var queued sendJobStatus
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &queued))
+
+	router := mux.NewRouter()
+	router.Handle("/api/v3/jobs/{id}", q.jobsHandler())
+
+	tests := []struct {
+		name         string
+		id           string
+		principal    string
+		expectedCode int
+	}{
+		{
+			name:         "found",
+			id:           queued.ID,
+			principal:    "client",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "other principal",
+			id:           queued.ID,
+			principal:    "other",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "unknown",
+			id:           "unknown",
+			principal:    "client",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/api/v3/jobs/"+tt.id, nil)
+			request = request.WithContext(bascule.WithToken(request.Context(), &jwtToken{principal: tt.principal}))
+
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+			assert.Equal(t, tt.expectedCode, response.Code)
+			if tt.expectedCode == http.StatusOK {
+				var status sendJobStatus
+				require.NoError(t, json.Unmarshal(response.Body.Bytes(), &status))
+				assert.Equal(t, queued.ID, status.ID)
+			}
+		})
+	}
+}
+
+func TestAsyncSendQueueStart(t *testing.T) {
+	q, _, _ := newTestAsyncSendQueue(t, AsyncSendConfig{}, http.StatusAccepted)
+	q.pollInterval = 10 * time.Millisecond
+	q.start()
+	defer q.stop()
+
+	response := httptest.NewRecorder()
+	q.then(http.NotFoundHandler()).ServeHTTP(response, newAsyncRequest(t, "client"))
+
+	var queued sendJobStatus
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &queued))
+
+	assert.Eventually(t, func() bool {
+		job, ok := q.get(queued.ID, "client")
+		return ok && job.Status == jobDelivered
+	}, time.Second, 10*time.Millisecond)
+}
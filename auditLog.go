@@ -0,0 +1,440 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/xmidt-org/webpa-common/v2/device"
+	"github.com/xmidt-org/webpa-common/v2/xhttp/fanout"
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.uber.org/zap"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const auditConfigKey = "audit"
+
+// The audit sinks.
+const (
+	auditSinkFile    = "file"
+	auditSinkSyslog  = "syslog"
+	auditSinkWebhook = "webhook"
+)
+
+const (
+	defaultAuditBufferSize     = 10000
+	defaultAuditBatchSize      = 100
+	defaultAuditFlushInterval  = time.Second
+	defaultAuditWebhookTimeout = 10 * time.Second
+)
+
+// AuditFileConfig is the rotating JSON lines file of the file sink.
+type AuditFileConfig struct {
+	// Filename is the path of the file.  It is required.
+	Filename string
+
+	// MaxSize is the size in megabytes at which the file is rotated.  Defaults to 100.
+	MaxSize int
+
+	// MaxAge is the number of days rotated files are kept.  By default they are kept forever.
+	MaxAge int
+
+	// MaxBackups is the number of rotated files kept.  By default all of them are kept.
+	MaxBackups int
+
+	// Compress gzips the rotated files.
+	Compress bool
+}
+
+// AuditSyslogConfig is the syslog server of the syslog sink.
+type AuditSyslogConfig struct {
+	// Network and Address are the syslog server.  When both are empty, the local syslog server
+	// is used.
+	Network string
+	Address string
+
+	// Tag is the syslog tag of the records.  Defaults to the program name.
+	Tag string
+}
+
+// AuditWebhookConfig is the endpoint of the webhook sink, which receives each batch of records
+// as a JSON lines POST.
+type AuditWebhookConfig struct {
+	// URL is the endpoint.  It is required.
+	URL string
+
+	// Timeout is the timeout of each POST.  Defaults to 10s.
+	Timeout time.Duration
+
+	// Headers are added to each POST, e.g. for the endpoint's authorization.
+	Headers map[string]string
+}
+
+// AuditConfig is the configuration of the audit log, which keeps one record of each send and
+// stat request apart from the operational logs.
+type AuditConfig struct {
+	// Sink is where the records are written: file, syslog or webhook.  The audit log is disabled
+	// when it is not set.
+	Sink string
+
+	File    AuditFileConfig
+	Syslog  AuditSyslogConfig
+	Webhook AuditWebhookConfig
+
+	// BufferSize is the number of records buffered for the sink.  Defaults to 10000.
+	BufferSize int
+
+	// BatchSize is the maximum number of records written to the sink at once.  Defaults to 100.
+	BatchSize int
+
+	// FlushInterval is how often buffered records are written when a batch is not full.
+	// Defaults to 1s.
+	FlushInterval time.Duration
+
+	// Block makes requests wait for room in a full buffer rather than dropping their records.
+	Block bool
+}
+
+// auditRecord is the record of one send or stat request.
+type auditRecord struct {
+	Time            time.Time `json:"time"`
+	Principal       string    `json:"principal"`
+	TokenType       string    `json:"tokenType,omitempty"`
+	PartnerIDs      []string  `json:"partnerIDs,omitempty"`
+	Capability      string    `json:"capability,omitempty"`
+	Method          string    `json:"method,omitempty"`
+	Path            string    `json:"path,omitempty"`
+	Destination     string    `json:"destination,omitempty"`
+	MessageType     string    `json:"messageType,omitempty"`
+	TransactionUUID string    `json:"transactionUUID,omitempty"`
+	PayloadHash     string    `json:"payloadHash,omitempty"`
+	Endpoint        string    `json:"endpoint,omitempty"`
+	StatusCode      int       `json:"statusCode"`
+	Latency         float64   `json:"latencyMs"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// auditSink writes batches of JSON encoded records.
+type auditSink interface {
+	write(records [][]byte) error
+	close() error
+}
+
+type fileAuditSink struct {
+	logger *lumberjack.Logger
+}
+
+func (s *fileAuditSink) write(records [][]byte) error {
+	var buffer bytes.Buffer
+	for _, record := range records {
+		buffer.Write(record)
+		buffer.WriteByte('\n')
+	}
+
+	_, err := s.logger.Write(buffer.Bytes())
+	return err
+}
+
+func (s *fileAuditSink) close() error {
+	return s.logger.Close()
+}
+
+type webhookAuditSink struct {
+	client  *http.Client
+	url     string
+	headers map[string]string
+}
+
+func (s *webhookAuditSink) write(records [][]byte) error {
+	request, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(append(bytes.Join(records, []byte("\n")), '\n')))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	for name, value := range s.headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("audit webhook responded with status %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+func (s *webhookAuditSink) close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func newAuditSink(cfg AuditConfig) (auditSink, error) {
+	switch cfg.Sink {
+	case auditSinkFile:
+		if len(cfg.File.Filename) == 0 {
+			return nil, errors.New("the audit file sink requires a filename")
+		}
+
+		return &fileAuditSink{
+			logger: &lumberjack.Logger{
+				Filename:   cfg.File.Filename,
+				MaxSize:    cfg.File.MaxSize,
+				MaxAge:     cfg.File.MaxAge,
+				MaxBackups: cfg.File.MaxBackups,
+				Compress:   cfg.File.Compress,
+			},
+		}, nil
+
+	case auditSinkSyslog:
+		return newSyslogAuditSink(cfg.Syslog)
+
+	case auditSinkWebhook:
+		if len(cfg.Webhook.URL) == 0 {
+			return nil, errors.New("the audit webhook sink requires a URL")
+		}
+
+		return &webhookAuditSink{
+			client:  &http.Client{Timeout: orDefault(cfg.Webhook.Timeout, defaultAuditWebhookTimeout)},
+			url:     cfg.Webhook.URL,
+			headers: cfg.Webhook.Headers,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("invalid audit sink [%s]", cfg.Sink)
+}
+
+// auditLog buffers the records of send and stat requests and writes them to the sink in
+// batches, away from the requests.  A nil auditLog records nothing.
+type auditLog struct {
+	sink          auditSink
+	batchSize     int
+	flushInterval time.Duration
+	block         bool
+	now           func() time.Time
+	logger        *zap.Logger
+
+	// records counts the records written, dropped because the buffer was full, or failed to be
+	// written.  buffered is the number of records waiting for the sink.
+	records  metrics.Counter
+	buffered metrics.Gauge
+
+	queue    chan auditRecord
+	shutdown chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+// newAuditLog creates the audit log for the configuration.  It returns nil when no sink is
+// configured.
+func newAuditLog(cfg AuditConfig, logger *zap.Logger, records metrics.Counter, buffered metrics.Gauge) (*auditLog, error) {
+	if len(cfg.Sink) == 0 {
+		return nil, nil
+	}
+
+	sink, err := newAuditSink(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditLog{
+		sink:          sink,
+		batchSize:     orDefault(cfg.BatchSize, defaultAuditBatchSize),
+		flushInterval: orDefault(cfg.FlushInterval, defaultAuditFlushInterval),
+		block:         cfg.Block,
+		now:           time.Now,
+		logger:        logger,
+		records:       records,
+		buffered:      buffered,
+		queue:         make(chan auditRecord, orDefault(cfg.BufferSize, defaultAuditBufferSize)),
+		shutdown:      make(chan struct{}),
+	}, nil
+}
+
+// start starts writing the buffered records to the sink.
+func (a *auditLog) start() {
+	if a == nil {
+		return
+	}
+
+	a.wg.Add(1)
+	go a.run()
+}
+
+// stop writes the records still buffered and closes the sink.
+func (a *auditLog) stop() {
+	if a == nil {
+		return
+	}
+
+	a.once.Do(func() {
+		close(a.shutdown)
+		a.wg.Wait()
+		if err := a.sink.close(); err != nil {
+			a.logger.Error("failed to close the audit sink", zap.Error(err))
+		}
+	})
+}
+
+// record buffers a record for the sink.  When the buffer is full, the record is dropped unless
+// the audit log blocks.
+func (a *auditLog) record(record auditRecord) {
+	if a == nil {
+		return
+	}
+
+	if a.block {
+		select {
+		case a.queue <- record:
+		case <-a.shutdown:
+			a.records.With(OutcomeLabel, Dropped).Add(1)
+		}
+	} else {
+		select {
+		case a.queue <- record:
+		default:
+			a.records.With(OutcomeLabel, Dropped).Add(1)
+		}
+	}
+
+	a.buffered.Set(float64(len(a.queue)))
+}
+
+func (a *auditLog) run() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]auditRecord, 0, a.batchSize)
+	for {
+		select {
+		case record := <-a.queue:
+			batch = append(batch, record)
+			if len(batch) >= a.batchSize {
+				batch = a.flush(batch)
+			}
+
+		case <-ticker.C:
+			batch = a.flush(batch)
+
+		case <-a.shutdown:
+			for {
+				select {
+				case record := <-a.queue:
+					batch = append(batch, record)
+					if len(batch) >= a.batchSize {
+						batch = a.flush(batch)
+					}
+
+				default:
+					a.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush writes the batch to the sink, returning the emptied batch.
+func (a *auditLog) flush(batch []auditRecord) []auditRecord {
+	a.buffered.Set(float64(len(a.queue)))
+	if len(batch) == 0 {
+		return batch
+	}
+
+	encoded := make([][]byte, 0, len(batch))
+	for _, record := range batch {
+		data, err := json.Marshal(record)
+		if err != nil {
+			a.records.With(OutcomeLabel, Failed).Add(1)
+			continue
+		}
+
+		encoded = append(encoded, data)
+	}
+
+	if err := a.sink.write(encoded); err != nil {
+		a.logger.Error("failed to write audit records", zap.Int("count", len(encoded)), zap.Error(err))
+		a.records.With(OutcomeLabel, Failed).Add(float64(len(encoded)))
+	} else {
+		a.records.With(OutcomeLabel, Written).Add(float64(len(encoded)))
+	}
+
+	return batch[:0]
+}
+
+type auditStartKey struct{}
+
+// startFanout is a fanout.FanoutRequestFunc that notes when the fanout request was started, so
+// that its latency can be recorded.
+func (a *auditLog) startFanout(ctx context.Context, _, _ *http.Request, _ []byte) (context.Context, error) {
+	if a == nil {
+		return ctx, nil
+	}
+
+	return context.WithValue(ctx, auditStartKey{}, a.now()), nil
+}
+
+// fanoutResult is a fanout.FanoutResponseFunc that records the result of a send or stat
+// request.  The WRP message of sends is read from the ContextKeyWRP value.
+func (a *auditLog) fanoutResult(ctx context.Context, _ http.ResponseWriter, result fanout.Result) context.Context {
+	if a == nil || result.Request == nil {
+		return ctx
+	}
+
+	requestCtx := result.Request.Context()
+	record := auditRecord{
+		Time:       a.now(),
+		Endpoint:   result.Request.URL.Redacted(),
+		StatusCode: result.StatusCode,
+	}
+
+	if started, ok := requestCtx.Value(auditStartKey{}).(time.Time); ok {
+		record.Latency = float64(record.Time.Sub(started).Microseconds()) / 1000
+	}
+
+	if result.Err != nil {
+		record.Error = result.Err.Error()
+	}
+
+	if vals, ok := FromContext(requestCtx); ok {
+		record.Principal = vals.SatClientID
+		record.TokenType = vals.TokenType
+		record.PartnerIDs = vals.PartnerIDs
+		record.Capability = vals.Capability
+		record.Method = vals.Method
+		record.Path = vals.Path
+	}
+
+	if msg, ok := requestCtx.Value(ContextKeyWRP).(*wrp.Message); ok && msg != nil {
+		record.Destination = msg.Destination
+		record.MessageType = msg.Type.FriendlyName()
+		record.TransactionUUID = msg.TransactionUUID
+		if len(msg.Payload) > 0 {
+			sum := sha256.Sum256(msg.Payload)
+			record.PayloadHash = hex.EncodeToString(sum[:])
+		}
+	} else {
+		record.Destination = result.Request.Header.Get(device.DeviceNameHeader)
+	}
+
+	a.record(record)
+	return ctx
+}
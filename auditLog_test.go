@@ -0,0 +1,314 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/webpa-common/v2/xhttp/fanout"
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.uber.org/zap"
+)
+
+// testAuditSink keeps the records written to it.
+type testAuditSink struct {
+	lock    sync.Mutex
+	records []auditRecord
+	err     error
+	closed  bool
+}
+
+func (s *testAuditSink) write(records [][]byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+
+	for _, data := range records {
+		var record auditRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+
+		s.records = append(s.records, record)
+	}
+
+	return nil
+}
+
+func (s *testAuditSink) close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	return nil
+}
+
+func newTestAuditLog(sink auditSink, bufferSize int) (*auditLog, *testCounter) {
+	counter := newTestCounter()
+	return &auditLog{
+		sink:          sink,
+		batchSize:     2,
+		flushInterval: time.Hour,
+		now:           time.Now,
+		logger:        zap.NewNop(),
+		records:       counter,
+		buffered:      generic.NewGauge("buffered"),
+		queue:         make(chan auditRecord, bufferSize),
+		shutdown:      make(chan struct{}),
+	}, counter
+}
+
+func TestNewAuditLog(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         AuditConfig
+		expectedNil bool
+		expectedErr bool
+	}{
+		{
+			name:        "disabled",
+			expectedNil: true,
+		},
+		{
+			name: "file",
+			cfg:  AuditConfig{Sink: auditSinkFile, File: AuditFileConfig{Filename: filepath.Join(t.TempDir(), "audit.log")}},
+		},
+		{
+			name:        "file without filename",
+			cfg:         AuditConfig{Sink: auditSinkFile},
+			expectedErr: true,
+		},
+		{
+			name: "webhook",
+			cfg:  AuditConfig{Sink: auditSinkWebhook, Webhook: AuditWebhookConfig{URL: "http://localhost/audit"}},
+		},
+		{
+			name:        "webhook without URL",
+			cfg:         AuditConfig{Sink: auditSinkWebhook},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown sink",
+			cfg:         AuditConfig{Sink: "kafka"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := newAuditLog(tt.cfg, zap.NewNop(), newTestCounter(), generic.NewGauge("buffered"))
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			if tt.expectedNil {
+				assert.Nil(t, a)
+				return
+			}
+
+			require.NotNil(t, a)
+			assert.Equal(t, defaultAuditBatchSize, a.batchSize)
+			assert.Equal(t, defaultAuditBufferSize, cap(a.queue))
+		})
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := newAuditSink(AuditConfig{Sink: auditSinkFile, File: AuditFileConfig{Filename: filename}})
+	require.NoError(t, err)
+
+	require.NoError(t, sink.write([][]byte{[]byte(`{"principal":"a"}`), []byte(`{"principal":"b"}`)}))
+	require.NoError(t, sink.close())
+
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	assert.Equal(t, []string{`{"principal":"a"}`, `{"principal":"b"}`}, lines)
+}
+
+func TestWebhookAuditSink(t *testing.T) {
+	var (
+		status = http.StatusNoContent
+		body   []byte
+		header http.Header
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink, err := newAuditSink(AuditConfig{
+		Sink:    auditSinkWebhook,
+		Webhook: AuditWebhookConfig{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, sink.write([][]byte{[]byte(`{"principal":"a"}`), []byte(`{"principal":"b"}`)}))
+	assert.Equal(t, "{\"principal\":\"a\"}\n{\"principal\":\"b\"}\n", string(body))
+	assert.Equal(t, "application/x-ndjson", header.Get("Content-Type"))
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+
+	status = http.StatusInternalServerError
+	assert.Error(t, sink.write([][]byte{[]byte(`{}`)}))
+}
+
+func TestAuditLogBuffering(t *testing.T) {
+	assert := assert.New(t)
+	sink := &testAuditSink{}
+	a, counter := newTestAuditLog(sink, 2)
+
+	// the buffer holds two records and the third is dropped
+	for _, principal := range []string{"a", "b", "c"} {
+		a.record(auditRecord{Principal: principal})
+	}
+
+	assert.Equal(Dropped, counter.labelPairs[OutcomeLabel])
+	assert.Equal(float64(1), counter.count)
+
+	// stopping writes the buffered records and closes the sink
+	a.start()
+	a.stop()
+	a.stop()
+
+	require.Len(t, sink.records, 2)
+	assert.Equal("a", sink.records[0].Principal)
+	assert.Equal("b", sink.records[1].Principal)
+	assert.True(sink.closed)
+	assert.Equal(Written, counter.labelPairs[OutcomeLabel])
+	assert.Equal(float64(3), counter.count)
+
+	// records are dropped after the audit log is stopped, even when it blocks
+	a.block = true
+	a.record(auditRecord{Principal: "d"})
+	a.record(auditRecord{Principal: "e"})
+	a.record(auditRecord{Principal: "f"})
+	assert.Equal(Dropped, counter.labelPairs[OutcomeLabel])
+
+	var none *auditLog
+	none.record(auditRecord{})
+	none.start()
+	none.stop()
+}
+
+func TestAuditLogFlushFailure(t *testing.T) {
+	sink := &testAuditSink{err: io.ErrClosedPipe}
+	a, counter := newTestAuditLog(sink, 10)
+
+	a.record(auditRecord{Principal: "a"})
+	a.flush([]auditRecord{<-a.queue})
+
+	assert.Equal(t, Failed, counter.labelPairs[OutcomeLabel])
+	assert.Equal(t, float64(1), counter.count)
+}
+
+func TestAuditLogFanoutResult(t *testing.T) {
+	vals := &ContextValues{
+		SatClientID: "client",
+		Method:      http.MethodPost,
+		Path:        "/api/v3/device",
+		PartnerIDs:  []string{"comcast"},
+		TokenType:   jwtTokenType,
+		Capability:  "x1:webpa:api:device/.*/config:all",
+	}
+
+	start := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		msg      *wrp.Message
+		err      error
+		expected auditRecord
+	}{
+		{
+			name: "send",
+			msg: &wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Destination:     "mac:112233445566/config",
+				TransactionUUID: "abc",
+				Payload:         []byte("payload"),
+			},
+			expected: auditRecord{
+				Destination:     "mac:112233445566/config",
+				MessageType:     "SimpleRequestResponse",
+				TransactionUUID: "abc",
+				PayloadHash:     "239f59ed55e737c77147cf55ad0c1b030b6d7ee748a7426952f9b852d5a935e5",
+			},
+		},
+		{
+			name: "stat",
+			err:  io.ErrUnexpectedEOF,
+			expected: auditRecord{
+				Destination: "mac:112233445566",
+				Error:       io.ErrUnexpectedEOF.Error(),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			sink := &testAuditSink{}
+			a, _ := newTestAuditLog(sink, 10)
+			a.now = func() time.Time { return start }
+
+			fr := httptest.NewRequest(http.MethodPost, "http://talaria:6200/api/v3/device/send", nil)
+			fr.Header.Set("X-Webpa-Device-Name", "mac:112233445566")
+
+			ctx, err := a.startFanout(NewContextWithValue(context.Background(), vals), nil, fr, nil)
+			require.NoError(t, err)
+			if tt.msg != nil {
+				ctx = context.WithValue(ctx, ContextKeyWRP, tt.msg)
+			}
+
+			a.now = func() time.Time { return start.Add(1500 * time.Microsecond) }
+			a.fanoutResult(ctx, httptest.NewRecorder(), fanout.Result{
+				StatusCode: http.StatusOK,
+				Request:    fr.WithContext(ctx),
+				Err:        tt.err,
+			})
+
+			a.flush([]auditRecord{<-a.queue})
+			require.Len(t, sink.records, 1)
+
+			expected := tt.expected
+			expected.Time = start.Add(1500 * time.Microsecond)
+			expected.Principal = "client"
+			expected.TokenType = jwtTokenType
+			expected.PartnerIDs = []string{"comcast"}
+			expected.Capability = vals.Capability
+			expected.Method = http.MethodPost
+			expected.Path = "/api/v3/device"
+			expected.Endpoint = "http://talaria:6200/api/v3/device/send"
+			expected.StatusCode = http.StatusOK
+			expected.Latency = 1.5
+			assert.Equal(expected, sink.records[0])
+		})
+	}
+}
@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/syslog"
+)
+
+type syslogAuditSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogAuditSink(cfg AuditSyslogConfig) (auditSink, error) {
+	writer, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_AUTH, cfg.Tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the audit syslog server: %w", err)
+	}
+
+	return &syslogAuditSink{writer: writer}, nil
+}
+
+func (s *syslogAuditSink) write(records [][]byte) error {
+	var errs []error
+	for _, record := range records {
+		errs = append(errs, s.writer.Info(string(record)))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *syslogAuditSink) close() error {
+	return s.writer.Close()
+}
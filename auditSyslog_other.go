@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows || plan9
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// newSyslogAuditSink fails, since log/syslog is not implemented on this platform.
+func newSyslogAuditSink(AuditSyslogConfig) (auditSink, error) {
+	return nil, fmt.Errorf("the audit syslog sink is not supported on %s", runtime.GOOS)
+}
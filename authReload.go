@@ -0,0 +1,392 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-kit/kit/metrics"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/sallust"
+	"go.uber.org/zap"
+)
+
+const (
+	authReloadConfigKey      = "authReload"
+	capabilityCheckConfigKey = "capabilityCheck"
+)
+
+var errBasicAuthMissingSeparator = errors.New("decoded credentials must be of the form user:password")
+
+// AuthReloadConfig drives the reloading of the authentication and authorization configuration
+// through the authReload configuration.
+type AuthReloadConfig struct {
+	// WatchConfig reloads whenever the configuration file changes.
+	WatchConfig bool
+
+	// Signal reloads whenever scytale receives a SIGHUP.
+	Signal bool
+}
+
+func (c AuthReloadConfig) enabled() bool {
+	return c.WatchConfig || c.Signal
+}
+
+// authSettings are the parts of the auth chain's configuration that can be reloaded: the allowed
+// basic auth credentials and the capability check.
+type authSettings struct {
+	basicAllowed map[string]passwordHash
+
+	// basicClaims are the claims mapped to basic auth users, by user.
+	basicClaims map[string]map[string]any
+
+	// certificates is nil when mTLS authentication is disabled.
+	certificates *certificateSettings
+
+	// capabilities is nil when capability checks are disabled.
+	capabilities *capabilitySettings
+}
+
+// capabilitySettings is the configured capability check.
+type capabilitySettings struct {
+	enforce         bool
+	check           endpointRegexCheck
+	endpointBuckets []*regexp.Regexp
+
+	// policy replaces the regex check when a policy file is configured.
+	policy *capabilityPolicy
+}
+
+// loadAuthSettings reads the authHeader and capabilityCheck configuration.  When strict is false,
+// invalid basic auth headers and endpoint buckets are logged and skipped, otherwise they are
+// returned as errors.
+func loadAuthSettings(v *viper.Viper, logger *zap.Logger, strict bool) (*authSettings, error) {
+	settings := &authSettings{
+		basicAllowed: make(map[string]passwordHash),
+		basicClaims:  make(map[string]map[string]any),
+	}
+
+	// neither the configured entries nor the decoded credentials are logged, since they hold
+	// passwords and hashes.  Only the position of an invalid entry is.
+	for i, a := range v.GetStringSlice(basicAuthConfigKey) {
+		decoded, err := base64.StdEncoding.DecodeString(a)
+		if err == nil && bytes.IndexByte(decoded, ':') <= 0 {
+			err = errBasicAuthMissingSeparator
+		}
+
+		var h saltedPasswordHash
+		if err == nil {
+			sep := bytes.IndexByte(decoded, ':')
+			h, err = newSaltedPasswordHash(string(decoded[sep+1:]))
+			decoded = decoded[:sep]
+		}
+
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("invalid basic auth header [%d]: %w", i, err)
+			}
+
+			logger.Error("failed to decode auth header", zap.Int("index", i), zap.Error(err))
+			continue
+		}
+
+		settings.basicAllowed[string(decoded)] = h
+	}
+
+	var credentials BasicCredentialsConfig
+	if err := v.UnmarshalKey(basicCredentialsConfigKey, &credentials); err != nil {
+		return nil, err
+	}
+
+	if err := settings.addCredentials(credentials.Users, basicCredentialsConfigKey+".users", logger, strict); err != nil {
+		return nil, err
+	}
+
+	if len(credentials.File) > 0 {
+		entries, err := readBasicCredentialsFile(credentials.File)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := settings.addCredentials(entries, credentials.File, logger, strict); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Debug("Created list of allowed basic auths", zap.Int("count", len(settings.basicAllowed)))
+
+	for i, m := range credentials.Mappings {
+		if _, ok := settings.basicAllowed[m.User]; !ok {
+			if strict {
+				return nil, fmt.Errorf("basic auth mapping [%d] is for an unknown user", i)
+			}
+
+			logger.Error("skipping basic auth mapping for an unknown user", zap.Int("index", i))
+			continue
+		}
+
+		settings.basicClaims[m.User] = m.claims()
+	}
+
+	certificates, err := loadCertificateSettings(v, logger, strict)
+	if err != nil {
+		return nil, err
+	}
+
+	settings.certificates = certificates
+
+	var capabilityCheck CapabilityConfig
+	if err := v.UnmarshalKey(capabilityCheckConfigKey, &capabilityCheck); err != nil {
+		return nil, err
+	}
+
+	if capabilityCheck.Type != enforceCheck && capabilityCheck.Type != monitorCheck {
+		return settings, nil
+	}
+
+	ec, err := newEndpointRegexCheck(capabilityCheck.Prefix, capabilityCheck.AcceptAllMethod)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capability check: %w", err)
+	}
+
+	endpointBuckets := make([]*regexp.Regexp, 0, len(capabilityCheck.EndpointBuckets))
+	for _, pattern := range capabilityCheck.EndpointBuckets {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("failed to compile endpoint bucket regex [%s]: %w", pattern, err)
+			}
+
+			logger.Error("failed to compile endpoint bucket regex", zap.String("regex", pattern), zap.Error(err))
+			continue
+		}
+
+		endpointBuckets = append(endpointBuckets, re)
+	}
+
+	settings.capabilities = &capabilitySettings{
+		enforce:         capabilityCheck.Type == enforceCheck,
+		check:           ec,
+		endpointBuckets: endpointBuckets,
+	}
+
+	// an invalid policy is never skipped, since skipping it would disable the check
+	if len(capabilityCheck.PolicyFile) > 0 {
+		settings.capabilities.policy, err = loadCapabilityPolicy(capabilityCheck.PolicyFile, ec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return settings, nil
+}
+
+// addCredentials adds htpasswd style user:hash entries read from source.
+func (s *authSettings) addCredentials(entries []string, source string, logger *zap.Logger, strict bool) error {
+	for i, entry := range entries {
+		user, h, err := parseBasicCredential(entry)
+		if err != nil {
+			if strict {
+				return fmt.Errorf("invalid basic auth credential [%s %d]: %w", source, i, err)
+			}
+
+			logger.Error("failed to parse basic auth credential", zap.String("source", source), zap.Int("index", i), zap.Error(err))
+			continue
+		}
+
+		s.basicAllowed[user] = h
+	}
+
+	return nil
+}
+
+// authorize runs the capability check for a request.  Failures are counted, and only returned
+// as errors when the check is enforced.
+func (cs *capabilitySettings) authorize(request *http.Request, token bascule.Token, counter metrics.Counter) error {
+	if !carriesClaims(token) {
+		return nil
+	}
+
+	clientID := token.Principal()
+	requestPath := trimVersionPrefix(request.URL.EscapedPath())
+	endpointBucket := determineEndpointMetric(cs.endpointBuckets, requestPath)
+	failureOutcome := Accepted
+	if cs.enforce {
+		failureOutcome = Rejected
+	}
+
+	reportFailure := func(reason, partnerID string) error {
+		counter.With(
+			OutcomeLabel, failureOutcome,
+			ReasonLabel, reason,
+			ClientIDLabel, clientID,
+			PartnerIDLabel, partnerID,
+			EndpointLabel, endpointBucket,
+		).Add(1)
+
+		if cs.enforce {
+			return bascule.ErrUnauthorized
+		}
+
+		return nil
+	}
+
+	accessor, ok := token.(bascule.AttributesAccessor)
+	if !ok {
+		return reportFailure(UndeterminedCapabilities, "undetermined")
+	}
+
+	// nolint: goconst
+	partnerID := "none"
+	if partnerVal, ok := bascule.GetAttribute[any](accessor, partnerKeys...); ok {
+		if partners, err := cast.ToStringSliceE(partnerVal); err == nil {
+			partnerID = determinePartnerMetric(partners)
+		}
+	}
+
+	if cs.policy != nil {
+		decision := cs.policy.evaluate(newPolicyInput(token, request.Method, requestPath))
+
+		switch {
+		case decision.deferred:
+			// the decision is counted once the WRP messages are decoded
+			deferCapabilityPolicy(request.Context())
+			return nil
+
+		case !decision.allowed:
+			sallust.Get(request.Context()).Debug("capability policy denied request",
+				zap.String("rule", decision.rule), zap.Strings("explain", decision.explain))
+			return reportFailure(decision.reason(), partnerID)
+		}
+
+		recordCapability(request.Context(), decision.capability)
+		counter.With(
+			OutcomeLabel, Accepted,
+			ReasonLabel, "",
+			ClientIDLabel, clientID,
+			PartnerIDLabel, partnerID,
+			EndpointLabel, endpointBucket,
+		).Add(1)
+
+		return nil
+	}
+
+	rawCapabilities, ok := bascule.GetAttribute[any](accessor, "capabilities")
+	if !ok {
+		return reportFailure(UndeterminedCapabilities, partnerID)
+	}
+
+	capabilities, ok := bascule.GetCapabilities(rawCapabilities)
+	if !ok || len(capabilities) < 1 {
+		return reportFailure(EmptyCapabilitiesList, partnerID)
+	}
+
+	if capability, ok := cs.check.matcher(capabilities).match(requestPath, request.Method); ok {
+		recordCapability(request.Context(), capability)
+		counter.With(
+			OutcomeLabel, Accepted,
+			ReasonLabel, "",
+			ClientIDLabel, clientID,
+			PartnerIDLabel, partnerID,
+			EndpointLabel, endpointBucket,
+		).Add(1)
+
+		return nil
+	}
+
+	return reportFailure(NoCapabilitiesMatch, "undetermined")
+}
+
+// authReloader holds the live authSettings and swaps in new ones when the configuration is
+// reloaded.  An invalid configuration is rejected and the previous settings are kept.
+type authReloader struct {
+	v        *viper.Viper
+	logger   *zap.Logger
+	reloads  metrics.Counter
+	lock     sync.Mutex
+	settings atomic.Pointer[authSettings]
+}
+
+func newAuthReloader(v *viper.Viper, logger *zap.Logger, initial *authSettings, reloads metrics.Counter) *authReloader {
+	r := &authReloader{
+		v:       v,
+		logger:  logger,
+		reloads: reloads,
+	}
+
+	r.settings.Store(initial)
+	return r
+}
+
+// load returns the live settings.
+func (r *authReloader) load() *authSettings {
+	return r.settings.Load()
+}
+
+// reload rebuilds the settings from the current configuration and swaps them in.
+func (r *authReloader) reload() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	settings, err := r.validate()
+	if err != nil {
+		r.reloads.With(OutcomeLabel, Rejected).Add(1)
+		r.logger.Error("rejected auth configuration reload, keeping the previous configuration", zap.Error(err))
+		return err
+	}
+
+	r.settings.Store(settings)
+	r.reloads.With(OutcomeLabel, Accepted).Add(1)
+	r.logger.Info("reloaded auth configuration",
+		zap.Int("basicAuthUsers", len(settings.basicAllowed)),
+		zap.Bool("mtlsAuth", settings.certificates != nil),
+		zap.Bool("capabilityCheck", settings.capabilities != nil))
+	return nil
+}
+
+func (r *authReloader) validate() (*authSettings, error) {
+	return loadAuthSettings(r.v, r.logger, true)
+}
+
+// watch starts reloading the settings on configuration file changes and SIGHUP, as configured.
+func (r *authReloader) watch(cfg AuthReloadConfig) {
+	if cfg.WatchConfig {
+		r.v.OnConfigChange(func(e fsnotify.Event) {
+			r.logger.Info("configuration file changed", zap.String("file", e.Name))
+			// nolint:errcheck
+			r.reload()
+		})
+		r.v.WatchConfig()
+	}
+
+	if cfg.Signal {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		go func() {
+			for range sighup {
+				if err := r.v.ReadInConfig(); err != nil {
+					r.reloads.With(OutcomeLabel, Rejected).Add(1)
+					r.logger.Error("failed to read configuration on SIGHUP", zap.Error(err))
+					continue
+				}
+
+				// nolint:errcheck
+				r.reload()
+			}
+		}()
+	}
+}
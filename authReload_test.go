@@ -0,0 +1,310 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/bascule/basculehttp"
+	"go.uber.org/zap"
+)
+
+// assertBasicAllowed checks that exactly the expected users are allowed, with their passwords.
+func assertBasicAllowed(t *testing.T, expected map[string]string, actual map[string]passwordHash) {
+	t.Helper()
+	assert.Len(t, actual, len(expected))
+	for user, password := range expected {
+		if assert.Contains(t, actual, user) {
+			assert.True(t, actual[user].verify(password), "password of %s", user)
+		}
+	}
+}
+
+func TestLoadAuthSettings(t *testing.T) {
+	var (
+		valid      = base64.StdEncoding.EncodeToString([]byte("user:pass"))
+		noSep      = base64.StdEncoding.EncodeToString([]byte("userpass"))
+		capability = map[string]any{
+			"type":            "enforce",
+			"prefix":          "x1:webpa:api:",
+			"acceptAllMethod": "all",
+			"endpointBuckets": []string{"hook\\b", "("},
+		}
+	)
+
+	tests := []struct {
+		name                 string
+		config               map[string]any
+		strict               bool
+		expectedErr          bool
+		expectedBasicAllowed map[string]string
+		expectedBasicClaims  []string
+		expectedCapabilities bool
+		expectedBuckets      int
+	}{
+		{
+			name:                 "empty",
+			config:               map[string]any{},
+			expectedBasicAllowed: map[string]string{},
+		},
+		{
+			name: "lenient skips invalid entries",
+			config: map[string]any{
+				basicAuthConfigKey:       []string{valid, "%%%", noSep},
+				capabilityCheckConfigKey: capability,
+			},
+			expectedBasicAllowed: map[string]string{"user": "pass"},
+			expectedCapabilities: true,
+			expectedBuckets:      1,
+		},
+		{
+			name: "strict rejects invalid basic auth",
+			config: map[string]any{
+				basicAuthConfigKey: []string{valid, noSep},
+			},
+			strict:      true,
+			expectedErr: true,
+		},
+		{
+			name: "strict rejects invalid bucket",
+			config: map[string]any{
+				capabilityCheckConfigKey: capability,
+			},
+			strict:      true,
+			expectedErr: true,
+		},
+		{
+			name: "lenient skips mappings of unknown users",
+			config: map[string]any{
+				basicAuthConfigKey: []string{valid},
+				basicCredentialsConfigKey: map[string]any{
+					"mappings": []map[string]any{
+						{"user": "user", "partners": []string{"comcast"}},
+						{"user": "unknown", "partners": []string{"comcast"}},
+					},
+				},
+			},
+			expectedBasicAllowed: map[string]string{"user": "pass"},
+			expectedBasicClaims:  []string{"user"},
+		},
+		{
+			name: "strict rejects mappings of unknown users",
+			config: map[string]any{
+				basicCredentialsConfigKey: map[string]any{
+					"mappings": []map[string]any{{"user": "unknown"}},
+				},
+			},
+			strict:      true,
+			expectedErr: true,
+		},
+		{
+			name: "unknown capability check type",
+			config: map[string]any{
+				capabilityCheckConfigKey: map[string]any{"type": "other"},
+			},
+			strict:               true,
+			expectedBasicAllowed: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			v := viper.New()
+			for k, value := range tt.config {
+				v.Set(k, value)
+			}
+
+			settings, err := loadAuthSettings(v, zap.NewNop(), tt.strict)
+			if tt.expectedErr {
+				assert.Error(err)
+				assert.Nil(settings)
+				return
+			}
+
+			require.NoError(t, err)
+			assertBasicAllowed(t, tt.expectedBasicAllowed, settings.basicAllowed)
+			assert.ElementsMatch(tt.expectedBasicClaims, slices.Collect(maps.Keys(settings.basicClaims)))
+			if !tt.expectedCapabilities {
+				assert.Nil(settings.capabilities)
+				return
+			}
+
+			require.NotNil(t, settings.capabilities)
+			assert.True(settings.capabilities.enforce)
+			assert.Len(settings.capabilities.endpointBuckets, tt.expectedBuckets)
+		})
+	}
+}
+
+func TestAuthReloaderReload(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	v := viper.New()
+	v.Set(basicAuthConfigKey, []string{base64.StdEncoding.EncodeToString([]byte("user:pass"))})
+
+	initial, err := loadAuthSettings(v, zap.NewNop(), false)
+	require.NoError(err)
+
+	counter := newTestCounter()
+	auth := newAuthReloader(v, zap.NewNop(), initial, counter)
+	parser := reloadableBasicTokenParser{auth: auth}
+
+	_, err = parser.Parse(context.Background(), basculehttp.BasicAuth("user", "pass"))
+	assert.NoError(err)
+
+	// a valid configuration replaces the live settings
+	v.Set(basicAuthConfigKey, []string{base64.StdEncoding.EncodeToString([]byte("other:secret"))})
+	require.NoError(auth.reload())
+	assert.Equal(Accepted, counter.labelPairs[OutcomeLabel])
+
+	_, err = parser.Parse(context.Background(), basculehttp.BasicAuth("user", "pass"))
+	assert.True(errors.Is(err, bascule.ErrBadCredentials))
+	_, err = parser.Parse(context.Background(), basculehttp.BasicAuth("other", "secret"))
+	assert.NoError(err)
+
+	// an invalid configuration is rejected and the previous settings are kept
+	v.Set(basicAuthConfigKey, []string{"%%%"})
+	assert.Error(auth.reload())
+	assert.Equal(Rejected, counter.labelPairs[OutcomeLabel])
+	assert.Equal(float64(2), counter.count)
+
+	_, err = parser.Parse(context.Background(), basculehttp.BasicAuth("other", "secret"))
+	assert.NoError(err)
+
+	// basic auth may be combined with WRP PartnerID checks, and mapped users carry claims
+	v.Set(basicAuthConfigKey, []string{base64.StdEncoding.EncodeToString([]byte("user:pass"))})
+	v.Set(wrpCheckConfigKey, map[string]any{"type": "enforce"})
+	v.Set(basicCredentialsConfigKey, map[string]any{
+		"mappings": []map[string]any{{"user": "user", "partners": []string{"comcast"}}},
+	})
+	require.NoError(auth.reload())
+
+	token, err := parser.Parse(context.Background(), basculehttp.BasicAuth("user", "pass"))
+	require.NoError(err)
+	assert.True(carriesClaims(token))
+
+	// mappings of unknown users are rejected
+	v.Set(basicCredentialsConfigKey, map[string]any{
+		"mappings": []map[string]any{{"user": "unknown", "partners": []string{"comcast"}}},
+	})
+	assert.Error(auth.reload())
+	assert.Contains(auth.load().basicClaims, "user")
+}
+
+func TestCapabilitySettingsAuthorize(t *testing.T) {
+	ec, err := newEndpointRegexCheck("x1:webpa:api:", "all")
+	require.NoError(t, err)
+
+	h, err := newSaltedPasswordHash("pass")
+	require.NoError(t, err)
+
+	basicToken, err := basicAllowedTokenParser{allowed: map[string]passwordHash{"user": h}}.Parse(context.Background(), basculehttp.BasicAuth("user", "pass"))
+	require.NoError(t, err)
+
+	mappedToken, err := basicAllowedTokenParser{
+		allowed: map[string]passwordHash{"user": h},
+		claims: map[string]map[string]any{
+			"user": BasicUserMapping{User: "user", Partners: []string{"comcast"}, Capabilities: []string{"x1:webpa:api:device/.*/config:all"}}.claims(),
+		},
+	}.Parse(context.Background(), basculehttp.BasicAuth("user", "pass"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		enforce         bool
+		token           bascule.Token
+		expectedErr     error
+		expectedCount   float64
+		expectedOutcome string
+		expectedReason  string
+		expectedPartner string
+	}{
+		{
+			name:  "not a jwt",
+			token: basicToken,
+		},
+		{
+			name:            "mapped basic user",
+			enforce:         true,
+			token:           mappedToken,
+			expectedCount:   1,
+			expectedOutcome: Accepted,
+			expectedPartner: "comcast",
+		},
+		{
+			name:    "authorized",
+			enforce: true,
+			token: &jwtToken{
+				principal: "client",
+				claims: map[string]any{
+					"allowedResources": map[string]any{"allowedPartners": []string{"comcast"}},
+					"capabilities":     []string{"x1:webpa:api:device/.*/config:all"},
+				},
+			},
+			expectedCount:   1,
+			expectedOutcome: Accepted,
+			expectedPartner: "comcast",
+		},
+		{
+			name:    "enforced without capabilities",
+			enforce: true,
+			token: &jwtToken{
+				principal: "client",
+				claims:    map[string]any{},
+			},
+			expectedErr:     bascule.ErrUnauthorized,
+			expectedCount:   1,
+			expectedOutcome: Rejected,
+			expectedReason:  UndeterminedCapabilities,
+			expectedPartner: "none",
+		},
+		{
+			name: "monitored with no matching capability",
+			token: &jwtToken{
+				principal: "client",
+				claims: map[string]any{
+					"capabilities": []string{"x1:webpa:api:hook:all"},
+				},
+			},
+			expectedCount:   1,
+			expectedOutcome: Accepted,
+			expectedReason:  NoCapabilitiesMatch,
+			expectedPartner: "undetermined",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			cs := &capabilitySettings{
+				enforce: tt.enforce,
+				check:   ec,
+			}
+
+			counter := newTestCounter()
+			request := httptest.NewRequest(http.MethodGet, "/api/v3/device/mac:112233445566/config", nil)
+			err := cs.authorize(request, tt.token, counter)
+			assert.Equal(tt.expectedErr, err)
+			assert.Equal(tt.expectedCount, counter.count)
+			if tt.expectedCount > 0 {
+				assert.Equal(tt.expectedOutcome, counter.labelPairs[OutcomeLabel])
+				assert.Equal(tt.expectedReason, counter.labelPairs[ReasonLabel])
+				assert.Equal(tt.expectedPartner, counter.labelPairs[PartnerIDLabel])
+			}
+		})
+	}
+}
@@ -5,19 +5,31 @@ package main
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"fmt"
 	"regexp"
 	"strings"
 
+	"github.com/go-kit/kit/metrics"
 	jwtv4 "github.com/golang-jwt/jwt/v4"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/xmidt-org/bascule"
 	"github.com/xmidt-org/bascule/basculehttp"
 	"github.com/xmidt-org/clortho"
 	gozap "go.uber.org/zap"
 )
 
-const jwtTokenType = "jwt"
+const (
+	jwtTokenType   = "jwt"
+	basicTokenType = "basic"
+)
+
+// defaultJWTAlgorithms are the signing algorithms accepted when jwtValidator.algorithms is not
+// configured.  The RSA PSS, ECDSA and EdDSA algorithms have to be opted in to.
+var defaultJWTAlgorithms = []string{"RS256", "RS384", "RS512"}
 
 type tokenType interface {
 	TokenType() string
@@ -49,6 +61,30 @@ type jwtTokenParser struct {
 	resolver clortho.Resolver
 	logger   *gozap.Logger
 	leeway   Leeway
+
+	// algorithms are the accepted signing algorithms, as returned by newJWTAlgorithms
+	algorithms []string
+	issuers    *claimMatcher
+	audiences  *claimMatcher
+	failures   metrics.Counter
+}
+
+// newJWTAlgorithms returns the signing algorithms to accept for JWTs.  Only asymmetric algorithms
+// whose keys can be served through clortho are allowed.
+func newJWTAlgorithms(configured []string) ([]string, error) {
+	if len(configured) == 0 {
+		return defaultJWTAlgorithms, nil
+	}
+
+	for _, alg := range configured {
+		switch jwtv4.GetSigningMethod(alg).(type) {
+		case *jwtv4.SigningMethodRSA, *jwtv4.SigningMethodRSAPSS, *jwtv4.SigningMethodECDSA, *jwtv4.SigningMethodEd25519:
+		default:
+			return nil, fmt.Errorf("unsupported JWT signing algorithm [%s]", alg)
+		}
+	}
+
+	return configured, nil
 }
 
 func (jtp *jwtTokenParser) Parse(ctx context.Context, raw string) (bascule.Token, error) {
@@ -57,12 +93,8 @@ func (jtp *jwtTokenParser) Parse(ctx context.Context, raw string) (bascule.Token
 	}
 
 	claims := jwtv4.MapClaims{}
-	parser := jwtv4.Parser{SkipClaimsValidation: true}
+	parser := jwtv4.Parser{ValidMethods: jtp.algorithms, SkipClaimsValidation: true}
 	token, err := parser.ParseWithClaims(raw, claims, func(token *jwtv4.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwtv4.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
 		keyID, ok := token.Header["kid"].(string)
 		if !ok {
 			return nil, fmt.Errorf("missing or invalid key ID in JWT header")
@@ -73,22 +105,7 @@ func (jtp *jwtTokenParser) Parse(ctx context.Context, raw string) (bascule.Token
 			return nil, fmt.Errorf("failed to resolve JWT signing key: %w", err)
 		}
 
-		var publicKey interface{}
-		switch k := clorthoKey.(type) {
-		case interface{ PublicKey() *rsa.PublicKey }:
-			publicKey = k.PublicKey()
-		case interface{ Key() interface{} }:
-			publicKey = k.Key()
-		default:
-			return nil, fmt.Errorf("unsupported key type: %T", clorthoKey)
-		}
-
-		rsaKey, ok := publicKey.(*rsa.PublicKey)
-		if !ok {
-			return nil, fmt.Errorf("expected RSA public key, got %T", publicKey)
-		}
-
-		return rsaKey, nil
+		return verificationKey(token.Method, clorthoKey)
 	})
 
 	if err != nil {
@@ -102,12 +119,8 @@ func (jtp *jwtTokenParser) Parse(ctx context.Context, raw string) (bascule.Token
 		return nil, bascule.ErrBadCredentials
 	}
 
-	if err := validateTimeClaimsWithLeeway(claims, jtp.leeway); err != nil {
-		if jtp.logger != nil {
-			jtp.logger.Error("JWT temporal claim validation failed", gozap.Error(err))
-		}
-
-		return nil, bascule.ErrBadCredentials
+	if err := jtp.validateClaims(claims); err != nil {
+		return nil, err
 	}
 
 	parsedClaims, ok := token.Claims.(jwtv4.MapClaims)
@@ -132,6 +145,165 @@ func (jtp *jwtTokenParser) Parse(ctx context.Context, raw string) (bascule.Token
 	return &jwtToken{principal: principal, claims: claimsMap}, nil
 }
 
+// validateClaims checks the temporal, issuer and audience claims of a validly signed JWT.
+func (jtp *jwtTokenParser) validateClaims(claims jwtv4.MapClaims) error {
+	if err := validateTimeClaimsWithLeeway(claims, jtp.leeway); err != nil {
+		if jtp.logger != nil {
+			jtp.logger.Error("JWT temporal claim validation failed", gozap.Error(err))
+		}
+
+		jtp.reportFailure(JWTTimeClaimsInvalid)
+		return bascule.ErrBadCredentials
+	}
+
+	if jtp.issuers != nil {
+		issuer, _ := claims["iss"].(string)
+		if !jtp.issuers.matches(issuer) {
+			if jtp.logger != nil {
+				jtp.logger.Error("JWT issuer not allowed", gozap.String("issuer", issuer))
+			}
+
+			jtp.reportFailure(JWTIssuerMismatch)
+			return bascule.ErrBadCredentials
+		}
+	}
+
+	if jtp.audiences != nil {
+		audiences := audienceClaim(claims)
+		if !jtp.audiences.matchesAny(audiences) {
+			if jtp.logger != nil {
+				jtp.logger.Error("JWT audience not allowed", gozap.Strings("audiences", audiences))
+			}
+
+			jtp.reportFailure(JWTAudienceMismatch)
+			return bascule.ErrBadCredentials
+		}
+	}
+
+	return nil
+}
+
+func (jtp *jwtTokenParser) reportFailure(reason string) {
+	if jtp.failures != nil {
+		jtp.failures.With(ReasonLabel, reason).Add(1)
+	}
+}
+
+// audienceClaim returns the aud claim, which may be either a single string or a list of strings.
+func audienceClaim(claims jwtv4.MapClaims) []string {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []string:
+		return aud
+	case []interface{}:
+		audiences := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+
+		return audiences
+	}
+
+	return nil
+}
+
+// claimMatcher matches JWT claim values against a list of patterns.  Patterns without
+// a '*' must match exactly, while a '*' in a pattern matches any sequence of characters.
+type claimMatcher struct {
+	exact map[string]bool
+	globs []*regexp.Regexp
+}
+
+// newClaimMatcher returns nil when there are no patterns, meaning the claim is not checked.
+func newClaimMatcher(patterns []string) (*claimMatcher, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	m := &claimMatcher{exact: make(map[string]bool)}
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "*") {
+			m.exact[pattern] = true
+			continue
+		}
+
+		re, err := regexp.Compile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$")
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile claim pattern [%v]: %w", pattern, err)
+		}
+
+		m.globs = append(m.globs, re)
+	}
+
+	return m, nil
+}
+
+func (m *claimMatcher) matches(value string) bool {
+	if value == "" {
+		return false
+	}
+
+	if m.exact[value] {
+		return true
+	}
+
+	for _, re := range m.globs {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *claimMatcher) matchesAny(values []string) bool {
+	for _, v := range values {
+		if m.matches(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verificationKey extracts the public key from a resolved clortho key and checks that its type
+// matches the JWT's signing method.
+func verificationKey(method jwtv4.SigningMethod, clorthoKey interface{}) (interface{}, error) {
+	var publicKey interface{}
+	switch k := clorthoKey.(type) {
+	case interface{ PublicKey() *rsa.PublicKey }:
+		publicKey = k.PublicKey()
+	case interface{ Public() crypto.PublicKey }:
+		publicKey = k.Public()
+	case interface{ Key() interface{} }:
+		publicKey = k.Key()
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", clorthoKey)
+	}
+
+	switch method.(type) {
+	case *jwtv4.SigningMethodRSA, *jwtv4.SigningMethodRSAPSS:
+		if rsaKey, ok := publicKey.(*rsa.PublicKey); ok {
+			return rsaKey, nil
+		}
+	case *jwtv4.SigningMethodECDSA:
+		if ecdsaKey, ok := publicKey.(*ecdsa.PublicKey); ok {
+			return ecdsaKey, nil
+		}
+	case *jwtv4.SigningMethodEd25519:
+		if edKey, ok := publicKey.(ed25519.PublicKey); ok {
+			return edKey, nil
+		}
+	default:
+		return nil, fmt.Errorf("unexpected signing method: %v", method.Alg())
+	}
+
+	return nil, fmt.Errorf("key type %T does not match signing method %v", publicKey, method.Alg())
+}
+
 func validateTimeClaimsWithLeeway(claims jwtv4.MapClaims, leeway Leeway) error {
 	now := jwtv4.TimeFunc().Unix()
 
@@ -150,8 +322,47 @@ func validateTimeClaimsWithLeeway(claims jwtv4.MapClaims, leeway Leeway) error {
 	return nil
 }
 
+// tokenTypeOf returns the type of the token: jwt, basic, or the type it reports.
+func tokenTypeOf(token bascule.Token) string {
+	if tt, ok := token.(tokenType); ok {
+		return tt.TokenType()
+	}
+
+	if _, ok := token.(basculehttp.BasicToken); ok {
+		return basicTokenType
+	}
+
+	return ""
+}
+
+// carriesClaims reports whether the token carries partner and capability claims: JWTs, client
+// certificates, and the basic auth tokens of mapped users.
+func carriesClaims(token bascule.Token) bool {
+	if _, ok := token.(*mappedBasicToken); ok {
+		return true
+	}
+
+	tt, ok := token.(tokenType)
+	return ok && (tt.TokenType() == jwtTokenType || tt.TokenType() == mtlsTokenType)
+}
+
+// mappedBasicToken is the basic auth token of a user mapped to allowed partners and capabilities,
+// which are exposed as the claims a JWT would carry.
+type mappedBasicToken struct {
+	basculehttp.BasicToken
+	claims map[string]any
+}
+
+func (t *mappedBasicToken) Get(key string) (any, bool) {
+	value, ok := t.claims[key]
+	return value, ok
+}
+
+// basicAllowedTokenParser accepts the basic auth credentials whose passwords match the allowed hashes.
+// The tokens of users with claims are mappedBasicTokens.
 type basicAllowedTokenParser struct {
-	allowed map[string]string
+	allowed map[string]passwordHash
+	claims  map[string]map[string]any
 }
 
 func (batp basicAllowedTokenParser) Parse(ctx context.Context, raw string) (bascule.Token, error) {
@@ -165,17 +376,46 @@ func (batp basicAllowedTokenParser) Parse(ctx context.Context, raw string) (basc
 		return nil, bascule.ErrInvalidCredentials
 	}
 
-	expectedPassword, ok := batp.allowed[basicToken.UserName()]
-	if !ok || basicToken.Password() != expectedPassword {
+	expected, ok := batp.allowed[basicToken.UserName()]
+	if !ok || !expected.verify(basicToken.Password()) {
 		return nil, bascule.ErrBadCredentials
 	}
 
+	if claims, ok := batp.claims[basicToken.UserName()]; ok {
+		return &mappedBasicToken{BasicToken: basicToken, claims: claims}, nil
+	}
+
 	return token, nil
 }
 
+// reloadableBasicTokenParser checks basic auth credentials against the live authSettings.
+type reloadableBasicTokenParser struct {
+	auth *authReloader
+}
+
+func (p reloadableBasicTokenParser) Parse(ctx context.Context, raw string) (bascule.Token, error) {
+	settings := p.auth.load()
+	return basicAllowedTokenParser{allowed: settings.basicAllowed, claims: settings.basicClaims}.Parse(ctx, raw)
+}
+
+const (
+	// capabilityPatternCacheSize bounds the number of parsed capabilities kept by an
+	// endpointRegexCheck.
+	capabilityPatternCacheSize = 10000
+
+	// capabilityMatcherCacheSize bounds the number of capability lists whose matchers are kept
+	// by an endpointRegexCheck.
+	capabilityMatcherCacheSize = 1000
+)
+
+// endpointRegexCheck authorizes requests with {prefix}{endpoint}:{method} capabilities.  Parsed
+// capabilities and the matchers of whole capability lists are cached, so that the endpoint
+// regexes are not compiled on every request.  The zero value authorizes nothing.
 type endpointRegexCheck struct {
 	prefixToMatch   *regexp.Regexp
 	acceptAllMethod string
+	patterns        *lru.Cache
+	matchers        *lru.Cache
 }
 
 func newEndpointRegexCheck(prefix, acceptAllMethod string) (endpointRegexCheck, error) {
@@ -184,34 +424,236 @@ func newEndpointRegexCheck(prefix, acceptAllMethod string) (endpointRegexCheck,
 		return endpointRegexCheck{}, fmt.Errorf("failed to compile prefix [%v]: %w", prefix, err)
 	}
 
+	patterns, err := lru.New(capabilityPatternCacheSize)
+	if err != nil {
+		return endpointRegexCheck{}, err
+	}
+
+	matchers, err := lru.New(capabilityMatcherCacheSize)
+	if err != nil {
+		return endpointRegexCheck{}, err
+	}
+
 	return endpointRegexCheck{
 		prefixToMatch:   matchPrefix,
 		acceptAllMethod: acceptAllMethod,
+		patterns:        patterns,
+		matchers:        matchers,
 	}, nil
 }
 
+// capabilityPattern is a parsed capability.  Endpoints without regex metacharacters are matched
+// as literal prefixes.
+type capabilityPattern struct {
+	method   string
+	literal  string
+	endpoint *regexp.Regexp
+}
+
+// matches reports whether the normalized path starts with the capability's endpoint.
+func (p *capabilityPattern) matches(path string) bool {
+	if p.endpoint == nil {
+		return strings.HasPrefix(path, p.literal)
+	}
+
+	return p.endpoint.MatchString(path)
+}
+
+// pattern parses a capability.  It returns nil for capabilities that authorize nothing: those
+// without the prefix and method, and those with an invalid endpoint regex.
+func (r endpointRegexCheck) pattern(capability string) *capabilityPattern {
+	if r.prefixToMatch == nil {
+		return nil
+	}
+
+	if r.patterns != nil {
+		if cached, ok := r.patterns.Get(capability); ok {
+			return cached.(*capabilityPattern)
+		}
+	}
+
+	var p *capabilityPattern
+	if matches := r.prefixToMatch.FindStringSubmatch(capability); len(matches) >= 3 {
+		endpoint := urlPathNormalization(matches[1])
+		if regexp.QuoteMeta(endpoint) == endpoint {
+			p = &capabilityPattern{method: matches[2], literal: endpoint}
+		} else if re, err := regexp.Compile("^(?:" + endpoint + ")"); err == nil {
+			p = &capabilityPattern{method: matches[2], endpoint: re}
+		}
+	}
+
+	if r.patterns != nil {
+		r.patterns.Add(capability, p)
+	}
+
+	return p
+}
+
 func (r endpointRegexCheck) authorized(capability, urlToMatch, methodToMatch string) bool {
-	matches := r.prefixToMatch.FindStringSubmatch(capability)
-	if len(matches) == 0 || len(matches) < 3 {
+	p := r.pattern(capability)
+	if p == nil {
 		return false
 	}
 
-	method := matches[2]
-	if method != r.acceptAllMethod && method != strings.ToLower(methodToMatch) {
+	if p.method != r.acceptAllMethod && p.method != strings.ToLower(methodToMatch) {
 		return false
 	}
 
-	re, err := regexp.Compile(urlPathNormalization(matches[1]))
-	if err != nil {
-		return false
+	return p.matches(urlPathNormalization(urlToMatch))
+}
+
+// matcher returns the matcher of a token's capabilities.
+func (r endpointRegexCheck) matcher(capabilities []string) *capabilityMatcher {
+	key := strings.Join(capabilities, "\n")
+	if r.matchers != nil {
+		if cached, ok := r.matchers.Get(key); ok {
+			return cached.(*capabilityMatcher)
+		}
 	}
 
-	matchIdxs := re.FindStringIndex(urlPathNormalization(urlToMatch))
-	if matchIdxs == nil || matchIdxs[0] != 0 {
-		return false
+	m := &capabilityMatcher{
+		capabilities:    capabilities,
+		acceptAllMethod: r.acceptAllMethod,
+		methods:         make(map[string]*capabilityBucket),
+	}
+
+	for i, capability := range capabilities {
+		p := r.pattern(capability)
+		if p == nil {
+			continue
+		}
+
+		bucket, ok := m.methods[p.method]
+		if !ok {
+			bucket = &capabilityBucket{literals: new(literalTrie)}
+			m.methods[p.method] = bucket
+		}
+
+		bucket.add(i, p)
+	}
+
+	if r.matchers != nil {
+		r.matchers.Add(key, m)
+	}
+
+	return m
+}
+
+// capabilityMatcher finds the first of a token's capabilities that authorizes a request.  The
+// capabilities are bucketed by method, and the literal endpoints of each bucket are kept in a
+// trie, so that only the regex endpoints are matched one by one.
+type capabilityMatcher struct {
+	capabilities    []string
+	acceptAllMethod string
+	methods         map[string]*capabilityBucket
+}
+
+// match returns the first capability that authorizes the method on the path.
+func (m *capabilityMatcher) match(path, method string) (string, bool) {
+	path = urlPathNormalization(path)
+	first := len(m.capabilities)
+	if bucket, ok := m.methods[strings.ToLower(method)]; ok {
+		first = bucket.match(path, first)
+	}
+
+	if bucket, ok := m.methods[m.acceptAllMethod]; ok {
+		first = bucket.match(path, first)
 	}
 
-	return true
+	if first == len(m.capabilities) {
+		return "", false
+	}
+
+	return m.capabilities[first], true
+}
+
+type indexedPattern struct {
+	index   int
+	pattern *capabilityPattern
+}
+
+// capabilityBucket holds the capabilities of one method, by their position in the token.
+type capabilityBucket struct {
+	literals *literalTrie
+	regexes  []indexedPattern
+}
+
+func (b *capabilityBucket) add(index int, p *capabilityPattern) {
+	if p.endpoint == nil {
+		b.literals.add(p.literal, index)
+		return
+	}
+
+	b.regexes = append(b.regexes, indexedPattern{index: index, pattern: p})
+}
+
+// match returns the position of the bucket's first capability that matches the path, if it is
+// before first, or first otherwise.
+func (b *capabilityBucket) match(path string, first int) int {
+	first = b.literals.match(path, first)
+	for _, r := range b.regexes {
+		if r.index >= first {
+			break
+		}
+
+		if r.pattern.matches(path) {
+			return r.index
+		}
+	}
+
+	return first
+}
+
+// literalTrie is a byte trie of literal endpoints, holding the position of the first capability
+// of each endpoint.
+type literalTrie struct {
+	index    int
+	terminal bool
+	children map[byte]*literalTrie
+}
+
+func (t *literalTrie) add(literal string, index int) {
+	node := t
+	for i := 0; i < len(literal); i++ {
+		child, ok := node.children[literal[i]]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[byte]*literalTrie)
+			}
+
+			child = new(literalTrie)
+			node.children[literal[i]] = child
+		}
+
+		node = child
+	}
+
+	if !node.terminal {
+		node.terminal = true
+		node.index = index
+	}
+}
+
+// match returns the position of the first capability whose endpoint is a prefix of the path, if
+// it is before first, or first otherwise.
+func (t *literalTrie) match(path string, first int) int {
+	node := t
+	for i := 0; ; i++ {
+		if node.terminal && node.index < first {
+			first = node.index
+		}
+
+		if i == len(path) {
+			return first
+		}
+
+		child, ok := node.children[path[i]]
+		if !ok {
+			return first
+		}
+
+		node = child
+	}
 }
 
 func urlPathNormalization(url string) string {
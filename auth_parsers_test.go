@@ -5,7 +5,14 @@ package main
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"errors"
+	"fmt"
 	"regexp"
 	"testing"
 	"time"
@@ -144,6 +151,252 @@ func TestValidateTimeClaimsWithLeeway(t *testing.T) {
 	}
 }
 
+func TestNewJWTAlgorithms(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured []string
+		expected   []string
+		shouldErr  bool
+	}{
+		{
+			name:     "default algorithms",
+			expected: []string{"RS256", "RS384", "RS512"},
+		},
+		{
+			name:       "configured algorithms",
+			configured: []string{"ES256", "EdDSA"},
+			expected:   []string{"ES256", "EdDSA"},
+		},
+		{
+			name:       "symmetric algorithm",
+			configured: []string{"ES256", "HS256"},
+			shouldErr:  true,
+		},
+		{
+			name:       "none algorithm",
+			configured: []string{"none"},
+			shouldErr:  true,
+		},
+		{
+			name:       "unknown algorithm",
+			configured: []string{"XX256"},
+			shouldErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			algorithms, err := newJWTAlgorithms(tt.configured)
+			if tt.shouldErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, algorithms)
+		})
+	}
+}
+
+type testPublicKey struct {
+	public crypto.PublicKey
+}
+
+func (k testPublicKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+func TestVerificationKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		method    jwtv4.SigningMethod
+		key       interface{}
+		expected  interface{}
+		shouldErr bool
+	}{
+		{
+			name:     "rsa",
+			method:   jwtv4.SigningMethodRS256,
+			key:      testPublicKey{public: &rsaKey.PublicKey},
+			expected: &rsaKey.PublicKey,
+		},
+		{
+			name:     "rsa pss",
+			method:   jwtv4.SigningMethodPS256,
+			key:      testPublicKey{public: &rsaKey.PublicKey},
+			expected: &rsaKey.PublicKey,
+		},
+		{
+			name:     "ecdsa",
+			method:   jwtv4.SigningMethodES256,
+			key:      testPublicKey{public: &ecdsaKey.PublicKey},
+			expected: &ecdsaKey.PublicKey,
+		},
+		{
+			name:     "eddsa",
+			method:   jwtv4.SigningMethodEdDSA,
+			key:      testPublicKey{public: edPublic},
+			expected: edPublic,
+		},
+		{
+			name:      "key type mismatch",
+			method:    jwtv4.SigningMethodES256,
+			key:       testPublicKey{public: &rsaKey.PublicKey},
+			shouldErr: true,
+		},
+		{
+			name:      "symmetric method",
+			method:    jwtv4.SigningMethodHS256,
+			key:       testPublicKey{public: &rsaKey.PublicKey},
+			shouldErr: true,
+		},
+		{
+			name:      "unsupported key",
+			method:    jwtv4.SigningMethodRS256,
+			key:       "not a key",
+			shouldErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := verificationKey(tt.method, tt.key)
+			if tt.shouldErr {
+				require.Error(t, err)
+				assert.Nil(t, key)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, key)
+		})
+	}
+}
+
+func TestClaimMatcher(t *testing.T) {
+	m, err := newClaimMatcher([]string{"https://issuer.example.com", "https://*.prod.example.com/keys"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		value    string
+		expected bool
+	}{
+		{name: "exact match", value: "https://issuer.example.com", expected: true},
+		{name: "glob match", value: "https://east.prod.example.com/keys", expected: true},
+		{name: "glob mismatch", value: "https://east.stage.example.com/keys", expected: false},
+		{name: "no partial exact match", value: "https://issuer.example.com/other", expected: false},
+		{name: "empty value", value: "", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, m.matches(tt.value))
+		})
+	}
+
+	none, err := newClaimMatcher(nil)
+	require.NoError(t, err)
+	assert.Nil(t, none)
+}
+
+func TestJWTTokenParserValidateClaims(t *testing.T) {
+	issuers, err := newClaimMatcher([]string{"https://issuer.example.com"})
+	require.NoError(t, err)
+
+	audiences, err := newClaimMatcher([]string{"scytale-*"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		claims         jwtv4.MapClaims
+		expectedErr    error
+		expectedReason string
+	}{
+		{
+			name: "valid claims",
+			claims: jwtv4.MapClaims{
+				"iss": "https://issuer.example.com",
+				"aud": "scytale-prod",
+			},
+		},
+		{
+			name: "audience list",
+			claims: jwtv4.MapClaims{
+				"iss": "https://issuer.example.com",
+				"aud": []interface{}{"other", "scytale-prod"},
+			},
+		},
+		{
+			name: "expired",
+			claims: jwtv4.MapClaims{
+				"iss": "https://issuer.example.com",
+				"aud": "scytale-prod",
+				"exp": float64(1),
+			},
+			expectedErr:    bascule.ErrBadCredentials,
+			expectedReason: JWTTimeClaimsInvalid,
+		},
+		{
+			name: "wrong issuer",
+			claims: jwtv4.MapClaims{
+				"iss": "https://staging.example.com",
+				"aud": "scytale-prod",
+			},
+			expectedErr:    bascule.ErrBadCredentials,
+			expectedReason: JWTIssuerMismatch,
+		},
+		{
+			name: "missing issuer",
+			claims: jwtv4.MapClaims{
+				"aud": "scytale-prod",
+			},
+			expectedErr:    bascule.ErrBadCredentials,
+			expectedReason: JWTIssuerMismatch,
+		},
+		{
+			name: "wrong audience",
+			claims: jwtv4.MapClaims{
+				"iss": "https://issuer.example.com",
+				"aud": []interface{}{"talaria"},
+			},
+			expectedErr:    bascule.ErrBadCredentials,
+			expectedReason: JWTAudienceMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := newTestCounter()
+			parser := &jwtTokenParser{
+				issuers:   issuers,
+				audiences: audiences,
+				failures:  counter,
+			}
+
+			err := parser.validateClaims(tt.claims)
+			if tt.expectedErr == nil {
+				require.NoError(t, err)
+				assert.Zero(t, counter.count)
+				return
+			}
+
+			assert.ErrorIs(t, err, tt.expectedErr)
+			assert.Equal(t, float64(1), counter.count)
+			assert.Equal(t, tt.expectedReason, counter.labelPairs[ReasonLabel])
+		})
+	}
+}
+
 func TestBasicAllowedTokenParserParse(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -189,7 +442,13 @@ func TestBasicAllowedTokenParserParse(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			parser := basicAllowedTokenParser{allowed: tt.allowed}
+			parser := basicAllowedTokenParser{allowed: make(map[string]passwordHash, len(tt.allowed))}
+			for user, password := range tt.allowed {
+				h, err := newSaltedPasswordHash(password)
+				require.NoError(t, err)
+				parser.allowed[user] = h
+			}
+
 			token, err := parser.Parse(context.Background(), tt.raw)
 			if tt.expectedErr != nil {
 				require.Error(t, err)
@@ -385,3 +644,165 @@ func TestDetermineEndpointMetric(t *testing.T) {
 		})
 	}
 }
+
+func TestEndpointRegexCheckCaches(t *testing.T) {
+	assert := assert.New(t)
+	checker, err := newEndpointRegexCheck("perm:", "all")
+	require.NoError(t, err)
+
+	p := checker.pattern("perm:device/.*/stat:get")
+	require.NotNil(t, p)
+	assert.Same(p, checker.pattern("perm:device/.*/stat:get"))
+	assert.Nil(checker.pattern("perm:[invalid:get"))
+	assert.Nil(checker.pattern("other:device:get"))
+
+	literal := checker.pattern("perm:hook:post")
+	require.NotNil(t, literal)
+	assert.Nil(literal.endpoint)
+	assert.Equal("/hook", literal.literal)
+
+	capabilities := []string{"perm:hook:post", "perm:device/.*/stat:get"}
+	assert.Same(checker.matcher(capabilities), checker.matcher(capabilities))
+
+	var zero endpointRegexCheck
+	assert.False(zero.authorized("perm:hook:post", "/hook", "POST"))
+	_, ok := zero.matcher(capabilities).match("/hook", "POST")
+	assert.False(ok)
+}
+
+func TestCapabilityMatcherMatch(t *testing.T) {
+	checker, err := newEndpointRegexCheck("perm:", "all")
+	require.NoError(t, err)
+
+	capabilities := []string{
+		"perm:[invalid:get",
+		"perm:device/.*/config:put",
+		"perm:hooks:get",
+		"perm:hook:all",
+		"perm:device/.*/stat:get",
+		"perm:device/mac:112233445566/stat:get",
+		"perm:device:post",
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		method   string
+		expected string
+	}{
+		{
+			name:     "regex",
+			path:     "/device/mac:112233445566/config",
+			method:   "PUT",
+			expected: "perm:device/.*/config:put",
+		},
+		{
+			name:     "first of a regex and a literal",
+			path:     "/device/mac:112233445566/stat",
+			method:   "GET",
+			expected: "perm:device/.*/stat:get",
+		},
+		{
+			name:     "literal prefix",
+			path:     "/hooks",
+			method:   "GET",
+			expected: "perm:hooks:get",
+		},
+		{
+			name:     "accept all method before a literal",
+			path:     "hook",
+			method:   "DELETE",
+			expected: "perm:hook:all",
+		},
+		{
+			name:     "literal in the method bucket",
+			path:     "/device",
+			method:   "POST",
+			expected: "perm:device:post",
+		},
+		{
+			name:   "method mismatch",
+			path:   "/device/mac:112233445566/config",
+			method: "GET",
+		},
+		{
+			name:   "endpoint mismatch",
+			path:   "/other",
+			method: "GET",
+		},
+	}
+
+	m := checker.matcher(capabilities)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, ok := m.match(tt.path, tt.method)
+			assert.Equal(t, len(tt.expected) > 0, ok)
+			assert.Equal(t, tt.expected, actual)
+
+			// the matcher finds the same capability as checking them one by one
+			var expected string
+			for _, capability := range capabilities {
+				if checker.authorized(capability, tt.path, tt.method) {
+					expected = capability
+					break
+				}
+			}
+
+			assert.Equal(t, expected, actual)
+		})
+	}
+}
+
+// benchmarkCapabilities returns the capabilities of a large token, of which only the last
+// authorizes GETs of device stats.
+func benchmarkCapabilities() []string {
+	capabilities := make([]string, 0, 60)
+	for i := range 30 {
+		capabilities = append(capabilities, fmt.Sprintf("x1:webpa:api:hook/%d:all", i))
+		capabilities = append(capabilities, fmt.Sprintf("x1:webpa:api:device/.*/config/%d:put", i))
+	}
+
+	return append(capabilities, "x1:webpa:api:device/.*/stat:get")
+}
+
+func BenchmarkCapabilityCheck(b *testing.B) {
+	checker, err := newEndpointRegexCheck("x1:webpa:api:", "all")
+	require.NoError(b, err)
+
+	capabilities := benchmarkCapabilities()
+	authorizedOneByOne := func(check endpointRegexCheck) bool {
+		for _, capability := range capabilities {
+			if check.authorized(capability, "/device/mac:112233445566/stat", "GET") {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	b.Run("uncached", func(b *testing.B) {
+		// without its caches, every endpoint regex is compiled on every check
+		uncached := endpointRegexCheck{prefixToMatch: checker.prefixToMatch, acceptAllMethod: checker.acceptAllMethod}
+		for b.Loop() {
+			if !authorizedOneByOne(uncached) {
+				b.Fatal("not authorized")
+			}
+		}
+	})
+
+	b.Run("cached patterns", func(b *testing.B) {
+		for b.Loop() {
+			if !authorizedOneByOne(checker) {
+				b.Fatal("not authorized")
+			}
+		}
+	})
+
+	b.Run("matcher", func(b *testing.B) {
+		for b.Loop() {
+			if _, ok := checker.matcher(capabilities).match("/device/mac:112233445566/stat", "GET"); !ok {
+				b.Fatal("not authorized")
+			}
+		}
+	})
+}
@@ -0,0 +1,468 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/spf13/pflag"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+const (
+	// authzExplainPath is the route of the explain endpoint, after the API version prefix.  It
+	// explains the caller's own token.
+	authzExplainPath = "/authz/explain"
+
+	// authzExplainTokenPath is the route that explains a token given in the request body.  Unlike
+	// authzExplainPath, it goes through the capability check and needs a capability for it.
+	authzExplainTokenPath = "/authz/explain/token"
+
+	// authzExplainCommand is the CLI subcommand that calls the explain endpoint.
+	authzExplainCommand = "explain"
+
+	// maxAuthzExplainBody bounds the size of an explain request.
+	maxAuthzExplainBody = 1 << 20
+
+	// outcomes of a check that did not count anything
+	outcomeSkipped  = "skipped"
+	outcomeDeferred = "deferred"
+)
+
+// authzExplainRequest is the JSON body of an explain request.  Token is an Authorization header
+// value, such as "Bearer <jwt>".  It is only accepted by authzExplainTokenPath; the caller's own
+// token is explained otherwise.
+type authzExplainRequest struct {
+	Token   string       `json:"token,omitempty"`
+	Method  string       `json:"method"`
+	Path    string       `json:"path"`
+	Message *wrp.Message `json:"message,omitempty"`
+}
+
+// authzValidatorResult is the outcome of one token validator.
+type authzValidatorResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// authzCheckResult is the outcome of the capability check, the message stage of the capability
+// policy or the WRP partner ID check.  Outcome and Reason are the labels the check counts with.
+type authzCheckResult struct {
+	Enforced            bool     `json:"enforced"`
+	Outcome             string   `json:"outcome"`
+	Reason              string   `json:"reason,omitempty"`
+	Error               string   `json:"error,omitempty"`
+	Capability          string   `json:"capability,omitempty"`
+	Rule                string   `json:"rule,omitempty"`
+	Explain             []string `json:"explain,omitempty"`
+	PartnerIDs          []string `json:"partnerIDs,omitempty"`
+	RewrittenPartnerIDs []string `json:"rewrittenPartnerIDs,omitempty"`
+}
+
+// authzExplanation is the JSON response of the explain endpoint.  Validators lists the validators
+// that ran, stopping at the first failure as authentication does.  The message checks are only
+// run when the request has a WRP message.
+type authzExplanation struct {
+	Allowed       bool                   `json:"allowed"`
+	Principal     string                 `json:"principal,omitempty"`
+	TokenType     string                 `json:"tokenType,omitempty"`
+	TokenError    string                 `json:"tokenError,omitempty"`
+	Validators    []authzValidatorResult `json:"validators,omitempty"`
+	Capability    *authzCheckResult      `json:"capabilityCheck,omitempty"`
+	MessagePolicy *authzCheckResult      `json:"messagePolicy,omitempty"`
+	WRPCheck      *authzCheckResult      `json:"wrpCheck,omitempty"`
+}
+
+// authzValidator is a named token validator of the auth chain.
+type authzValidator struct {
+	name     string
+	validate func(context.Context, *http.Request, bascule.Token) error
+}
+
+// labelRecorder is a metrics.Counter that keeps the labels it is counted with, so the checks can
+// be explained through their metric reasons without counting anything.
+type labelRecorder struct {
+	labels  map[string]string
+	counted bool
+}
+
+func newLabelRecorder() *labelRecorder {
+	return &labelRecorder{labels: make(map[string]string)}
+}
+
+func (r *labelRecorder) With(labelValues ...string) metrics.Counter {
+	for i := 0; i+1 < len(labelValues); i += 2 {
+		r.labels[labelValues[i]] = labelValues[i+1]
+	}
+
+	return r
+}
+
+func (r *labelRecorder) Add(float64) {
+	r.counted = true
+}
+
+// result fills in the outcome of a check from the recorded labels.
+func (r *labelRecorder) result(enforced bool, err error) *authzCheckResult {
+	result := &authzCheckResult{
+		Enforced: enforced,
+		Outcome:  outcomeSkipped,
+	}
+
+	if r.counted {
+		result.Outcome = r.labels[OutcomeLabel]
+		result.Reason = r.labels[ReasonLabel]
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// authzExplainer runs the checks of the auth chain and the WRP access authorities against a
+// token and a described request, without counting or sending anything.
+type authzExplainer struct {
+	parser     bascule.TokenParser[*http.Request]
+	validators []authzValidator
+	auth       *authReloader
+	policy     *capabilityPolicyAccess
+
+	// partners is the WRP partner ID check, if it is configured.
+	partners *wrpPartnersAccess
+
+	// otherTokens is set for authzExplainTokenPath, which explains the token of the request body.
+	otherTokens bool
+}
+
+// forOtherTokens returns a copy of the explainer that explains the token of the request body,
+// for authzExplainTokenPath.
+func (e *authzExplainer) forOtherTokens() *authzExplainer {
+	c := *e
+	c.otherTokens = true
+	return &c
+}
+
+func (e *authzExplainer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request authzExplainRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAuthzExplainBody)).Decode(&request); err != nil {
+		writeRequestError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid explain request: %s", err))
+		return
+	}
+
+	if len(request.Method) == 0 || !strings.HasPrefix(request.Path, "/") {
+		writeRequestError(w, r, http.StatusBadRequest, "an explain request needs a method and an absolute path")
+		return
+	}
+
+	token, ok := bascule.Get(r.Context())
+	if !ok {
+		writeRequestError(w, r, http.StatusUnauthorized, ErrTokenMissing.Error())
+		return
+	}
+
+	// explaining another token discloses whether it is valid and what it grants, so it needs a
+	// capability the capability check matched, rather than only authentication
+	switch {
+	case !e.otherTokens && len(request.Token) > 0:
+		writeRequestError(w, r, http.StatusForbidden, fmt.Sprintf("explaining another token needs POST %s", authzExplainTokenPath))
+		return
+
+	case e.otherTokens && len(request.Token) == 0:
+		writeRequestError(w, r, http.StatusBadRequest, "an explain token request needs a token")
+		return
+
+	case e.otherTokens && !authorizedByCapability(r.Context()):
+		writeRequestError(w, r, http.StatusForbidden, "explaining another token needs a capability for the endpoint")
+		return
+	}
+
+	explanation, err := e.explain(r.Context(), token, request)
+	if err != nil {
+		writeRequestError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid explain request: %s", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	// nolint:errchkjson
+	_ = json.NewEncoder(w).Encode(explanation)
+}
+
+// explain describes how the request would be authorized.  caller is used unless the request
+// has its own token.  An error means the described request is invalid.
+func (e *authzExplainer) explain(ctx context.Context, caller bascule.Token, request authzExplainRequest) (authzExplanation, error) {
+	var explanation authzExplanation
+
+	target, err := http.NewRequestWithContext(ctx, request.Method, request.Path, nil)
+	if err != nil {
+		return explanation, err
+	}
+
+	token := caller
+	if len(request.Token) > 0 {
+		parsed, err := e.parseToken(ctx, request.Token)
+		if err != nil {
+			explanation.TokenError = err.Error()
+			return explanation, nil
+		}
+
+		token = parsed
+	}
+
+	explanation.Principal = token.Principal()
+	explanation.TokenType = tokenTypeOf(token)
+
+	result := new(capabilityResult)
+	target = target.WithContext(bascule.WithToken(context.WithValue(ctx, capabilityKey{}, result), token))
+
+	for _, v := range e.validators {
+		err := v.validate(target.Context(), target, token)
+		explanation.Validators = append(explanation.Validators, validatorResult(v.name, err))
+		if err != nil {
+			return explanation, nil
+		}
+	}
+
+	cs := e.auth.load().capabilities
+	if cs != nil {
+		recorder := newLabelRecorder()
+		err := cs.authorize(target, token, recorder)
+		explanation.Validators = append(explanation.Validators, validatorResult("capabilities", err))
+		explanation.Capability = recorder.result(cs.enforce, err)
+		explanation.Capability.Capability = result.capability
+
+		if cs.policy != nil {
+			decision := cs.policy.evaluate(newPolicyInput(token, target.Method, trimVersionPrefix(target.URL.EscapedPath())))
+			explanation.Capability.Rule = decision.rule
+			explanation.Capability.Explain = decision.explain
+		}
+
+		if result.deferred {
+			explanation.Capability.Outcome = outcomeDeferred
+		}
+
+		if err != nil {
+			return explanation, nil
+		}
+	}
+
+	explanation.Allowed = true
+	if request.Message == nil {
+		return explanation, nil
+	}
+
+	// the message checks read the request through its ContextValues, as they do for a send
+	populateContextValues(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		target = r
+	})).ServeHTTP(nil, target)
+
+	if cs != nil && cs.policy != nil && result.deferred {
+		explanation.MessagePolicy, err = e.explainMessagePolicy(target.Context(), cs, token, request.Message)
+		if err != nil {
+			explanation.Allowed = false
+			return explanation, nil
+		}
+	}
+
+	if e.partners != nil {
+		explanation.WRPCheck, err = e.explainPartners(target.Context(), request.Message)
+		explanation.Allowed = err == nil
+	}
+
+	return explanation, nil
+}
+
+// authorizedByCapability reports whether the capability check matched one of the token's
+// capabilities for the request.  Tokens without claims, such as unmapped basic auth, and requests
+// the check only monitored without a match have no capability.
+func authorizedByCapability(ctx context.Context) bool {
+	vals, ok := FromContext(ctx)
+	return ok && len(vals.Capability) > 0
+}
+
+// parseToken parses an Authorization header value with the auth chain's token parsers.
+func (e *authzExplainer) parseToken(ctx context.Context, authorization string) (bascule.Token, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Header.Set("Authorization", authorization)
+	return e.parser.Parse(ctx, r)
+}
+
+// explainMessagePolicy finishes a capability policy decision deferred to the WRP message.
+func (e *authzExplainer) explainMessagePolicy(ctx context.Context, cs *capabilitySettings, token bascule.Token, msg *wrp.Message) (*authzCheckResult, error) {
+	recorder := newLabelRecorder()
+	access := *e.policy
+	access.counter = recorder
+	_, err := access.authorizeWRP(ctx, msg)
+
+	result := recorder.result(cs.enforce, err)
+	if vals, ok := FromContext(ctx); ok {
+		in := newPolicyInput(token, vals.Method, trimVersionPrefix(vals.Path))
+		in.carriesWRP = true
+		in.message = msg
+
+		decision := cs.policy.evaluate(in)
+		result.Rule = decision.rule
+		result.Capability = decision.capability
+		result.Explain = decision.explain
+	}
+
+	return result, err
+}
+
+// explainPartners runs the WRP partner ID check on a copy of the message and reports any rewrite
+// of its PartnerIDs.
+func (e *authzExplainer) explainPartners(ctx context.Context, msg *wrp.Message) (*authzCheckResult, error) {
+	recorder := newLabelRecorder()
+	partners := *e.partners
+	partners.receivedWRPMessageCount = recorder
+
+	message := *msg
+	message.PartnerIDs = slices.Clone(msg.PartnerIDs)
+	modified, err := partners.authorizeWRP(ctx, &message)
+
+	result := recorder.result(partners.strict, err)
+	result.PartnerIDs = msg.PartnerIDs
+	if modified {
+		result.RewrittenPartnerIDs = message.PartnerIDs
+	}
+
+	return result, err
+}
+
+func validatorResult(name string, err error) authzValidatorResult {
+	result := authzValidatorResult{Name: name, Passed: err == nil}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// explainCLI is the explain subcommand.  It posts an explain request to a running scytale and
+// prints the explanation.
+func explainCLI(arguments []string, stdout, stderr io.Writer) int {
+	f := pflag.NewFlagSet(authzExplainCommand, pflag.ContinueOnError)
+	f.SetOutput(stderr)
+
+	var (
+		server        = f.String("server", "http://localhost:6300", "the base URL of scytale")
+		authorization = f.String("authorization", os.Getenv("SCYTALE_AUTHORIZATION"), "the Authorization header to call scytale with, by default $SCYTALE_AUTHORIZATION")
+		token         = f.String("token", "", "the Authorization header value to explain, by default the caller's; needs a capability for "+authzExplainTokenPath)
+		method        = f.String("method", http.MethodPost, "the method of the request to explain")
+		target        = f.String("path", "", "the path of the request to explain, such as /api/v3/device")
+		message       = f.String("message", "", "a file holding the JSON WRP message of the request, or - for stdin")
+		timeout       = f.Duration("timeout", 10*time.Second, "the timeout of the explain request")
+	)
+
+	if err := f.Parse(arguments); err != nil {
+		return 2
+	}
+
+	request := authzExplainRequest{
+		Token:  *token,
+		Method: *method,
+		Path:   *target,
+	}
+
+	if len(*message) > 0 {
+		var (
+			data []byte
+			err  error
+		)
+
+		if *message == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(*message)
+		}
+
+		if err == nil {
+			request.Message = new(wrp.Message)
+			err = json.Unmarshal(data, request.Message)
+		}
+
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to read the WRP message: %s\n", err)
+			return 1
+		}
+	}
+
+	path := authzExplainPath
+	if len(request.Token) > 0 {
+		path = authzExplainTokenPath
+	}
+
+	body, err := postExplain(*server, path, *authorization, *timeout, request)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		out.Reset()
+		out.Write(body)
+	}
+
+	fmt.Fprintln(stdout, strings.TrimSpace(out.String()))
+	return 0
+}
+
+// postExplain sends an explain request to the explain route at path and returns the explanation.
+func postExplain(server, path, authorization string, timeout time.Duration, request authzExplainRequest) ([]byte, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	url := strings.TrimSuffix(server, "/") + "/" + apiBase + path
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	r.Header.Set("Content-Type", "application/json")
+	if len(authorization) > 0 {
+		r.Header.Set("Authorization", authorization)
+	}
+
+	response, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("explain request failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the explanation: %w", err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(strings.TrimSpace(fmt.Sprintf("explain request failed with status %d: %s", response.StatusCode, data)))
+	}
+
+	return data, nil
+}
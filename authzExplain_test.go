@@ -0,0 +1,432 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.uber.org/zap"
+)
+
+// testTokenParser returns the token of the request's Authorization header.
+type testTokenParser map[string]bascule.Token
+
+func (p testTokenParser) Parse(_ context.Context, r *http.Request) (bascule.Token, error) {
+	if token, ok := p[r.Header.Get("Authorization")]; ok {
+		return token, nil
+	}
+
+	return nil, bascule.ErrInvalidCredentials
+}
+
+func newTestAuthzExplainer(t *testing.T, cs *capabilitySettings, tokens testTokenParser) (*authzExplainer, *testCounter) {
+	auth := newAuthReloader(viper.New(), zap.NewNop(), &authSettings{capabilities: cs}, newTestCounter())
+	counter := newTestCounter()
+	return &authzExplainer{
+		parser: tokens,
+		validators: []authzValidator{
+			{
+				name: "principal",
+				validate: func(_ context.Context, _ *http.Request, token bascule.Token) error {
+					if token.Principal() == "" {
+						return errors.New("empty token principal")
+					}
+
+					return nil
+				},
+			},
+		},
+		auth:     auth,
+		policy:   &capabilityPolicyAccess{auth: auth, counter: counter},
+		partners: &wrpPartnersAccess{receivedWRPMessageCount: counter},
+	}, counter
+}
+
+func TestAuthzExplainerExplain(t *testing.T) {
+	ec, err := newEndpointRegexCheck("x1:webpa:api:", "all")
+	require.NoError(t, err)
+
+	var (
+		config = &jwtToken{
+			principal: "config-client",
+			claims: map[string]any{
+				"allowedResources": map[string]any{"allowedPartners": []string{"comcast"}},
+				"capabilities":     []string{"x1:webpa:api:device/.*/config:all", "x1:webpa:api:device:post"},
+			},
+		}
+
+		hooks = &jwtToken{
+			principal: "hook-client",
+			claims: map[string]any{
+				"allowedResources": map[string]any{"allowedPartners": []string{"comcast"}},
+				"capabilities":     []string{"x1:webpa:api:hook:all"},
+			},
+		}
+
+		sky = &jwtToken{
+			principal: "sky-client",
+			claims: map[string]any{
+				"allowedResources": map[string]any{"allowedPartners": []string{"sky"}},
+				"capabilities":     []string{"x1:webpa:api:config"},
+			},
+		}
+
+		tokens = testTokenParser{"Bearer hooks": hooks}
+	)
+
+	tests := []struct {
+		name       string
+		cs         *capabilitySettings
+		caller     bascule.Token
+		request    authzExplainRequest
+		expected   authzExplanation
+		expectsErr bool
+	}{
+		{
+			name:   "capability match",
+			cs:     &capabilitySettings{enforce: true, check: ec},
+			caller: config,
+			request: authzExplainRequest{
+				Method: http.MethodGet,
+				Path:   "/api/v3/device/mac:112233445566/config",
+			},
+			expected: authzExplanation{
+				Allowed:   true,
+				Principal: "config-client",
+				TokenType: jwtTokenType,
+				Validators: []authzValidatorResult{
+					{Name: "principal", Passed: true},
+					{Name: "capabilities", Passed: true},
+				},
+				Capability: &authzCheckResult{
+					Enforced:   true,
+					Outcome:    Accepted,
+					Capability: "x1:webpa:api:device/.*/config:all",
+				},
+			},
+		},
+		{
+			name:   "no capability match for the request's token",
+			cs:     &capabilitySettings{enforce: true, check: ec},
+			caller: config,
+			request: authzExplainRequest{
+				Token:  "Bearer hooks",
+				Method: http.MethodGet,
+				Path:   "/api/v3/device/mac:112233445566/config",
+			},
+			expected: authzExplanation{
+				Principal: "hook-client",
+				TokenType: jwtTokenType,
+				Validators: []authzValidatorResult{
+					{Name: "principal", Passed: true},
+					{Name: "capabilities", Error: bascule.ErrUnauthorized.Error()},
+				},
+				Capability: &authzCheckResult{
+					Enforced: true,
+					Outcome:  Rejected,
+					Reason:   NoCapabilitiesMatch,
+					Error:    bascule.ErrUnauthorized.Error(),
+				},
+			},
+		},
+		{
+			name:   "invalid token",
+			caller: config,
+			request: authzExplainRequest{
+				Token:  "Bearer unknown",
+				Method: http.MethodGet,
+				Path:   "/api/v3/device/mac:112233445566/stat",
+			},
+			expected: authzExplanation{
+				TokenError: bascule.ErrInvalidCredentials.Error(),
+			},
+		},
+		{
+			name:   "failed validator",
+			cs:     &capabilitySettings{enforce: true, check: ec},
+			caller: &jwtToken{},
+			request: authzExplainRequest{
+				Method: http.MethodGet,
+				Path:   "/api/v3/device/mac:112233445566/stat",
+			},
+			expected: authzExplanation{
+				TokenType: jwtTokenType,
+				Validators: []authzValidatorResult{
+					{Name: "principal", Error: "empty token principal"},
+				},
+			},
+		},
+		{
+			name:   "partner ID rewrite",
+			caller: config,
+			request: authzExplainRequest{
+				Method: http.MethodPost,
+				Path:   "/api/v3/device",
+				Message: &wrp.Message{
+					Type:        wrp.SimpleRequestResponseMessageType,
+					Destination: "mac:112233445566/config",
+					PartnerIDs:  []string{"sky"},
+				},
+			},
+			expected: authzExplanation{
+				Allowed:   true,
+				Principal: "config-client",
+				TokenType: jwtTokenType,
+				Validators: []authzValidatorResult{
+					{Name: "principal", Passed: true},
+				},
+				WRPCheck: &authzCheckResult{
+					Outcome:             Accepted,
+					Reason:              WRPPIDMismatch,
+					PartnerIDs:          []string{"sky"},
+					RewrittenPartnerIDs: []string{"comcast"},
+				},
+			},
+		},
+		{
+			name:   "message policy denial",
+			cs:     &capabilitySettings{enforce: true, check: ec, policy: newTestCapabilityPolicy(t)},
+			caller: sky,
+			request: authzExplainRequest{
+				Method: http.MethodPost,
+				Path:   "/api/v3/device",
+				Message: &wrp.Message{
+					Type:        wrp.SimpleRequestResponseMessageType,
+					Destination: "mac:112233445566/reboot",
+				},
+			},
+			expected: authzExplanation{
+				Principal: "sky-client",
+				TokenType: jwtTokenType,
+				Validators: []authzValidatorResult{
+					{Name: "principal", Passed: true},
+					{Name: "capabilities", Passed: true},
+				},
+				Capability: &authzCheckResult{
+					Enforced: true,
+					Outcome:  outcomeDeferred,
+					Rule:     "no sky reboots",
+				},
+				MessagePolicy: &authzCheckResult{
+					Enforced: true,
+					Outcome:  Rejected,
+					Reason:   PolicyDenied,
+					Error:    ErrPolicyDenied.Error(),
+					Rule:     "no sky reboots",
+				},
+			},
+		},
+		{
+			name:       "invalid method",
+			caller:     config,
+			request:    authzExplainRequest{Method: "BAD METHOD", Path: "/api/v3/device"},
+			expectsErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			e, counter := newTestAuthzExplainer(t, tt.cs, tokens)
+
+			var partnerIDs []string
+			if tt.request.Message != nil {
+				partnerIDs = append(partnerIDs, tt.request.Message.PartnerIDs...)
+			}
+
+			explanation, err := e.explain(context.Background(), tt.caller, tt.request)
+			if tt.expectsErr {
+				assert.Error(err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			// the rules a policy skipped are not part of the expectations
+			for _, result := range []*authzCheckResult{explanation.Capability, explanation.MessagePolicy} {
+				if result != nil {
+					assert.Equal(tt.cs.policy != nil, len(result.Explain) > 0)
+					result.Explain = nil
+				}
+			}
+
+			assert.Equal(tt.expected, explanation)
+			assert.Zero(counter.count)
+			if tt.request.Message != nil {
+				assert.Equal(partnerIDs, tt.request.Message.PartnerIDs)
+			}
+		})
+	}
+}
+
+func TestAuthzExplainerServeHTTP(t *testing.T) {
+	ec, err := newEndpointRegexCheck("x1:webpa:api:", "all")
+	require.NoError(t, err)
+
+	var (
+		caller = &jwtToken{
+			principal: "stat-client",
+			claims:    map[string]any{"capabilities": []string{"x1:webpa:api:device/.*/stat:get"}},
+		}
+
+		tokens = testTokenParser{"Bearer stat": caller}
+	)
+
+	tests := []struct {
+		name              string
+		body              string
+		token             bascule.Token
+		otherTokens       bool
+		capability        string
+		expectedStatus    int
+		expectedPrincipal string
+	}{
+		{
+			name:              "explained",
+			body:              `{"method":"GET","path":"/api/v3/device/mac:112233445566/stat"}`,
+			token:             caller,
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: "stat-client",
+		},
+		{
+			name:           "invalid body",
+			body:           `{"method":`,
+			token:          caller,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "relative path",
+			body:           `{"method":"GET","path":"device"}`,
+			token:          caller,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "no token",
+			body:           `{"method":"GET","path":"/api/v3/device/mac:112233445566/stat"}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "another token",
+			body:           `{"token":"Bearer stat","method":"GET","path":"/api/v3/device/mac:112233445566/stat"}`,
+			token:          &jwtToken{principal: "other-client"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:              "another token with the explain capability",
+			body:              `{"token":"Bearer stat","method":"GET","path":"/api/v3/device/mac:112233445566/stat"}`,
+			token:             &jwtToken{principal: "admin-client"},
+			otherTokens:       true,
+			capability:        "x1:webpa:api:authz/explain/token:post",
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: "stat-client",
+		},
+		{
+			name:           "another token without the explain capability",
+			body:           `{"token":"Bearer stat","method":"GET","path":"/api/v3/device/mac:112233445566/stat"}`,
+			token:          &jwtToken{principal: "other-client"},
+			otherTokens:    true,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "token route without a token",
+			body:           `{"method":"GET","path":"/api/v3/device/mac:112233445566/stat"}`,
+			token:          caller,
+			otherTokens:    true,
+			capability:     "x1:webpa:api:authz/explain/token:post",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			e, _ := newTestAuthzExplainer(t, &capabilitySettings{enforce: true, check: ec}, tokens)
+			path := "/api/v3/authz/explain"
+			if tt.otherTokens {
+				e = e.forOtherTokens()
+				path = "/api/v3/authz/explain/token"
+			}
+
+			request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(tt.body))
+			if tt.token != nil {
+				ctx := bascule.WithToken(request.Context(), tt.token)
+				ctx = NewContextWithValue(ctx, &ContextValues{Capability: tt.capability})
+				request = request.WithContext(ctx)
+			}
+
+			response := httptest.NewRecorder()
+			e.ServeHTTP(response, request)
+			assert.Equal(tt.expectedStatus, response.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var explanation authzExplanation
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&explanation))
+			assert.True(explanation.Allowed)
+			assert.Equal(tt.expectedPrincipal, explanation.Principal)
+			assert.Equal("x1:webpa:api:device/.*/stat:get", explanation.Capability.Capability)
+		})
+	}
+}
+
+func TestExplainCLI(t *testing.T) {
+	var (
+		status  = http.StatusOK
+		request authzExplainRequest
+		header  http.Header
+		path    string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		header = r.Header
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, `{"allowed":true}`)
+	}))
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := explainCLI([]string{
+		"--server", server.URL,
+		"--authorization", "Bearer caller",
+		"--token", "Bearer other",
+		"--method", http.MethodGet,
+		"--path", "/api/v3/device/mac:112233445566/stat",
+	}, &stdout, &stderr)
+
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "/api/v3/authz/explain/token", path)
+	assert.Equal(t, "Bearer caller", header.Get("Authorization"))
+	assert.Equal(t, authzExplainRequest{
+		Token:  "Bearer other",
+		Method: http.MethodGet,
+		Path:   "/api/v3/device/mac:112233445566/stat",
+	}, request)
+	assert.JSONEq(t, `{"allowed":true}`, stdout.String())
+
+	status = http.StatusForbidden
+	stdout.Reset()
+	code = explainCLI([]string{"--server", server.URL, "--path", "/api/v3/device"}, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "/api/v3/authz/explain", path)
+	assert.Empty(t, stdout.String())
+	assert.Contains(t, stderr.String(), "status 403")
+
+	assert.Equal(t, 2, explainCLI([]string{"--unknown"}, &stdout, &stderr))
+}
@@ -14,9 +14,10 @@ import (
 // nolint: goconst
 var partnerKeys = []string{"allowedResources", "allowedPartners"}
 
+// requirePartnersJWTClaim requires JWTs and client certificates to carry partner IDs.
 var requirePartnersJWTClaim = func(_ context.Context, token bascule.Token) error {
 	tt, ok := token.(tokenType)
-	if !ok || tt.TokenType() != jwtTokenType {
+	if !ok || (tt.TokenType() != jwtTokenType && tt.TokenType() != mtlsTokenType) {
 		return nil
 	}
 
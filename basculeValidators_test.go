@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/xmidt-org/bascule"
 )
 
 func TestRequirePartnerIDs(t *testing.T) {
@@ -58,13 +59,18 @@ func TestRequirePartnerIDs(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			assert := assert.New(t)
-			token := &jwtToken{principal: "client0", claims: test.attrMap}
+			tokens := []bascule.Token{
+				&jwtToken{principal: "client0", claims: test.attrMap},
+				&certificateToken{principal: "client0", claims: test.attrMap},
+			}
 
-			err := requirePartnersJWTClaim(ctx, token)
-			if test.shouldPass {
-				assert.Nil(err)
-			} else {
-				assert.NotNil(err)
+			for _, token := range tokens {
+				err := requirePartnersJWTClaim(ctx, token)
+				if test.shouldPass {
+					assert.Nil(err)
+				} else {
+					assert.NotNil(err)
+				}
 			}
 		})
 	}
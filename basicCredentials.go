@@ -0,0 +1,236 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	basicCredentialsConfigKey = "basicAuth"
+
+	// maxArgon2idMemory caps the memory, in KiB, an argon2id hash makes each login with it use.
+	maxArgon2idMemory = 256 * 1024
+)
+
+var (
+	errBasicCredentialMissingSeparator = errors.New("credentials must be of the form user:hash")
+	errUnsupportedPasswordHash         = errors.New("unsupported password hash, expected bcrypt or argon2id")
+	errInvalidArgon2idHash             = errors.New("invalid argon2id hash")
+)
+
+// BasicCredentialsConfig holds hashed basic auth credentials through the basicAuth configuration.
+// Each credential is an htpasswd style user:hash entry, where the hash is either bcrypt or a PHC
+// formatted argon2id hash.
+type BasicCredentialsConfig struct {
+	// Users are the inline credentials.
+	Users []string
+
+	// File is the path of an htpasswd style file of credentials, one per line.  Blank lines and
+	// lines starting with # are skipped.
+	File string
+
+	// Mappings give basic auth users the allowed partners and capabilities a JWT would carry,
+	// so that they are subject to the WRPCheck and capabilityCheck like JWT clients.
+	Mappings []BasicUserMapping
+}
+
+// BasicUserMapping is the allowed partners and capabilities of a basic auth user.
+type BasicUserMapping struct {
+	User         string
+	Partners     []string
+	Capabilities []string
+}
+
+// claims returns the mapping in the shape of the JWT claims it stands in for.
+func (m BasicUserMapping) claims() map[string]any {
+	return mappedClaims(m.Partners, m.Capabilities)
+}
+
+// mappedClaims returns configured partners and capabilities in the shape of the JWT claims they
+// stand in for.
+func mappedClaims(partners, capabilities []string) map[string]any {
+	claims := make(map[string]any, 2)
+	if len(partners) > 0 {
+		claims[partnerKeys[0]] = map[string]any{partnerKeys[1]: partners}
+	}
+
+	if len(capabilities) > 0 {
+		claims["capabilities"] = capabilities
+	}
+
+	return claims
+}
+
+// passwordHash verifies a password without keeping the password itself.
+type passwordHash interface {
+	verify(password string) bool
+}
+
+// saltedPasswordHash is a salted SHA-256 digest.  It is used for the plaintext credentials of the
+// authHeader configuration, so they are not kept in memory as they were configured.
+type saltedPasswordHash struct {
+	salt []byte
+	sum  []byte
+}
+
+func newSaltedPasswordHash(password string) (saltedPasswordHash, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return saltedPasswordHash{}, fmt.Errorf("failed to create salt: %w", err)
+	}
+
+	h := saltedPasswordHash{salt: salt}
+	h.sum = h.digest(password)
+	return h, nil
+}
+
+func (h saltedPasswordHash) digest(password string) []byte {
+	d := sha256.New()
+	d.Write(h.salt)
+	d.Write([]byte(password))
+	return d.Sum(nil)
+}
+
+func (h saltedPasswordHash) verify(password string) bool {
+	return subtle.ConstantTimeCompare(h.sum, h.digest(password)) == 1
+}
+
+// bcryptPasswordHash is a bcrypt hash, such as those created by htpasswd -B.
+type bcryptPasswordHash []byte
+
+func (h bcryptPasswordHash) verify(password string) bool {
+	return bcrypt.CompareHashAndPassword(h, []byte(password)) == nil
+}
+
+// argon2idPasswordHash is a PHC formatted argon2id hash:
+//
+// $argon2id$v=19$m=65536,t=3,p=4$<base64 salt>$<base64 key>
+type argon2idPasswordHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2idPasswordHash(encoded string) (argon2idPasswordHash, error) {
+	var (
+		h       argon2idPasswordHash
+		version int
+		parts   = strings.Split(encoded, "$")
+	)
+
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return h, errInvalidArgon2idHash
+	}
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return h, fmt.Errorf("%w: unsupported version", errInvalidArgon2idHash)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return h, fmt.Errorf("%w: invalid parameters", errInvalidArgon2idHash)
+	}
+
+	// argon2.IDKey panics on a zero time or thread count
+	switch {
+	case h.time < 1:
+		return h, fmt.Errorf("%w: time must be at least 1", errInvalidArgon2idHash)
+	case h.threads < 1:
+		return h, fmt.Errorf("%w: parallelism must be at least 1", errInvalidArgon2idHash)
+	case h.memory < 8*uint32(h.threads):
+		return h, fmt.Errorf("%w: memory must be at least 8 KiB per thread", errInvalidArgon2idHash)
+	case h.memory > maxArgon2idMemory:
+		return h, fmt.Errorf("%w: memory must be at most %d KiB", errInvalidArgon2idHash, maxArgon2idMemory)
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return h, fmt.Errorf("%w: invalid salt", errInvalidArgon2idHash)
+	}
+
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return h, fmt.Errorf("%w: invalid key", errInvalidArgon2idHash)
+	}
+
+	return h, nil
+}
+
+func (h argon2idPasswordHash) verify(password string) bool {
+	key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(h.key, key) == 1
+}
+
+// parsePasswordHash determines the kind of hash from its prefix.
+func parsePasswordHash(encoded string) (passwordHash, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(encoded)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash: %w", err)
+		}
+
+		return bcryptPasswordHash(encoded), nil
+
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return parseArgon2idPasswordHash(encoded)
+	}
+
+	return nil, errUnsupportedPasswordHash
+}
+
+// parseBasicCredential parses an htpasswd style user:hash entry.
+func parseBasicCredential(entry string) (string, passwordHash, error) {
+	user, encoded, ok := strings.Cut(entry, ":")
+	if !ok || len(user) == 0 {
+		return "", nil, errBasicCredentialMissingSeparator
+	}
+
+	h, err := parsePasswordHash(encoded)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return user, h, nil
+}
+
+// readBasicCredentialsFile reads the entries of an htpasswd style file.
+func readBasicCredentialsFile(path string) ([]string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read basic auth file: %w", err)
+	}
+
+	var (
+		entries []string
+		scanner = bufio.NewScanner(bytes.NewReader(contents))
+	)
+
+	for scanner.Scan() {
+		line := string(bytes.TrimSpace(scanner.Bytes()))
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entries = append(entries, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read basic auth file: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestBcryptHash(t *testing.T, password string) string {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+	return string(h)
+}
+
+func newTestArgon2idHash(password string) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(password), salt, 1, 64, 1, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=64,t=1,p=1$%s$%s",
+		argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+func TestParseBasicCredential(t *testing.T) {
+	tests := []struct {
+		name         string
+		entry        string
+		expectedUser string
+		expectedErr  error
+	}{
+		{
+			name:         "bcrypt",
+			entry:        "user:" + newTestBcryptHash(t, "pass"),
+			expectedUser: "user",
+		},
+		{
+			name:         "argon2id",
+			entry:        "user:" + newTestArgon2idHash("pass"),
+			expectedUser: "user",
+		},
+		{
+			name:        "missing separator",
+			entry:       "user",
+			expectedErr: errBasicCredentialMissingSeparator,
+		},
+		{
+			name:        "missing user",
+			entry:       ":" + newTestArgon2idHash("pass"),
+			expectedErr: errBasicCredentialMissingSeparator,
+		},
+		{
+			name:        "plaintext",
+			entry:       "user:pass",
+			expectedErr: errUnsupportedPasswordHash,
+		},
+		{
+			name:        "argon2i",
+			entry:       "user:$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5",
+			expectedErr: errUnsupportedPasswordHash,
+		},
+		{
+			name:        "argon2id version",
+			entry:       "user:$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5",
+			expectedErr: errInvalidArgon2idHash,
+		},
+		{
+			name:        "argon2id parameters",
+			entry:       "user:$argon2id$v=19$m=64$c2FsdA$a2V5",
+			expectedErr: errInvalidArgon2idHash,
+		},
+		{
+			name:        "argon2id zero time",
+			entry:       "user:$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5",
+			expectedErr: errInvalidArgon2idHash,
+		},
+		{
+			name:        "argon2id zero parallelism",
+			entry:       "user:$argon2id$v=19$m=64,t=1,p=0$c2FsdA$a2V5",
+			expectedErr: errInvalidArgon2idHash,
+		},
+		{
+			name:        "argon2id memory below 8 KiB per thread",
+			entry:       "user:$argon2id$v=19$m=31,t=1,p=4$c2FsdA$a2V5",
+			expectedErr: errInvalidArgon2idHash,
+		},
+		{
+			name:        "argon2id memory above the cap",
+			entry:       "user:$argon2id$v=19$m=4194304,t=1,p=1$c2FsdA$a2V5",
+			expectedErr: errInvalidArgon2idHash,
+		},
+		{
+			name:        "argon2id key",
+			entry:       "user:$argon2id$v=19$m=64,t=1,p=1$c2FsdA$%%%",
+			expectedErr: errInvalidArgon2idHash,
+		},
+		{
+			name:  "bcrypt truncated",
+			entry: "user:$2y$10$short",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			user, h, err := parseBasicCredential(tt.entry)
+			if len(tt.expectedUser) == 0 {
+				assert.Error(err)
+				if tt.expectedErr != nil {
+					assert.True(errors.Is(err, tt.expectedErr))
+				}
+				assert.Nil(h)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(tt.expectedUser, user)
+			assert.True(h.verify("pass"))
+			assert.False(h.verify("wrong"))
+			assert.False(h.verify(""))
+		})
+	}
+}
+
+func TestSaltedPasswordHash(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	first, err := newSaltedPasswordHash("pass")
+	require.NoError(err)
+	second, err := newSaltedPasswordHash("pass")
+	require.NoError(err)
+
+	assert.True(first.verify("pass"))
+	assert.False(first.verify("wrong"))
+	assert.NotEqual(first.salt, second.salt)
+	assert.NotEqual(first.sum, second.sum)
+}
+
+func TestLoadAuthSettingsCredentials(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(os.WriteFile(path, []byte(
+		"# scytale users\n\nfile:"+newTestBcryptHash(t, "filepass")+"\ninvalid\n",
+	), 0600))
+
+	v := viper.New()
+	v.Set(basicAuthConfigKey, []string{base64.StdEncoding.EncodeToString([]byte("header:headerpass"))})
+	v.Set(basicCredentialsConfigKey, map[string]any{
+		"users": []string{"inline:" + newTestArgon2idHash("inlinepass")},
+		"file":  path,
+	})
+
+	settings, err := loadAuthSettings(v, zap.NewNop(), false)
+	require.NoError(err)
+	assertBasicAllowed(t, map[string]string{
+		"header": "headerpass",
+		"inline": "inlinepass",
+		"file":   "filepass",
+	}, settings.basicAllowed)
+
+	// strict loading rejects the invalid line of the file
+	_, err = loadAuthSettings(v, zap.NewNop(), true)
+	assert.Error(err)
+
+	v.Set(basicCredentialsConfigKey, map[string]any{"file": filepath.Join(t.TempDir(), "missing")})
+	_, err = loadAuthSettings(v, zap.NewNop(), false)
+	assert.Error(err)
+}
@@ -0,0 +1,273 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrp-go/v3/wrpcontext"
+	"github.com/xmidt-org/wrp-go/v3/wrphttp"
+)
+
+const (
+	// defaultBatchConcurrency is the number of messages of a batch or broadcast in flight at once.
+	defaultBatchConcurrency = 4
+
+	// defaultBatchMaxBodySize bounds the size of a batch request.
+	defaultBatchMaxBodySize = 10 << 20
+
+	// defaultFanoutConcurrency is the concurrency the fanout uses when none is configured.
+	defaultFanoutConcurrency = 10
+)
+
+// BatchConfig drives the batch send endpoint through the batchSend configuration.
+type BatchConfig struct {
+	// MaxMessages is the maximum number of WRP messages accepted in a single batch.
+	// Zero means there is no limit.
+	MaxMessages int
+
+	// MaxBodySize is the maximum size in bytes of a batch request.  Defaults to 10 MiB.
+	MaxBodySize int64
+
+	// Concurrency is the number of messages of a single batch or broadcast sent at once.  It is
+	// kept below the fanout's concurrency, which every request to the server shares, so that one
+	// batch cannot take all of it.  Defaults to 4.
+	Concurrency int
+}
+
+// sendConcurrency returns the number of messages of a batch sent at once, given the concurrency
+// of the fanout.
+func (c BatchConfig) sendConcurrency(fanoutConcurrency int) int {
+	concurrency := c.Concurrency
+	if concurrency < 1 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	if fanoutConcurrency < 1 {
+		fanoutConcurrency = defaultFanoutConcurrency
+	}
+
+	return max(min(concurrency, fanoutConcurrency/2), 1)
+}
+
+func (c BatchConfig) maxBodySize() int64 {
+	if c.MaxBodySize < 1 {
+		return defaultBatchMaxBodySize
+	}
+
+	return c.MaxBodySize
+}
+
+// batchSendResult is the outcome of sending a single message of a batch.
+type batchSendResult struct {
+	Destination string `json:"destination"`
+	Status      int    `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// wrpSender validates, authorizes and fans out individual WRP messages on behalf of the
+// endpoints that send many messages from a single HTTP request.
+type wrpSender struct {
+	fanoutHandler http.Handler
+	validators    *wrpValidators
+	access        wrpAccessAuthority
+	enricher      *wrpEnricher
+	concurrency   int
+}
+
+// sendAll sends every message with at most concurrency messages in flight.  The results are
+// keyed by the value key returns for each message, which must be unique within messages.
+func (s *wrpSender) sendAll(original *http.Request, messages []wrp.Message, key func(*wrp.Message) string) map[string]batchSendResult {
+	var (
+		results = make(map[string]batchSendResult, len(messages))
+		lock    sync.Mutex
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, max(s.concurrency, 1))
+	)
+
+	for i := range messages {
+		msg := &messages[i]
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			result := s.send(original, msg)
+			lock.Lock()
+			results[key(msg)] = result
+			lock.Unlock()
+		}()
+	}
+
+	wg.Wait()
+	return results
+}
+
+// batchSendHandler fans out each WRP message of a batch through the same fanout handler used for
+// single sends.  Every message is validated and authorized on its own, and the outcomes are
+// reported keyed by the messages' transaction UUIDs.
+type batchSendHandler struct {
+	sender      *wrpSender
+	maxMessages int
+	maxBodySize int64
+}
+
+func (h *batchSendHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize)
+	messages, err := decodeBatch(r)
+	if err != nil {
+		writeRequestError(w, r, requestBodyErrorStatus(err), err.Error())
+		return
+	}
+
+	if err := h.checkBatch(messages); err != nil {
+		writeRequestError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results := h.sender.sendAll(r, messages, func(msg *wrp.Message) string {
+		return msg.TransactionUUID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	// nolint:errchkjson
+	_ = json.NewEncoder(w).Encode(results)
+}
+
+// send enriches, validates, authorizes and fans out a single message.
+func (s *wrpSender) send(original *http.Request, msg *wrp.Message) batchSendResult {
+	ctx := original.Context()
+
+	// the messages are sent at the same time and each may be allowed with its own capability
+	if vals, ok := FromContext(ctx); ok {
+		copied := *vals
+		ctx = NewContextWithValue(ctx, &copied)
+	}
+
+	result := batchSendResult{Destination: msg.Destination}
+	s.enricher.enrich(ctx, msg)
+	if failures := s.validators.validate(msg); len(failures) > 0 {
+		reasons := make([]string, 0, len(failures))
+		for _, f := range failures {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", f.Validator, f.Message))
+		}
+
+		result.Status = http.StatusBadRequest
+		result.Error = strings.Join(reasons, "; ")
+		return result
+	}
+
+	if s.access != nil {
+		if _, err := s.access.authorizeWRP(ctx, msg); err != nil {
+			result.Status = errorStatusCode(err)
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	entity := &wrphttp.Entity{Message: *msg, Format: wrp.Msgpack}
+	if err := wrp.NewEncoderBytes(&entity.Bytes, entity.Format).Encode(entity.Message); err != nil {
+		result.Status = http.StatusBadRequest
+		result.Error = err.Error()
+		return result
+	}
+
+	fanout := original.Clone(wrpcontext.SetMessage(ctx, &entity.Message))
+	fanoutPrep(fanout, entity.Bytes, entity)
+
+	response := newBufferedResponse()
+	s.fanoutHandler.ServeHTTP(response, fanout)
+
+	result.Status = response.code
+	if response.code >= http.StatusBadRequest {
+		result.Error = response.header.Get("X-Xmidt-Error")
+	}
+
+	return result
+}
+
+// checkBatch makes sure the batch is not empty, not too large, and that every message can be
+// reported on by a unique transaction UUID.
+func (h *batchSendHandler) checkBatch(messages []wrp.Message) error {
+	if len(messages) == 0 {
+		return errors.New("batch must contain at least one wrp message")
+	}
+
+	if h.maxMessages > 0 && len(messages) > h.maxMessages {
+		return fmt.Errorf("batch of %d wrp messages exceeds the limit of %d", len(messages), h.maxMessages)
+	}
+
+	seen := make(map[string]bool, len(messages))
+	for i, msg := range messages {
+		if msg.TransactionUUID == "" {
+			return fmt.Errorf("wrp message %d is missing a transaction_uuid", i)
+		}
+
+		if seen[msg.TransactionUUID] {
+			return fmt.Errorf("wrp message %d has a duplicate transaction_uuid %s", i, msg.TransactionUUID)
+		}
+
+		seen[msg.TransactionUUID] = true
+	}
+
+	return nil
+}
+
+// requestBodyErrorStatus is the status code of an error reading or decoding a request body.
+func requestBodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusBadRequest
+}
+
+// decodeBatch reads the WRP messages of a batch, either as a JSON array or as a stream of
+// msgpack encoded messages.
+func decodeBatch(r *http.Request) ([]wrp.Message, error) {
+	format, err := wrphttp.DetermineFormat(wrp.JSON, r.Header, "Content-Type")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	var messages []wrp.Message
+	if format == wrp.JSON {
+		if err := wrp.NewDecoderBytes(body, wrp.JSON).Decode(&messages); err != nil {
+			return nil, fmt.Errorf("failed to decode wrp messages: %w", err)
+		}
+
+		return messages, nil
+	}
+
+	reader := bytes.NewReader(body)
+	decoder := wrp.NewDecoder(reader, wrp.Msgpack)
+	for reader.Len() > 0 {
+		var msg wrp.Message
+		if err := decoder.Decode(&msg); err != nil {
+			return nil, fmt.Errorf("failed to decode wrp message %d: %w", len(messages), err)
+		}
+
+		messages = append(messages, msg)
+	}
+
+	return messages, nil
+}
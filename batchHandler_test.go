@@ -0,0 +1,286 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrp-go/v3/wrpcontext"
+)
+
+func TestDecodeBatch(t *testing.T) {
+	messages := []wrp.Message{
+		{Type: wrp.SimpleEventMessageType, Destination: "mac:112233445566", TransactionUUID: "1"},
+		{Type: wrp.SimpleEventMessageType, Destination: "mac:112233445577", TransactionUUID: "2"},
+	}
+
+	var msgpackBody []byte
+	for _, msg := range messages {
+		msgpackBody = append(msgpackBody, wrp.MustEncode(msg, wrp.Msgpack)...)
+	}
+
+	jsonBody, err := json.Marshal(messages)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		shouldErr   bool
+	}{
+		{
+			name:        "json array",
+			contentType: wrp.JSON.ContentType(),
+			body:        jsonBody,
+		},
+		{
+			name:        "msgpack stream",
+			contentType: wrp.Msgpack.ContentType(),
+			body:        msgpackBody,
+		},
+		{
+			name:        "invalid json",
+			contentType: wrp.JSON.ContentType(),
+			body:        []byte("not json"),
+			shouldErr:   true,
+		},
+		{
+			name:        "unsupported content type",
+			contentType: "text/plain",
+			body:        jsonBody,
+			shouldErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/v3/devices/batch", bytes.NewReader(tt.body))
+			request.Header.Set("Content-Type", tt.contentType)
+
+			decoded, err := decodeBatch(request)
+			if tt.shouldErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, decoded, len(messages))
+			for i := range messages {
+				assert.Equal(t, messages[i].Destination, decoded[i].Destination)
+				assert.Equal(t, messages[i].TransactionUUID, decoded[i].TransactionUUID)
+			}
+		})
+	}
+}
+
+func TestCheckBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxMessages int
+		messages    []wrp.Message
+		shouldErr   bool
+	}{
+		{
+			name:     "valid batch",
+			messages: []wrp.Message{{TransactionUUID: "1"}, {TransactionUUID: "2"}},
+		},
+		{
+			name:      "empty batch",
+			shouldErr: true,
+		},
+		{
+			name:        "too many messages",
+			maxMessages: 1,
+			messages:    []wrp.Message{{TransactionUUID: "1"}, {TransactionUUID: "2"}},
+			shouldErr:   true,
+		},
+		{
+			name:      "missing transaction uuid",
+			messages:  []wrp.Message{{TransactionUUID: "1"}, {}},
+			shouldErr: true,
+		},
+		{
+			name:      "duplicate transaction uuid",
+			messages:  []wrp.Message{{TransactionUUID: "1"}, {TransactionUUID: "1"}},
+			shouldErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &batchSendHandler{maxMessages: tt.maxMessages}
+			err := h.checkBatch(tt.messages)
+			if tt.shouldErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestBatchSendHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	validators, err := newWRPValidators([]WRPValidatorConfig{{Type: destinationValidatorType, Level: enforceCheck}}, newTestFactory())
+	require.NoError(err)
+
+	access := new(mockWRPAccessAuthority)
+	access.On("authorizeWRP", mock.Anything, mock.MatchedBy(func(m *wrp.Message) bool {
+		return m.TransactionUUID == "forbidden"
+	})).Return(false, ErrPIDMismatch)
+	access.On("authorizeWRP", mock.Anything, mock.Anything).Return(false, nil)
+
+	var fanouts int32
+	fanoutHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fanouts, 1)
+		msg, ok := wrpcontext.GetMessage(r.Context())
+		if !assert.True(ok) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		assert.Equal(wrp.Msgpack.ContentType(), r.Header.Get("Content-Type"))
+		assert.Equal(msg.Destination, r.Header.Get("X-Webpa-Device-Name"))
+		if msg.Destination == "mac:112233445599" {
+			w.Header().Set("X-Xmidt-Error", "device not found")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := &batchSendHandler{
+		sender: &wrpSender{
+			fanoutHandler: fanoutHandler,
+			validators:    validators,
+			access:        access,
+			concurrency:   2,
+		},
+		maxBodySize: defaultBatchMaxBodySize,
+	}
+
+	body, err := json.Marshal([]wrp.Message{
+		{Type: wrp.SimpleEventMessageType, Destination: "mac:112233445566", TransactionUUID: "ok"},
+		{Type: wrp.SimpleEventMessageType, Destination: "mac:112233445599", TransactionUUID: "offline"},
+		{Type: wrp.SimpleEventMessageType, Destination: "dns:talaria.example.com", TransactionUUID: "invalid"},
+		{Type: wrp.SimpleEventMessageType, Destination: "mac:112233445577", TransactionUUID: "forbidden"},
+	})
+	require.NoError(err)
+
+	request := httptest.NewRequest(http.MethodPost, "/api/v3/devices/batch", bytes.NewReader(body))
+	request.Header.Set("Content-Type", wrp.JSON.ContentType())
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+
+	require.Equal(http.StatusOK, response.Code)
+
+	var results map[string]batchSendResult
+	require.NoError(json.NewDecoder(response.Body).Decode(&results))
+	require.Len(results, 4)
+
+	assert.Equal(batchSendResult{Destination: "mac:112233445566", Status: http.StatusOK}, results["ok"])
+	assert.Equal(batchSendResult{Destination: "mac:112233445599", Status: http.StatusNotFound, Error: "device not found"}, results["offline"])
+	assert.Equal(http.StatusBadRequest, results["invalid"].Status)
+	assert.NotEmpty(results["invalid"].Error)
+	assert.Equal(http.StatusForbidden, results["forbidden"].Status)
+	assert.Equal(ErrPIDMismatch.Error(), results["forbidden"].Error)
+	assert.Equal(int32(2), atomic.LoadInt32(&fanouts))
+}
+
+func TestBatchConfigSendConcurrency(t *testing.T) {
+	tests := []struct {
+		name              string
+		cfg               BatchConfig
+		fanoutConcurrency int
+		expected          int
+	}{
+		{
+			name:              "default",
+			fanoutConcurrency: 100,
+			expected:          defaultBatchConcurrency,
+		},
+		{
+			name:              "configured",
+			cfg:               BatchConfig{Concurrency: 8},
+			fanoutConcurrency: 100,
+			expected:          8,
+		},
+		{
+			name:              "capped at half the fanout",
+			cfg:               BatchConfig{Concurrency: 8},
+			fanoutConcurrency: 10,
+			expected:          5,
+		},
+		{
+			name:     "default fanout",
+			cfg:      BatchConfig{Concurrency: 50},
+			expected: defaultFanoutConcurrency / 2,
+		},
+		{
+			name:              "at least one",
+			fanoutConcurrency: 1,
+			expected:          1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.cfg.sendConcurrency(tt.fanoutConcurrency))
+		})
+	}
+}
+
+func TestBatchSendHandlerBodyTooLarge(t *testing.T) {
+	handler := &batchSendHandler{
+		sender: &wrpSender{
+			fanoutHandler: http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+				t.Error("a rejected batch must not be fanned out")
+			}),
+		},
+		maxBodySize: 16,
+	}
+
+	body, err := json.Marshal([]wrp.Message{
+		{Type: wrp.SimpleEventMessageType, Destination: "mac:112233445566", TransactionUUID: "1"},
+	})
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodPost, "/api/v3/devices/batch", bytes.NewReader(body))
+	request.Header.Set("Content-Type", wrp.JSON.ContentType())
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+}
+
+func TestBatchSendHandlerBadBatch(t *testing.T) {
+	handler := &batchSendHandler{
+		sender: &wrpSender{
+			fanoutHandler: http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+				t.Error("a rejected batch must not be fanned out")
+			}),
+		},
+		maxBodySize: defaultBatchMaxBodySize,
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/api/v3/devices/batch", bytes.NewReader([]byte("[]")))
+	request.Header.Set("Content-Type", wrp.JSON.ContentType())
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+}
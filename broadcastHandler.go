@@ -0,0 +1,256 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/xmidt-org/webpa-common/v2/device"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrp-go/v3/wrphttp"
+)
+
+// multipart form fields of a broadcast request
+const (
+	broadcastMessageField = "message"
+	broadcastDevicesField = "devices"
+)
+
+// defaultBroadcastMaxBodySize bounds the size of a broadcast request.
+const defaultBroadcastMaxBodySize = 10 << 20
+
+// BroadcastConfig drives the broadcast send endpoint through the broadcast configuration.
+type BroadcastConfig struct {
+	// MaxDevices is the maximum number of devices a single broadcast may target.
+	// Zero means there is no limit.
+	MaxDevices int
+
+	// MaxBodySize is the maximum size in bytes of a broadcast request, including an uploaded
+	// devices file.  Defaults to 10 MiB.
+	MaxBodySize int64
+}
+
+func (c BroadcastConfig) maxBodySize() int64 {
+	if c.MaxBodySize < 1 {
+		return defaultBroadcastMaxBodySize
+	}
+
+	return c.MaxBodySize
+}
+
+// broadcastRequest is the JSON body of a broadcast request.
+type broadcastRequest struct {
+	Devices []string    `json:"devices"`
+	Message wrp.Message `json:"message"`
+}
+
+// broadcastResponse summarizes the outcome of a broadcast.
+type broadcastResponse struct {
+	Total     int                        `json:"total"`
+	Succeeded int                        `json:"succeeded"`
+	Failed    int                        `json:"failed"`
+	Results   map[string]batchSendResult `json:"results"`
+}
+
+// broadcastHandler sends one WRP message template to many devices.  The Destination of the
+// template is rewritten for each device and every copy is sent on its own, so the fanout's
+// device name key routes each copy to the talaria that owns the device.
+type broadcastHandler struct {
+	sender      *wrpSender
+	maxDevices  int
+	maxBodySize int64
+}
+
+func (h *broadcastHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize)
+	template, names, err := decodeBroadcast(r)
+	if err != nil {
+		writeRequestError(w, r, requestBodyErrorStatus(err), err.Error())
+		return
+	}
+
+	messages, err := h.messages(template, names)
+	if err != nil {
+		writeRequestError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response := broadcastResponse{
+		Total: len(messages),
+		Results: h.sender.sendAll(r, messages, func(msg *wrp.Message) string {
+			id, _ := device.ParseID(msg.Destination)
+			return string(id)
+		}),
+	}
+
+	for _, result := range response.Results {
+		if result.Status >= http.StatusOK && result.Status < http.StatusMultipleChoices {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	// nolint:errchkjson
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// messages creates a copy of the template for each distinct device.
+func (h *broadcastHandler) messages(template *wrp.Message, names []string) ([]wrp.Message, error) {
+	var service string
+	if len(template.Destination) > 0 {
+		l, err := wrp.ParseLocator(template.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message destination: %w", err)
+		}
+
+		service = l.Service + l.Ignored
+		if len(l.Service) > 0 {
+			service = "/" + service
+		}
+	}
+
+	var (
+		messages = make([]wrp.Message, 0, len(names))
+		seen     = make(map[device.ID]bool, len(names))
+	)
+
+	for _, name := range names {
+		id, err := device.ParseID(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device id [%s]: %w", name, err)
+		}
+
+		if seen[id] {
+			continue
+		}
+
+		seen[id] = true
+		msg := *template
+		msg.Destination = string(id) + service
+		messages = append(messages, msg)
+	}
+
+	if len(messages) == 0 {
+		return nil, errors.New("broadcast must target at least one device")
+	}
+
+	if h.maxDevices > 0 && len(messages) > h.maxDevices {
+		return nil, fmt.Errorf("broadcast to %d devices exceeds the limit of %d", len(messages), h.maxDevices)
+	}
+
+	return messages, nil
+}
+
+// decodeBroadcast reads the message template and device names of a broadcast, either from a JSON
+// body or from a multipart form with a message part and a devices file of one device id per line.
+func decodeBroadcast(r *http.Request) (*wrp.Message, []string, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid Content-Type: %w", err)
+	}
+
+	switch mediaType {
+	case wrp.JSON.ContentType():
+		var request broadcastRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode broadcast request: %w", err)
+		}
+
+		return &request.Message, request.Devices, nil
+
+	case "multipart/form-data":
+		return decodeBroadcastForm(multipart.NewReader(r.Body, params["boundary"]))
+	}
+
+	return nil, nil, fmt.Errorf("unsupported Content-Type [%s]", mediaType)
+}
+
+func decodeBroadcastForm(reader *multipart.Reader) (*wrp.Message, []string, error) {
+	var (
+		template *wrp.Message
+		names    []string
+	)
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read broadcast form: %w", err)
+		}
+
+		switch part.FormName() {
+		case broadcastMessageField:
+			template, err = decodeBroadcastMessage(part)
+		case broadcastDevicesField:
+			names, err = readDeviceNames(part)
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if template == nil {
+		return nil, nil, fmt.Errorf("broadcast form is missing the %s field", broadcastMessageField)
+	}
+
+	return template, names, nil
+}
+
+func decodeBroadcastMessage(part *multipart.Part) (*wrp.Message, error) {
+	format, err := wrphttp.DetermineFormat(wrp.JSON, http.Header(part.Header), "Content-Type")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(part)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read broadcast message: %w", err)
+	}
+
+	var msg wrp.Message
+	if err := wrp.NewDecoderBytes(body, format).Decode(&msg); err != nil {
+		return nil, fmt.Errorf("failed to decode broadcast message: %w", err)
+	}
+
+	return &msg, nil
+}
+
+// readDeviceNames reads one device name per line, skipping blank lines and # comments.
+func readDeviceNames(r io.Reader) ([]string, error) {
+	var (
+		names   []string
+		scanner = bufio.NewScanner(r)
+	)
+
+	for scanner.Scan() {
+		line := string(bytes.TrimSpace(scanner.Bytes()))
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		names = append(names, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read devices: %w", err)
+	}
+
+	return names, nil
+}
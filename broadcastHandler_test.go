@@ -0,0 +1,264 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrp-go/v3/wrpcontext"
+)
+
+func newBroadcastForm(t *testing.T, msg wrp.Message, devices string) (*bytes.Buffer, string) {
+	var (
+		body   bytes.Buffer
+		writer = multipart.NewWriter(&body)
+	)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="message"`)
+	header.Set("Content-Type", wrp.Msgpack.ContentType())
+	part, err := writer.CreatePart(header)
+	require.NoError(t, err)
+	_, err = part.Write(wrp.MustEncode(msg, wrp.Msgpack))
+	require.NoError(t, err)
+
+	file, err := writer.CreateFormFile("devices", "devices.txt")
+	require.NoError(t, err)
+	_, err = file.Write([]byte(devices))
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Close())
+	return &body, writer.FormDataContentType()
+}
+
+func TestDecodeBroadcast(t *testing.T) {
+	template := wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "mac:000000000000/config", Source: "dns:scytale.example.com"}
+
+	jsonBody, err := json.Marshal(broadcastRequest{
+		Devices: []string{"mac:112233445566", "uuid:1234"},
+		Message: template,
+	})
+	require.NoError(t, err)
+
+	formBody, formContentType := newBroadcastForm(t, template, "# devices\nmac:112233445566\n\n  uuid:1234  \n")
+	noMessageForm, noMessageContentType := func() (*bytes.Buffer, string) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		require.NoError(t, writer.WriteField("devices", "mac:112233445566"))
+		require.NoError(t, writer.Close())
+		return &body, writer.FormDataContentType()
+	}()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		shouldErr   bool
+	}{
+		{
+			name:        "json",
+			contentType: wrp.JSON.ContentType(),
+			body:        jsonBody,
+		},
+		{
+			name:        "multipart form",
+			contentType: formContentType,
+			body:        formBody.Bytes(),
+		},
+		{
+			name:        "multipart form without message",
+			contentType: noMessageContentType,
+			body:        noMessageForm.Bytes(),
+			shouldErr:   true,
+		},
+		{
+			name:        "invalid json",
+			contentType: wrp.JSON.ContentType(),
+			body:        []byte("{"),
+			shouldErr:   true,
+		},
+		{
+			name:        "unsupported content type",
+			contentType: "text/plain",
+			body:        jsonBody,
+			shouldErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/v3/devices/broadcast", bytes.NewReader(tt.body))
+			request.Header.Set("Content-Type", tt.contentType)
+
+			msg, names, err := decodeBroadcast(request)
+			if tt.shouldErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, template.Destination, msg.Destination)
+			assert.Equal(t, template.Source, msg.Source)
+			assert.Equal(t, []string{"mac:112233445566", "uuid:1234"}, names)
+		})
+	}
+}
+
+func TestBroadcastHandlerMessages(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxDevices   int
+		destination  string
+		names        []string
+		expectedDest []string
+		shouldErr    bool
+	}{
+		{
+			name:         "service is kept",
+			destination:  "mac:000000000000/config/ignored",
+			names:        []string{"mac:112233445566", "uuid:1234"},
+			expectedDest: []string{"mac:112233445566/config/ignored", "uuid:1234/config/ignored"},
+		},
+		{
+			name:         "no template destination",
+			names:        []string{"mac:112233445566"},
+			expectedDest: []string{"mac:112233445566"},
+		},
+		{
+			name:         "duplicates are dropped",
+			names:        []string{"mac:112233445566", "mac:112233445566"},
+			expectedDest: []string{"mac:112233445566"},
+		},
+		{
+			name:      "invalid device id",
+			names:     []string{"112233445566"},
+			shouldErr: true,
+		},
+		{
+			name:      "no devices",
+			shouldErr: true,
+		},
+		{
+			name:       "too many devices",
+			maxDevices: 1,
+			names:      []string{"mac:112233445566", "uuid:1234"},
+			shouldErr:  true,
+		},
+		{
+			name:        "invalid template destination",
+			destination: "bogus",
+			names:       []string{"mac:112233445566"},
+			shouldErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &broadcastHandler{maxDevices: tt.maxDevices}
+			messages, err := h.messages(&wrp.Message{Destination: tt.destination}, tt.names)
+			if tt.shouldErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			destinations := make([]string, 0, len(messages))
+			for _, msg := range messages {
+				destinations = append(destinations, msg.Destination)
+			}
+
+			assert.Equal(t, tt.expectedDest, destinations)
+		})
+	}
+}
+
+func TestBroadcastHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var (
+		lock        sync.Mutex
+		deviceNames []string
+	)
+
+	handler := &broadcastHandler{
+		sender: &wrpSender{
+			fanoutHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				msg, ok := wrpcontext.GetMessage(r.Context())
+				if !assert.True(ok) {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				lock.Lock()
+				deviceNames = append(deviceNames, r.Header.Get("X-Webpa-Device-Name"))
+				lock.Unlock()
+
+				if strings.HasPrefix(msg.Destination, "uuid:") {
+					w.Header().Set("X-Xmidt-Error", "device not found")
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				w.WriteHeader(http.StatusOK)
+			}),
+			concurrency: 2,
+		},
+		maxBodySize: defaultBroadcastMaxBodySize,
+	}
+
+	body, contentType := newBroadcastForm(t,
+		wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "mac:000000000000/config"},
+		"mac:112233445566\nmac:112233445577\nuuid:1234\n")
+
+	request := httptest.NewRequest(http.MethodPost, "/api/v3/devices/broadcast", body)
+	request.Header.Set("Content-Type", contentType)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+
+	require.Equal(http.StatusOK, response.Code)
+
+	var summary broadcastResponse
+	require.NoError(json.NewDecoder(response.Body).Decode(&summary))
+
+	assert.Equal(3, summary.Total)
+	assert.Equal(2, summary.Succeeded)
+	assert.Equal(1, summary.Failed)
+	assert.Equal(batchSendResult{Destination: "mac:112233445566/config", Status: http.StatusOK}, summary.Results["mac:112233445566"])
+	assert.Equal(batchSendResult{Destination: "uuid:1234/config", Status: http.StatusNotFound, Error: "device not found"}, summary.Results["uuid:1234"])
+	assert.ElementsMatch([]string{"mac:112233445566/config", "mac:112233445577/config", "uuid:1234/config"}, deviceNames)
+}
+
+func TestBroadcastHandlerBodyTooLarge(t *testing.T) {
+	handler := &broadcastHandler{
+		sender: &wrpSender{
+			fanoutHandler: http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+				t.Error("a rejected broadcast must not be fanned out")
+			}),
+		},
+		maxBodySize: 64,
+	}
+
+	body, contentType := newBroadcastForm(t,
+		wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "mac:000000000000/config"},
+		strings.Repeat("mac:112233445566\n", 16))
+
+	request := httptest.NewRequest(http.MethodPost, "/api/v3/devices/broadcast", body)
+	request.Header.Set("Content-Type", contentType)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+}
@@ -0,0 +1,502 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/webpa-common/v2/xhttp"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// The effects of capability policy rules.
+const (
+	effectAllow = "allow"
+	effectDeny  = "deny"
+)
+
+// ruleTypeCapability is the rule type that matches when one of the token's capabilities passes
+// the regex capability check.
+const ruleTypeCapability = "capability"
+
+// wrpSendPaths are the paths, without the API version, of the routes that carry WRP messages.
+var wrpSendPaths = []string{"/device", "/devices/batch", "/devices/broadcast"}
+
+var ErrPolicyDenied = &xhttp.Error{Code: http.StatusForbidden, Text: "WRP message denied by the capability policy"}
+
+// CapabilityRoleConfig is a named role, granted by any of its capabilities.
+type CapabilityRoleConfig struct {
+	Name string
+
+	// Capabilities are the token capabilities that grant the role.
+	Capabilities []string
+}
+
+// CapabilityRuleConfig is a capability policy rule.  A rule matches when all of its conditions
+// do, and empty conditions match everything.
+type CapabilityRuleConfig struct {
+	// Name identifies the rule in the explanation of a decision.  Defaults to its position.
+	Name string
+
+	// Effect is allow or deny.
+	Effect string
+
+	// Type is capability for rules that also require a token capability to pass the regex
+	// capability check.
+	Type string
+
+	// Roles are the roles of which the token must have one.
+	Roles []string
+
+	// Principals are regular expressions matched against the whole principal.
+	Principals []string
+
+	// Partners are the partners of which the token must allow one.  * matches any partner.
+	Partners []string
+
+	// Endpoints are path templates, without the API version, such as /device/{deviceID}/stat.
+	// A {name} variable matches one path segment, and {name:regex} matches the regex.
+	Endpoints []string
+
+	// Methods are the request methods.
+	Methods []string
+
+	// MessageTypes are the names of the WRP message types.  Rules with message types or
+	// destinations only apply to WRP messages.
+	MessageTypes []string
+
+	// Destinations are regular expressions matched against the whole WRP destination.
+	Destinations []string
+}
+
+// CapabilityPolicyConfig is the policy file of the capability check.
+type CapabilityPolicyConfig struct {
+	Roles []CapabilityRoleConfig
+
+	// Rules are evaluated in order, and the first that matches decides.  Requests that match
+	// no rule are denied.
+	Rules []CapabilityRuleConfig
+}
+
+type capabilityRule struct {
+	name         string
+	effect       string
+	capability   bool
+	roles        []string
+	principals   []*regexp.Regexp
+	partners     []string
+	endpoints    []*regexp.Regexp
+	methods      []string
+	messageTypes map[wrp.MessageType]bool
+	destinations []*regexp.Regexp
+}
+
+// capabilityPolicy is a compiled CapabilityPolicyConfig.
+type capabilityPolicy struct {
+	// roles are the roles granted by each capability.
+	roles map[string][]string
+	rules []capabilityRule
+	check endpointRegexCheck
+}
+
+// policyInput is what a capability policy decides on.  message is nil until the request's WRP
+// messages are decoded.
+type policyInput struct {
+	principal    string
+	partners     []string
+	capabilities []string
+	method       string
+	path         string
+	carriesWRP   bool
+	message      *wrp.Message
+}
+
+// policyDecision is the outcome of a capability policy.  A deferred decision waits for the WRP
+// message.  explain holds the reason each rule was skipped, and the final decision.
+type policyDecision struct {
+	allowed    bool
+	deferred   bool
+	rule       string
+	capability string
+	explain    []string
+}
+
+// loadCapabilityPolicy reads a YAML or JSON policy file.  Capability rules use the regex check.
+func loadCapabilityPolicy(filename string, check endpointRegexCheck) (*capabilityPolicy, error) {
+	pv := viper.New()
+	pv.SetConfigFile(filename)
+	if err := pv.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read capability policy: %w", err)
+	}
+
+	var cfg CapabilityPolicyConfig
+	if err := pv.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode capability policy: %w", err)
+	}
+
+	return newCapabilityPolicy(cfg, check)
+}
+
+func newCapabilityPolicy(cfg CapabilityPolicyConfig, check endpointRegexCheck) (*capabilityPolicy, error) {
+	p := &capabilityPolicy{
+		roles: make(map[string][]string),
+		check: check,
+	}
+
+	names := make(map[string]bool, len(cfg.Roles))
+	for i, role := range cfg.Roles {
+		if len(role.Name) == 0 {
+			return nil, fmt.Errorf("capability policy role %d has no name", i)
+		}
+
+		names[role.Name] = true
+		for _, capability := range role.Capabilities {
+			p.roles[capability] = append(p.roles[capability], role.Name)
+		}
+	}
+
+	for i, ruleConfig := range cfg.Rules {
+		rule, err := newCapabilityRule(ruleConfig, names)
+		if err != nil {
+			return nil, fmt.Errorf("capability policy rule %d: %w", i, err)
+		}
+
+		if len(rule.name) == 0 {
+			rule.name = fmt.Sprintf("rule %d", i)
+		}
+
+		p.rules = append(p.rules, rule)
+	}
+
+	return p, nil
+}
+
+func newCapabilityRule(cfg CapabilityRuleConfig, roles map[string]bool) (capabilityRule, error) {
+	r := capabilityRule{
+		name:     cfg.Name,
+		effect:   cfg.Effect,
+		partners: cfg.Partners,
+		roles:    cfg.Roles,
+	}
+
+	if r.effect != effectAllow && r.effect != effectDeny {
+		return r, fmt.Errorf("invalid effect [%s]", r.effect)
+	}
+
+	switch cfg.Type {
+	case "":
+	case ruleTypeCapability:
+		r.capability = true
+	default:
+		return r, fmt.Errorf("invalid rule type [%s]", cfg.Type)
+	}
+
+	for _, role := range cfg.Roles {
+		if !roles[role] {
+			return r, fmt.Errorf("unknown role [%s]", role)
+		}
+	}
+
+	var err error
+	if r.principals, err = compileAnchored(cfg.Principals); err != nil {
+		return r, fmt.Errorf("invalid principal: %w", err)
+	}
+
+	if r.destinations, err = compileAnchored(cfg.Destinations); err != nil {
+		return r, fmt.Errorf("invalid destination: %w", err)
+	}
+
+	for _, template := range cfg.Endpoints {
+		re, err := compileEndpointTemplate(template)
+		if err != nil {
+			return r, err
+		}
+
+		r.endpoints = append(r.endpoints, re)
+	}
+
+	for _, method := range cfg.Methods {
+		r.methods = append(r.methods, strings.ToUpper(method))
+	}
+
+	if len(cfg.MessageTypes) > 0 {
+		r.messageTypes = make(map[wrp.MessageType]bool, len(cfg.MessageTypes))
+		for _, name := range cfg.MessageTypes {
+			mt := wrp.StringToMessageType(name)
+			if !validMessageType(mt) {
+				return r, fmt.Errorf("invalid message type [%s]", name)
+			}
+
+			r.messageTypes[mt] = true
+		}
+	}
+
+	return r, nil
+}
+
+// compileAnchored compiles regular expressions that must match the whole value.
+func compileAnchored(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, re)
+	}
+
+	return res, nil
+}
+
+// compileEndpointTemplate compiles a path template into a regular expression matching the whole
+// path.  The regex of a {name:regex} variable cannot contain braces.
+func compileEndpointTemplate(template string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	rest := urlPathNormalization(template)
+	for len(rest) > 0 {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			b.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unbalanced braces in endpoint template [%s]", template)
+		}
+
+		b.WriteString(regexp.QuoteMeta(rest[:start]))
+		name, pattern, ok := strings.Cut(rest[start+1:start+end], ":")
+		if len(name) == 0 {
+			return nil, fmt.Errorf("unnamed variable in endpoint template [%s]", template)
+		}
+
+		if !ok {
+			pattern = "[^/]+"
+		}
+
+		b.WriteString("(?:" + pattern + ")")
+		rest = rest[start+end+1:]
+	}
+
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint template [%s]: %w", template, err)
+	}
+
+	return re, nil
+}
+
+func matchesAny(res []*regexp.Regexp, value string) bool {
+	return slices.ContainsFunc(res, func(re *regexp.Regexp) bool {
+		return re.MatchString(value)
+	})
+}
+
+// matches reports whether the rule matches the input, or why it does not.  A rule with message
+// conditions is pending until the message of a WRP route is known.
+func (r *capabilityRule) matches(in policyInput, roles map[string]bool, matcher *capabilityMatcher) (matched, pending bool, capability, reason string) {
+	switch {
+	case len(r.roles) > 0 && !slices.ContainsFunc(r.roles, func(role string) bool { return roles[role] }):
+		return false, false, "", "no role"
+	case len(r.principals) > 0 && !matchesAny(r.principals, in.principal):
+		return false, false, "", "principal did not match"
+	case len(r.partners) > 0 && !slices.ContainsFunc(in.partners, func(partner string) bool {
+		return contains(r.partners, "*") || contains(r.partners, partner)
+	}):
+		return false, false, "", "partners did not match"
+	case len(r.endpoints) > 0 && !matchesAny(r.endpoints, in.path):
+		return false, false, "", "endpoint did not match"
+	case len(r.methods) > 0 && !contains(r.methods, strings.ToUpper(in.method)):
+		return false, false, "", "method did not match"
+	}
+
+	if r.capability {
+		var ok bool
+		if capability, ok = matcher.match(in.path, in.method); !ok {
+			return false, false, "", "no capability matched"
+		}
+	}
+
+	if len(r.messageTypes) == 0 && len(r.destinations) == 0 {
+		return true, false, capability, ""
+	}
+
+	switch {
+	case in.message == nil && in.carriesWRP:
+		return false, true, capability, "waiting for the WRP message"
+	case in.message == nil:
+		return false, false, "", "not a WRP message"
+	case len(r.messageTypes) > 0 && !r.messageTypes[in.message.Type]:
+		return false, false, "", "message type did not match"
+	case len(r.destinations) > 0 && !matchesAny(r.destinations, in.message.Destination):
+		return false, false, "", "destination did not match"
+	}
+
+	return true, false, capability, ""
+}
+
+// rolesOf returns the roles granted by the capabilities.
+func (p *capabilityPolicy) rolesOf(capabilities []string) map[string]bool {
+	roles := make(map[string]bool)
+	for _, capability := range capabilities {
+		for _, role := range p.roles[capability] {
+			roles[role] = true
+		}
+	}
+
+	return roles
+}
+
+// evaluate runs the rules in order.  The first rule that matches decides, and a pending rule
+// defers the decision to the WRP message.
+func (p *capabilityPolicy) evaluate(in policyInput) policyDecision {
+	var (
+		d       policyDecision
+		roles   = p.rolesOf(in.capabilities)
+		matcher = p.check.matcher(in.capabilities)
+	)
+
+	for i := range p.rules {
+		rule := &p.rules[i]
+		matched, pending, capability, reason := rule.matches(in, roles, matcher)
+		switch {
+		case pending:
+			d.deferred = true
+			d.rule = rule.name
+			d.explain = append(d.explain, fmt.Sprintf("%s (%s): %s", rule.name, rule.effect, reason))
+			return d
+
+		case !matched:
+			d.explain = append(d.explain, fmt.Sprintf("%s (%s): %s", rule.name, rule.effect, reason))
+			continue
+		}
+
+		d.allowed = rule.effect == effectAllow
+		d.rule = rule.name
+		d.capability = capability
+		d.explain = append(d.explain, fmt.Sprintf("%s (%s): matched", rule.name, rule.effect))
+		return d
+	}
+
+	d.explain = append(d.explain, "no rule matched: denied")
+	return d
+}
+
+// reason returns the metric reason of a denial.
+func (d policyDecision) reason() string {
+	if len(d.rule) == 0 {
+		return NoPolicyRuleMatch
+	}
+
+	return PolicyDenied
+}
+
+// carriesWRP reports whether the trimmed path and method are those of a route that carries WRP
+// messages.
+func carriesWRP(path, method string) bool {
+	return (method == http.MethodPost || method == http.MethodPut) && contains(wrpSendPaths, path)
+}
+
+// newPolicyInput is the request stage input of a capability policy for the token, method and
+// trimmed path.
+func newPolicyInput(token bascule.Token, method, path string) policyInput {
+	in := policyInput{
+		principal:  token.Principal(),
+		method:     method,
+		path:       path,
+		carriesWRP: carriesWRP(path, method),
+	}
+
+	if accessor, ok := token.(bascule.AttributesAccessor); ok {
+		in.partners = tokenPartners(accessor)
+		in.capabilities = tokenCapabilities(accessor)
+	}
+
+	return in
+}
+
+// tokenCapabilities returns the capabilities of the token, if any.
+func tokenCapabilities(accessor bascule.AttributesAccessor) []string {
+	raw, ok := bascule.GetAttribute[any](accessor, "capabilities")
+	if !ok {
+		return nil
+	}
+
+	capabilities, _ := bascule.GetCapabilities(raw)
+	return capabilities
+}
+
+// tokenPartners returns the partners the token allows, if any.
+func tokenPartners(accessor bascule.AttributesAccessor) []string {
+	raw, ok := bascule.GetAttribute[any](accessor, partnerKeys...)
+	if !ok {
+		return nil
+	}
+
+	partners, _ := cast.ToStringSliceE(raw)
+	return partners
+}
+
+// capabilityPolicyAccess is the wrpAccessAuthority that finishes the capability policy decisions
+// deferred to the WRP messages.
+type capabilityPolicyAccess struct {
+	auth    *authReloader
+	counter metrics.Counter
+}
+
+func (a *capabilityPolicyAccess) authorizeWRP(ctx context.Context, msg *wrp.Message) (bool, error) {
+	vals, ok := FromContext(ctx)
+	if !ok || !vals.PolicyDeferred {
+		return false, nil
+	}
+
+	cs := a.auth.load().capabilities
+	if cs == nil || cs.policy == nil {
+		return false, nil
+	}
+
+	token, ok := bascule.Get(ctx)
+	if !ok {
+		return false, ErrTokenMissing
+	}
+
+	in := newPolicyInput(token, vals.Method, trimVersionPrefix(vals.Path))
+	in.carriesWRP = true
+	in.message = msg
+
+	decision := cs.policy.evaluate(in)
+	labels := []string{
+		ClientIDLabel, in.principal,
+		PartnerIDLabel, determinePartnerMetric(in.partners),
+		EndpointLabel, determineEndpointMetric(cs.endpointBuckets, in.path),
+	}
+
+	if decision.allowed {
+		// the audit record and the fanout carry the capability the message was allowed with
+		vals.Capability = decision.capability
+		a.counter.With(append(labels, OutcomeLabel, Accepted, ReasonLabel, "")...).Add(1)
+		return false, nil
+	}
+
+	if !cs.enforce {
+		a.counter.With(append(labels, OutcomeLabel, Accepted, ReasonLabel, decision.reason())...).Add(1)
+		return false, nil
+	}
+
+	a.counter.With(append(labels, OutcomeLabel, Rejected, ReasonLabel, decision.reason())...).Add(1)
+	return false, ErrPolicyDenied
+}
@@ -0,0 +1,457 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.uber.org/zap"
+)
+
+const testCapabilityPolicy = `
+roles:
+  - name: "config"
+    capabilities:
+      - "x1:webpa:api:config"
+rules:
+  - name: "no sky reboots"
+    effect: "deny"
+    partners: ["sky"]
+    messageTypes: ["SimpleRequestResponse"]
+    destinations: ["mac:[0-9a-f]+/reboot"]
+  - name: "config writers"
+    effect: "allow"
+    roles: ["config"]
+    endpoints: ["/device", "/device/{deviceID}/config"]
+    methods: ["POST", "GET"]
+  - name: "stat readers"
+    effect: "allow"
+    principals: ["stat-.*"]
+    endpoints: ["/device/{deviceID:mac:[0-9a-f]+}/stat"]
+  - name: "legacy"
+    effect: "allow"
+    type: "capability"
+`
+
+func newTestCapabilityPolicy(t *testing.T) *capabilityPolicy {
+	filename := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(testCapabilityPolicy), 0600))
+
+	ec, err := newEndpointRegexCheck("x1:webpa:api:", "all")
+	require.NoError(t, err)
+
+	p, err := loadCapabilityPolicy(filename, ec)
+	require.NoError(t, err)
+	return p
+}
+
+func TestCompileEndpointTemplate(t *testing.T) {
+	tests := []struct {
+		template    string
+		matches     []string
+		mismatches  []string
+		expectedErr bool
+	}{
+		{
+			template:   "/device",
+			matches:    []string{"/device"},
+			mismatches: []string{"/device/mac:112233445566/stat", "/devices"},
+		},
+		{
+			template:   "device/{deviceID}/stat",
+			matches:    []string{"/device/mac:112233445566/stat"},
+			mismatches: []string{"/device/mac:112233445566/config", "/device/a/b/stat"},
+		},
+		{
+			template:   "/hook/{id:[0-9]+}",
+			matches:    []string{"/hook/12"},
+			mismatches: []string{"/hook/ab"},
+		},
+		{
+			template:    "/device/{deviceID",
+			expectedErr: true,
+		},
+		{
+			template:    "/device/{}",
+			expectedErr: true,
+		},
+		{
+			template:    "/device/{id:(}",
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			re, err := compileEndpointTemplate(tt.template)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			for _, path := range tt.matches {
+				assert.True(t, re.MatchString(path), path)
+			}
+
+			for _, path := range tt.mismatches {
+				assert.False(t, re.MatchString(path), path)
+			}
+		})
+	}
+}
+
+func TestNewCapabilityPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  CapabilityPolicyConfig
+	}{
+		{
+			name: "unnamed role",
+			cfg:  CapabilityPolicyConfig{Roles: []CapabilityRoleConfig{{}}},
+		},
+		{
+			name: "invalid effect",
+			cfg:  CapabilityPolicyConfig{Rules: []CapabilityRuleConfig{{Effect: "maybe"}}},
+		},
+		{
+			name: "invalid type",
+			cfg:  CapabilityPolicyConfig{Rules: []CapabilityRuleConfig{{Effect: effectAllow, Type: "jwt"}}},
+		},
+		{
+			name: "unknown role",
+			cfg:  CapabilityPolicyConfig{Rules: []CapabilityRuleConfig{{Effect: effectAllow, Roles: []string{"admin"}}}},
+		},
+		{
+			name: "invalid principal",
+			cfg:  CapabilityPolicyConfig{Rules: []CapabilityRuleConfig{{Effect: effectAllow, Principals: []string{"("}}}},
+		},
+		{
+			name: "invalid destination",
+			cfg:  CapabilityPolicyConfig{Rules: []CapabilityRuleConfig{{Effect: effectAllow, Destinations: []string{"("}}}},
+		},
+		{
+			name: "invalid endpoint",
+			cfg:  CapabilityPolicyConfig{Rules: []CapabilityRuleConfig{{Effect: effectAllow, Endpoints: []string{"/{"}}}},
+		},
+		{
+			name: "invalid message type",
+			cfg:  CapabilityPolicyConfig{Rules: []CapabilityRuleConfig{{Effect: effectAllow, MessageTypes: []string{"Unknown"}}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newCapabilityPolicy(tt.cfg, endpointRegexCheck{})
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := loadCapabilityPolicy(filepath.Join(t.TempDir(), "missing.yaml"), endpointRegexCheck{})
+	assert.Error(t, err)
+}
+
+func TestLoadCapabilityPolicyJSON(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(filename, []byte(`{"rules": [{"effect": "allow", "methods": ["get"]}]}`), 0600))
+
+	p, err := loadCapabilityPolicy(filename, endpointRegexCheck{})
+	require.NoError(t, err)
+	require.Len(t, p.rules, 1)
+	assert.Equal(t, "rule 0", p.rules[0].name)
+	assert.Equal(t, []string{http.MethodGet}, p.rules[0].methods)
+}
+
+func TestCapabilityPolicyEvaluate(t *testing.T) {
+	p := newTestCapabilityPolicy(t)
+	tests := []struct {
+		name               string
+		in                 policyInput
+		expectedAllowed    bool
+		expectedDeferred   bool
+		expectedRule       string
+		expectedCapability string
+		expectedExplain    int
+	}{
+		{
+			name: "role",
+			in: policyInput{
+				principal:    "client",
+				capabilities: []string{"x1:webpa:api:config"},
+				method:       http.MethodGet,
+				path:         "/device/mac:112233445566/config",
+			},
+			expectedAllowed: true,
+			expectedRule:    "config writers",
+			expectedExplain: 2,
+		},
+		{
+			name: "principal and endpoint variable",
+			in: policyInput{
+				principal: "stat-reader",
+				method:    http.MethodGet,
+				path:      "/device/mac:112233445566/stat",
+			},
+			expectedAllowed: true,
+			expectedRule:    "stat readers",
+			expectedExplain: 3,
+		},
+		{
+			name: "capability rule",
+			in: policyInput{
+				principal:    "client",
+				capabilities: []string{"x1:webpa:api:config", "x1:webpa:api:device/.*/stat:get"},
+				method:       http.MethodGet,
+				path:         "/device/mac:112233445566/stat",
+			},
+			expectedAllowed:    true,
+			expectedRule:       "legacy",
+			expectedCapability: "x1:webpa:api:device/.*/stat:get",
+			expectedExplain:    4,
+		},
+		{
+			name: "denied by default",
+			in: policyInput{
+				principal: "client",
+				method:    http.MethodGet,
+				path:      "/hooks",
+			},
+			expectedExplain: 5,
+		},
+		{
+			name: "deferred on a WRP route",
+			in: policyInput{
+				principal:  "client",
+				partners:   []string{"sky"},
+				method:     http.MethodPost,
+				path:       "/device",
+				carriesWRP: true,
+			},
+			expectedDeferred: true,
+			expectedRule:     "no sky reboots",
+			expectedExplain:  1,
+		},
+		{
+			name: "message denied",
+			in: policyInput{
+				principal:    "client",
+				partners:     []string{"sky"},
+				capabilities: []string{"x1:webpa:api:config"},
+				method:       http.MethodPost,
+				path:         "/device",
+				carriesWRP:   true,
+				message:      &wrp.Message{Type: wrp.SimpleRequestResponseMessageType, Destination: "mac:112233445566/reboot"},
+			},
+			expectedRule:    "no sky reboots",
+			expectedExplain: 1,
+		},
+		{
+			name: "message allowed",
+			in: policyInput{
+				principal:    "client",
+				partners:     []string{"sky"},
+				capabilities: []string{"x1:webpa:api:config"},
+				method:       http.MethodPost,
+				path:         "/device",
+				carriesWRP:   true,
+				message:      &wrp.Message{Type: wrp.SimpleRequestResponseMessageType, Destination: "mac:112233445566/config"},
+			},
+			expectedAllowed: true,
+			expectedRule:    "config writers",
+			expectedExplain: 2,
+		},
+		{
+			name: "message rules skipped without a WRP route",
+			in: policyInput{
+				principal:    "client",
+				partners:     []string{"sky"},
+				capabilities: []string{"x1:webpa:api:config"},
+				method:       http.MethodGet,
+				path:         "/device/mac:112233445566/config",
+			},
+			expectedAllowed: true,
+			expectedRule:    "config writers",
+			expectedExplain: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			d := p.evaluate(tt.in)
+			assert.Equal(tt.expectedAllowed, d.allowed)
+			assert.Equal(tt.expectedDeferred, d.deferred)
+			assert.Equal(tt.expectedRule, d.rule)
+			assert.Equal(tt.expectedCapability, d.capability)
+			assert.Len(d.explain, tt.expectedExplain)
+		})
+	}
+}
+
+func TestCapabilitySettingsAuthorizePolicy(t *testing.T) {
+	cs := &capabilitySettings{enforce: true, policy: newTestCapabilityPolicy(t)}
+	tests := []struct {
+		name               string
+		method             string
+		path               string
+		claims             map[string]any
+		expectedErr        error
+		expectedCount      float64
+		expectedReason     string
+		expectedCapability string
+		expectedDeferred   bool
+	}{
+		{
+			name:   "allowed",
+			method: http.MethodGet,
+			path:   "/api/v3/device/mac:112233445566/stat",
+			claims: map[string]any{
+				"capabilities": []string{"x1:webpa:api:device/.*/stat:all"},
+			},
+			expectedCount:      1,
+			expectedCapability: "x1:webpa:api:device/.*/stat:all",
+		},
+		{
+			name:           "denied",
+			method:         http.MethodGet,
+			path:           "/api/v3/hooks",
+			claims:         map[string]any{},
+			expectedErr:    bascule.ErrUnauthorized,
+			expectedCount:  1,
+			expectedReason: NoPolicyRuleMatch,
+		},
+		{
+			name:   "deferred",
+			method: http.MethodPost,
+			path:   "/api/v3/device",
+			claims: map[string]any{
+				"allowedResources": map[string]any{"allowedPartners": []string{"sky"}},
+			},
+			expectedDeferred: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			counter := newTestCounter()
+
+			var result *capabilityResult
+			handler := trackCapability(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				result, _ = r.Context().Value(capabilityKey{}).(*capabilityResult)
+				err := cs.authorize(r, &jwtToken{principal: "client", claims: tt.claims}, counter)
+				assert.Equal(tt.expectedErr, err)
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+			assert.Equal(tt.expectedCount, counter.count)
+			assert.Equal(tt.expectedReason, counter.labelPairs[ReasonLabel])
+			require.NotNil(t, result)
+			assert.Equal(tt.expectedCapability, result.capability)
+			assert.Equal(tt.expectedDeferred, result.deferred)
+		})
+	}
+}
+
+func TestCapabilityPolicyAccess(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(testCapabilityPolicy), 0600))
+
+	v := viper.New()
+	v.Set(capabilityCheckConfigKey, map[string]any{
+		"type":            "enforce",
+		"prefix":          "x1:webpa:api:",
+		"acceptAllMethod": "all",
+		"policyFile":      filename,
+	})
+
+	settings, err := loadAuthSettings(v, zap.NewNop(), true)
+	require.NoError(t, err)
+	require.NotNil(t, settings.capabilities.policy)
+
+	tests := []struct {
+		name               string
+		deferred           bool
+		destination        string
+		capabilities       []string
+		expectedErr        error
+		expectedOutcome    string
+		expectedCapability string
+	}{
+		{
+			name:        "not deferred",
+			destination: "mac:112233445566/reboot",
+		},
+		{
+			name:            "allowed",
+			deferred:        true,
+			destination:     "mac:112233445566/config",
+			expectedOutcome: Accepted,
+		},
+		{
+			name:               "allowed by a capability",
+			capabilities:       []string{"x1:webpa:api:device:post"},
+			deferred:           true,
+			destination:        "mac:112233445566/config",
+			expectedOutcome:    Accepted,
+			expectedCapability: "x1:webpa:api:device:post",
+		},
+		{
+			name:            "denied",
+			deferred:        true,
+			destination:     "mac:112233445566/reboot",
+			expectedErr:     ErrPolicyDenied,
+			expectedOutcome: Rejected,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			counter := newTestCounter()
+			a := &capabilityPolicyAccess{auth: newAuthReloader(v, zap.NewNop(), settings, newTestCounter()), counter: counter}
+
+			capabilities := tt.capabilities
+			if len(capabilities) == 0 {
+				capabilities = []string{"x1:webpa:api:config"}
+			}
+
+			token := &jwtToken{
+				principal: "client",
+				claims: map[string]any{
+					"allowedResources": map[string]any{"allowedPartners": []string{"sky"}},
+					"capabilities":     capabilities,
+				},
+			}
+
+			vals := &ContextValues{
+				Method:         http.MethodPost,
+				Path:           "/api/v3/device",
+				PolicyDeferred: tt.deferred,
+			}
+
+			ctx := NewContextWithValue(bascule.WithToken(context.Background(), token), vals)
+
+			modified, err := wrpAccessAuthorities{a}.authorizeWRP(ctx, &wrp.Message{
+				Type:        wrp.SimpleRequestResponseMessageType,
+				Destination: tt.destination,
+			})
+
+			assert.False(modified)
+			assert.Equal(tt.expectedErr, err)
+			assert.Equal(tt.expectedOutcome, counter.labelPairs[OutcomeLabel])
+			assert.Equal(tt.expectedCapability, vals.Capability)
+		})
+	}
+}
@@ -3,7 +3,13 @@
 
 package main
 
-import "context"
+import (
+	"context"
+	"net/http"
+
+	"github.com/spf13/cast"
+	"github.com/xmidt-org/bascule"
+)
 
 type contextKey string
 
@@ -13,6 +19,14 @@ const (
 
 type contextValuesKey struct{}
 
+type capabilityKey struct{}
+
+// capabilityResult is what the capability check found out about a request.
+type capabilityResult struct {
+	capability string
+	deferred   bool
+}
+
 // ContextValues contains the values shared under the satClientIDKey from this package
 type ContextValues struct {
 	SatClientID string
@@ -20,6 +34,15 @@ type ContextValues struct {
 	Path        string
 	PartnerIDs  []string
 	Trust       string
+	TokenType   string
+
+	// Capability is the capability that authorized the request, when the capability check
+	// is enabled.
+	Capability string
+
+	// PolicyDeferred is set when the capability policy can only decide on the request's WRP
+	// messages.
+	PolicyDeferred bool
 }
 
 // NewContextWithValue returns a context with the specified context values
@@ -33,3 +56,74 @@ func FromContext(ctx context.Context) (*ContextValues, bool) {
 	vals, ofType := ctx.Value(contextValuesKey{}).(*ContextValues)
 	return vals, ofType
 }
+
+// populateContextValues is middleware that stores the ContextValues for the authenticated
+// token in the request context.  It must run after the bascule middleware.
+func populateContextValues(delegate http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bascule.Get(r.Context())
+		if !ok {
+			delegate.ServeHTTP(w, r)
+			return
+		}
+
+		vals := &ContextValues{
+			SatClientID: token.Principal(),
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			TokenType:   tokenTypeOf(token),
+		}
+
+		if result, ok := r.Context().Value(capabilityKey{}).(*capabilityResult); ok {
+			vals.Capability = result.capability
+			vals.PolicyDeferred = result.deferred
+		}
+
+		if accessor, ok := token.(bascule.AttributesAccessor); ok {
+			if partnerVal, ok := bascule.GetAttribute[any](accessor, partnerKeys...); ok {
+				vals.PartnerIDs, _ = cast.ToStringSliceE(partnerVal)
+			}
+
+			if trust, ok := accessor.Get("trust"); ok {
+				vals.Trust = cast.ToString(trust)
+			}
+		}
+
+		delegate.ServeHTTP(w, r.WithContext(NewContextWithValue(r.Context(), vals)))
+	})
+}
+
+// trackCapability is middleware that makes room in the request context for the result of the
+// capability check, so that populateContextValues can pick it up.  It must run before the
+// bascule middleware.
+func trackCapability(delegate http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delegate.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), capabilityKey{}, new(capabilityResult))))
+	})
+}
+
+// recordCapability notes the capability that authorized the request, if trackCapability made
+// room for it.
+func recordCapability(ctx context.Context, capability string) {
+	if result, ok := ctx.Value(capabilityKey{}).(*capabilityResult); ok {
+		result.capability = capability
+	}
+}
+
+// deferCapabilityPolicy notes that the capability policy decides on the request's WRP messages,
+// if trackCapability made room for it.
+func deferCapabilityPolicy(ctx context.Context) {
+	if result, ok := ctx.Value(capabilityKey{}).(*capabilityResult); ok {
+		result.deferred = true
+	}
+}
+
+// forwardContextValues is a fanout.FanoutRequestFunc that carries the original request's
+// ContextValues over to each fanout request.
+func forwardContextValues(ctx context.Context, original, _ *http.Request, _ []byte) (context.Context, error) {
+	if vals, ok := FromContext(original.Context()); ok {
+		ctx = NewContextWithValue(ctx, vals)
+	}
+
+	return ctx, nil
+}
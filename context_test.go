@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+)
+
+func TestPopulateContextValues(t *testing.T) {
+	tests := []struct {
+		name         string
+		token        bascule.Token
+		capability   string
+		expectedVals *ContextValues
+	}{
+		{
+			name: "jwt token",
+			token: &jwtToken{
+				principal: "client0",
+				claims: map[string]interface{}{
+					// nolint: goconst
+					"allowedResources": map[string]interface{}{
+						// nolint: goconst
+						"allowedPartners": []string{"partner0", "partner1"},
+					},
+					"trust": 1000,
+				},
+			},
+			capability: "x1:webpa:api:device/.*/config:all",
+			expectedVals: &ContextValues{
+				SatClientID: "client0",
+				Method:      http.MethodPost,
+				// nolint: goconst
+				Path:       "/api/v3/device",
+				PartnerIDs: []string{"partner0", "partner1"},
+				Trust:      "1000",
+				TokenType:  jwtTokenType,
+				Capability: "x1:webpa:api:device/.*/config:all",
+			},
+		},
+		{
+			name:  "token without attributes",
+			token: &testToken{principal: "user", tokenType: "basic"},
+			expectedVals: &ContextValues{
+				SatClientID: "user",
+				Method:      http.MethodPost,
+				Path:        "/api/v3/device",
+				TokenType:   basicTokenType,
+			},
+		},
+		{
+			name: "no token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var (
+				vals  *ContextValues
+				found bool
+			)
+
+			populate := populateContextValues(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				vals, found = FromContext(r.Context())
+			}))
+
+			// the capability check records the capability before the values are populated
+			handler := trackCapability(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if len(tt.capability) > 0 {
+					recordCapability(r.Context(), tt.capability)
+				}
+
+				populate.ServeHTTP(w, r)
+			}))
+
+			request := httptest.NewRequest(http.MethodPost, "/api/v3/device", nil)
+			if tt.token != nil {
+				request = request.WithContext(bascule.WithToken(request.Context(), tt.token))
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+			if tt.expectedVals == nil {
+				assert.False(found)
+				return
+			}
+
+			require.True(t, found)
+			assert.Equal(tt.expectedVals, vals)
+		})
+	}
+}
+
+func TestForwardContextValues(t *testing.T) {
+	assert := assert.New(t)
+	vals := &ContextValues{SatClientID: "client0"}
+
+	original := httptest.NewRequest(http.MethodPost, "/api/v3/device", nil)
+	original = original.WithContext(NewContextWithValue(original.Context(), vals))
+
+	ctx, err := forwardContextValues(context.Background(), original, nil, nil)
+	assert.NoError(err)
+
+	forwarded, ok := FromContext(ctx)
+	assert.True(ok)
+	assert.Equal(vals, forwarded)
+
+	ctx, err = forwardContextValues(context.Background(), httptest.NewRequest(http.MethodPost, "/", nil), nil, nil)
+	assert.NoError(err)
+
+	_, ok = FromContext(ctx)
+	assert.False(ok)
+}
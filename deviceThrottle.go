@@ -0,0 +1,255 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/xmidt-org/webpa-common/v2/device"
+	"golang.org/x/time/rate"
+)
+
+const (
+	deviceThrottleConfigKey = "deviceThrottle"
+
+	defaultDeviceThrottleMaxDevices = 10000
+)
+
+// DeviceThrottleConfig drives the throttling of the messages sent to each device through the
+// deviceThrottle configuration.
+type DeviceThrottleConfig struct {
+	// MaxInFlight is the maximum number of messages being sent to one device at a time.
+	// Zero means there is no limit.
+	MaxInFlight int
+
+	// MaxPerMinute is the maximum number of messages sent to one device per minute.
+	// Zero means there is no limit.
+	MaxPerMinute int
+
+	// Wait is how long a message waits for the device's limits before it is rejected.
+	// Zero rejects throttled messages immediately.
+	Wait time.Duration
+
+	// MaxDevices is the number of devices whose limits are tracked.  When more devices are sent
+	// to, the least recently used device is forgotten.
+	MaxDevices int
+}
+
+// deviceThrottleState is the in-flight and per-minute limits of one device.
+type deviceThrottleState struct {
+	inFlight  chan struct{}
+	perMinute *rate.Limiter
+
+	// users is the number of messages waiting for or holding the state, guarded by the
+	// deviceThrottle's lock
+	users int
+}
+
+// deviceThrottle limits the messages sent to each device.  The device states are kept in an LRU,
+// so memory is bounded no matter how many devices are sent to.  The states of devices with
+// messages waiting or in flight are also kept in active, so that a device evicted from the LRU
+// keeps counting its messages in flight.
+type deviceThrottle struct {
+	maxInFlight  int
+	maxPerMinute int
+	wait         time.Duration
+	throttled    metrics.Counter
+
+	lock    sync.Mutex
+	devices *lru.Cache
+	active  map[device.ID]*deviceThrottleState
+}
+
+// newDeviceThrottle creates the deviceThrottle for the configuration, or nil if it has no limits.
+func newDeviceThrottle(cfg DeviceThrottleConfig, throttled metrics.Counter) (*deviceThrottle, error) {
+	if cfg.MaxInFlight < 0 || cfg.MaxPerMinute < 0 || cfg.Wait < 0 || cfg.MaxDevices < 0 {
+		return nil, fmt.Errorf("device throttle limits cannot be negative")
+	}
+
+	if cfg.MaxInFlight == 0 && cfg.MaxPerMinute == 0 {
+		return nil, nil
+	}
+
+	if cfg.MaxDevices == 0 {
+		cfg.MaxDevices = defaultDeviceThrottleMaxDevices
+	}
+
+	devices, err := lru.New(cfg.MaxDevices)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deviceThrottle{
+		maxInFlight:  cfg.MaxInFlight,
+		maxPerMinute: cfg.MaxPerMinute,
+		wait:         cfg.Wait,
+		throttled:    throttled,
+		devices:      devices,
+		active:       make(map[device.ID]*deviceThrottleState),
+	}, nil
+}
+
+// hold returns the state of the device for a message, which must give it back with letGo.
+func (dt *deviceThrottle) hold(id device.ID) *deviceThrottleState {
+	dt.lock.Lock()
+	defer dt.lock.Unlock()
+
+	s, ok := dt.active[id]
+	if !ok {
+		if cached, ok := dt.devices.Get(id); ok {
+			s = cached.(*deviceThrottleState)
+		} else {
+			s = new(deviceThrottleState)
+			if dt.maxInFlight > 0 {
+				s.inFlight = make(chan struct{}, dt.maxInFlight)
+			}
+
+			if dt.maxPerMinute > 0 {
+				s.perMinute = rate.NewLimiter(rate.Every(time.Minute/time.Duration(dt.maxPerMinute)), dt.maxPerMinute)
+			}
+		}
+
+		dt.active[id] = s
+	}
+
+	// the state goes back to the front of the LRU, even if it was evicted while in use
+	dt.devices.Add(id, s)
+	s.users++
+	return s
+}
+
+// letGo gives back a state returned by hold.
+func (dt *deviceThrottle) letGo(id device.ID, s *deviceThrottleState) {
+	dt.lock.Lock()
+	defer dt.lock.Unlock()
+
+	s.users--
+	if s.users == 0 {
+		delete(dt.active, id)
+	}
+}
+
+// deviceThrottledError is returned for a message that exceeded its device's limits.
+type deviceThrottledError struct {
+	id         device.ID
+	reason     string
+	retryAfter time.Duration
+}
+
+func (e *deviceThrottledError) Error() string {
+	return fmt.Sprintf("too many messages to device %s", e.id)
+}
+
+func (e *deviceThrottledError) StatusCode() int {
+	return http.StatusTooManyRequests
+}
+
+func (e *deviceThrottledError) Headers() http.Header {
+	if e.retryAfter <= 0 {
+		return nil
+	}
+
+	return http.Header{"Retry-After": []string{strconv.Itoa(int(math.Ceil(e.retryAfter.Seconds())))}}
+}
+
+// acquire waits, for at most the configured wait, for the device's limits.  On success, the
+// returned function must be called once the message has been sent, and delayed reports whether
+// the message had to wait.
+func (dt *deviceThrottle) acquire(ctx context.Context, id device.ID) (release func(), delayed bool, err error) {
+	s := dt.hold(id)
+	ctx, cancel := context.WithTimeout(ctx, dt.wait)
+	defer cancel()
+
+	release = func() { dt.letGo(id, s) }
+	if s.inFlight != nil {
+		select {
+		case s.inFlight <- struct{}{}:
+		default:
+			delayed = true
+			select {
+			case s.inFlight <- struct{}{}:
+			case <-ctx.Done():
+				release()
+				return nil, false, &deviceThrottledError{id: id, reason: TooManyInFlight}
+			}
+		}
+
+		release = func() {
+			<-s.inFlight
+			dt.letGo(id, s)
+		}
+	}
+
+	if s.perMinute != nil {
+		now := time.Now()
+		reservation := s.perMinute.ReserveN(now, 1)
+		delay := reservation.DelayFrom(now)
+		if delay == 0 {
+			return release, delayed, nil
+		}
+
+		if deadline, _ := ctx.Deadline(); delay > deadline.Sub(now) {
+			reservation.CancelAt(now)
+			release()
+			return nil, false, &deviceThrottledError{id: id, reason: TooManyPerMinute, retryAfter: delay}
+		}
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			delayed = true
+		case <-ctx.Done():
+			reservation.Cancel()
+			release()
+			return nil, false, &deviceThrottledError{id: id, reason: TooManyPerMinute, retryAfter: delay}
+		}
+	}
+
+	return release, delayed, nil
+}
+
+// then is middleware, run for each message sent, that applies the limits of the message's device.
+// The device is taken from the device name header set by fanoutPrep.
+func (dt *deviceThrottle) then(delegate http.Handler) http.Handler {
+	if dt == nil {
+		return delegate
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := device.ParseID(r.Header.Get(device.DeviceNameHeader))
+		if err != nil {
+			// the fanout rejects messages without a valid device
+			delegate.ServeHTTP(w, r)
+			return
+		}
+
+		release, delayed, err := dt.acquire(r.Context(), id)
+		if err != nil {
+			var throttled *deviceThrottledError
+			if errors.As(err, &throttled) {
+				dt.throttled.With(OutcomeLabel, Rejected, ReasonLabel, throttled.reason).Add(1)
+			}
+
+			encodeError(r.Context(), err, w)
+			return
+		}
+
+		defer release()
+		if delayed {
+			dt.throttled.With(OutcomeLabel, Accepted, ReasonLabel, Delayed).Add(1)
+		}
+
+		delegate.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,240 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/webpa-common/v2/device"
+)
+
+func newThrottledRequest(name string) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, "/api/v3/device", nil)
+	request.Header.Set(device.DeviceNameHeader, name)
+	return request
+}
+
+func TestNewDeviceThrottle(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         DeviceThrottleConfig
+		expectedNil bool
+		expectedErr bool
+	}{
+		{
+			name:        "not configured",
+			cfg:         DeviceThrottleConfig{Wait: time.Second},
+			expectedNil: true,
+		},
+		{
+			name: "in flight",
+			cfg:  DeviceThrottleConfig{MaxInFlight: 1},
+		},
+		{
+			name:        "negative",
+			cfg:         DeviceThrottleConfig{MaxPerMinute: -1},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dt, err := newDeviceThrottle(tt.cfg, newTestCounter())
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedNil, dt == nil)
+		})
+	}
+}
+
+func TestDeviceThrottleInFlight(t *testing.T) {
+	tests := []struct {
+		name           string
+		wait           time.Duration
+		expectedStatus int
+		expectedReason string
+	}{
+		{
+			name:           "reject",
+			expectedStatus: http.StatusTooManyRequests,
+			expectedReason: TooManyInFlight,
+		},
+		{
+			name:           "wait",
+			wait:           5 * time.Second,
+			expectedStatus: http.StatusAccepted,
+			expectedReason: Delayed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			counter := newTestCounter()
+			dt, err := newDeviceThrottle(DeviceThrottleConfig{MaxInFlight: 1, Wait: tt.wait}, counter)
+			require.NoError(t, err)
+
+			var (
+				entered = make(chan struct{})
+				finish  = make(chan struct{})
+				wg      sync.WaitGroup
+				handler = dt.then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Header.Get("X-Block") != "" {
+						close(entered)
+						<-finish
+					}
+
+					w.WriteHeader(http.StatusAccepted)
+				}))
+			)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				request := newThrottledRequest("mac:112233445566/config")
+				request.Header.Set("X-Block", "true")
+				handler.ServeHTTP(httptest.NewRecorder(), request)
+			}()
+
+			<-entered
+
+			// other devices are not affected
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, newThrottledRequest("mac:665544332211"))
+			assert.Equal(http.StatusAccepted, response.Code)
+
+			if tt.wait > 0 {
+				time.AfterFunc(50*time.Millisecond, func() { close(finish) })
+			}
+
+			response = httptest.NewRecorder()
+			handler.ServeHTTP(response, newThrottledRequest("mac:112233445566/config"))
+			assert.Equal(tt.expectedStatus, response.Code)
+			assert.Empty(response.Header().Get("Retry-After"))
+			assert.Equal(float64(1), counter.count)
+			assert.Equal(tt.expectedReason, counter.labelPairs[ReasonLabel])
+
+			if tt.wait == 0 {
+				close(finish)
+			}
+
+			wg.Wait()
+		})
+	}
+}
+
+func TestDeviceThrottlePerMinute(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	counter := newTestCounter()
+	dt, err := newDeviceThrottle(DeviceThrottleConfig{MaxPerMinute: 1}, counter)
+	require.NoError(err)
+
+	handler := dt.then(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, newThrottledRequest("mac:112233445566"))
+	assert.Equal(http.StatusAccepted, response.Code)
+
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, newThrottledRequest("mac:112233445566/config"))
+	assert.Equal(http.StatusTooManyRequests, response.Code)
+	assert.Equal("60", response.Header().Get("Retry-After"))
+	assert.Contains(response.Header().Get("X-Xmidt-Error"), "mac:112233445566")
+	assert.Equal(map[string]string{OutcomeLabel: Rejected, ReasonLabel: TooManyPerMinute}, counter.labelPairs)
+
+	// messages without a valid device are left to the fanout
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, newThrottledRequest(""))
+	assert.Equal(http.StatusAccepted, response.Code)
+}
+
+func TestDeviceThrottlePerMinuteWait(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	counter := newTestCounter()
+	dt, err := newDeviceThrottle(DeviceThrottleConfig{MaxPerMinute: 1200, Wait: time.Second}, counter)
+	require.NoError(err)
+
+	// use up the burst, so the next message waits for the next token
+	id := device.ID("mac:112233445566")
+	for i := 0; i < 1200; i++ {
+		release, _, err := dt.acquire(t.Context(), id)
+		require.NoError(err)
+		release()
+	}
+
+	release, delayed, err := dt.acquire(t.Context(), id)
+	require.NoError(err)
+	assert.True(delayed)
+	release()
+}
+
+func TestDeviceThrottleMaxDevices(t *testing.T) {
+	assert := assert.New(t)
+
+	dt, err := newDeviceThrottle(DeviceThrottleConfig{MaxPerMinute: 1, MaxDevices: 2}, newTestCounter())
+	require.NoError(t, err)
+
+	for _, id := range []device.ID{"mac:000000000001", "mac:000000000002", "mac:000000000003"} {
+		release, _, err := dt.acquire(t.Context(), id)
+		require.NoError(t, err)
+		release()
+	}
+
+	assert.Equal(2, dt.devices.Len())
+	assert.Empty(dt.active)
+
+	// the first device was forgotten, so its limit starts over
+	_, _, err = dt.acquire(t.Context(), "mac:000000000001")
+	assert.NoError(err)
+	_, _, err = dt.acquire(t.Context(), "mac:000000000003")
+	assert.Error(err)
+}
+
+func TestDeviceThrottleEvictedInFlight(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+	)
+
+	dt, err := newDeviceThrottle(DeviceThrottleConfig{MaxInFlight: 1, MaxDevices: 1}, newTestCounter())
+	require.NoError(err)
+	dt.wait = 0
+
+	releaseFirst, _, err := dt.acquire(t.Context(), "mac:000000000001")
+	require.NoError(err)
+
+	// evicts the first device from the LRU while its message is still in flight
+	releaseSecond, _, err := dt.acquire(t.Context(), "mac:000000000002")
+	require.NoError(err)
+	assert.Equal(1, dt.devices.Len())
+
+	_, _, err = dt.acquire(t.Context(), "mac:000000000001")
+	var throttled *deviceThrottledError
+	require.ErrorAs(err, &throttled)
+	assert.Equal(TooManyInFlight, throttled.reason)
+
+	releaseFirst()
+	releaseSecond()
+	assert.Empty(dt.active)
+
+	release, _, err := dt.acquire(t.Context(), "mac:000000000001")
+	require.NoError(err)
+	release()
+}
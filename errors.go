@@ -0,0 +1,209 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	gokithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/webpa-common/v2/device"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrp-go/v3/wrpcontext"
+)
+
+// problemContentType is the RFC 7807 media type for error bodies.
+const problemContentType = "application/problem+json"
+
+// errorCatalog maps the known errors to HTTP status codes.  Errors are matched with errors.Is,
+// so wrapped errors map to the same status as the errors they wrap.
+var errorCatalog = []struct {
+	err  error
+	code int
+}{
+	{device.ErrorInvalidDeviceName, http.StatusBadRequest},
+	{device.ErrorDeviceNotFound, http.StatusNotFound},
+	{device.ErrorNonUniqueID, http.StatusBadRequest},
+	{device.ErrorInvalidTransactionKey, http.StatusBadRequest},
+	{device.ErrorTransactionAlreadyRegistered, http.StatusBadRequest},
+	{device.ErrorMissingPathVars, http.StatusBadRequest},
+	{device.ErrorNoSuchTransactionKey, http.StatusBadGateway},
+	{device.ErrorMissingDeviceNameHeader, http.StatusBadRequest},
+	{errNoDeviceName, http.StatusBadRequest},
+	{bascule.ErrMissingCredentials, http.StatusUnauthorized},
+	{bascule.ErrBadCredentials, http.StatusUnauthorized},
+	{bascule.ErrInvalidCredentials, http.StatusBadRequest},
+	{bascule.ErrUnauthorized, http.StatusForbidden},
+}
+
+// errorStatusCode returns the HTTP status code for an error.  An error that carries its own status
+// code takes precedence over the catalog, and unknown errors are a 500.
+func errorStatusCode(err error) int {
+	var sc gokithttp.StatusCoder
+	if errors.As(err, &sc) {
+		return sc.StatusCode()
+	}
+
+	for _, entry := range errorCatalog {
+		if errors.Is(err, entry.err) {
+			return entry.code
+		}
+	}
+
+	return http.StatusInternalServerError
+}
+
+// errorResponse is the body written for a failed request.  Failures lists the failed checks of
+// a WRP message rejected by the wrpValidators.
+type errorResponse struct {
+	Code            int                    `json:"code"`
+	Message         string                 `json:"message"`
+	DeviceID        string                 `json:"deviceID,omitempty"`
+	TransactionUUID string                 `json:"transactionUUID,omitempty"`
+	Failures        []wrpValidationFailure `json:"failures,omitempty"`
+}
+
+// problemDetails is the RFC 7807 form of errorResponse.
+type problemDetails struct {
+	Type            string                 `json:"type"`
+	Title           string                 `json:"title"`
+	Status          int                    `json:"status"`
+	Detail          string                 `json:"detail"`
+	DeviceID        string                 `json:"deviceID,omitempty"`
+	TransactionUUID string                 `json:"transactionUUID,omitempty"`
+	Failures        []wrpValidationFailure `json:"failures,omitempty"`
+}
+
+// errorDetails is the request information used for error bodies written where only the
+// request's context is available, such as in a fanout error encoder.
+type errorDetails struct {
+	accept   string
+	deviceID string
+}
+
+type errorDetailsKey struct{}
+
+// validationFailuresKey holds the []wrpValidationFailure reported in an error body.
+type validationFailuresKey struct{}
+
+func newErrorDetails(r *http.Request) *errorDetails {
+	d := &errorDetails{
+		accept:   r.Header.Get("Accept"),
+		deviceID: mux.Vars(r)[deviceID],
+	}
+
+	if len(d.deviceID) == 0 {
+		d.deviceID = r.Header.Get(device.DeviceNameHeader)
+	}
+
+	return d
+}
+
+// withErrorDetails is middleware that stores the errorDetails of the request in its context.
+func withErrorDetails(delegate http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), errorDetailsKey{}, newErrorDetails(r))
+		delegate.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newErrorResponse builds the error body, filling in the device ID and transaction UUID from
+// the context when they are known.
+func newErrorResponse(ctx context.Context, code int, message string) (errorResponse, string) {
+	response := errorResponse{
+		Code:    code,
+		Message: message,
+	}
+
+	var accept string
+	if d, ok := ctx.Value(errorDetailsKey{}).(*errorDetails); ok {
+		accept = d.accept
+		response.DeviceID = d.deviceID
+	}
+
+	if msg, ok := wrpcontext.GetMessage(ctx); ok && msg != nil {
+		response.TransactionUUID = msg.TransactionUUID
+		if len(response.DeviceID) == 0 {
+			response.DeviceID = msg.Destination
+		}
+	}
+
+	if id, err := device.ParseID(response.DeviceID); err == nil {
+		response.DeviceID = string(id)
+	}
+
+	response.Failures, _ = ctx.Value(validationFailuresKey{}).([]wrpValidationFailure)
+	return response, accept
+}
+
+// marshalError encodes the error body in the format asked for by the Accept header: RFC 7807
+// problem+json, msgpack, or JSON by default.
+func marshalError(ctx context.Context, code int, message string) (string, []byte, error) {
+	response, accept := newErrorResponse(ctx, code, message)
+
+	switch {
+	case strings.Contains(accept, problemContentType):
+		body, err := json.Marshal(problemDetails{
+			Type:            "about:blank",
+			Title:           http.StatusText(code),
+			Status:          code,
+			Detail:          message,
+			DeviceID:        response.DeviceID,
+			TransactionUUID: response.TransactionUUID,
+			Failures:        response.Failures,
+		})
+		return problemContentType, body, err
+
+	case strings.Contains(accept, wrp.Msgpack.ContentType()):
+		var body []byte
+		err := wrp.NewEncoderBytes(&body, wrp.Msgpack).Encode(response)
+		return wrp.Msgpack.ContentType(), body, err
+	}
+
+	body, err := json.Marshal(response)
+	return "application/json", body, err
+}
+
+// writeError writes an error response using the errorDetails stored in the context.
+func writeError(ctx context.Context, w http.ResponseWriter, code int, message string) {
+	contentType, body, err := marshalError(ctx, code, message)
+	if err != nil {
+		contentType, body = "text/plain; charset=utf-8", []byte(message)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Xmidt-Error", message)
+	w.WriteHeader(code)
+	// nolint:errcheck
+	w.Write(body)
+}
+
+// writeRequestError writes an error response for a request, whether or not withErrorDetails ran.
+func writeRequestError(w http.ResponseWriter, r *http.Request, code int, message string) {
+	ctx := r.Context()
+	if _, ok := ctx.Value(errorDetailsKey{}).(*errorDetails); !ok {
+		ctx = context.WithValue(ctx, errorDetailsKey{}, newErrorDetails(r))
+	}
+
+	writeError(ctx, w, code, message)
+}
+
+// encodeError is the go-kit error encoder used by the fanout handlers.
+func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	var headerer gokithttp.Headerer
+	if errors.As(err, &headerer) {
+		for k, values := range headerer.Headers() {
+			for _, v := range values {
+				w.Header().Add(k, v)
+			}
+		}
+	}
+
+	writeError(ctx, w, errorStatusCode(err), err.Error())
+}
@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/webpa-common/v2/device"
+	"github.com/xmidt-org/webpa-common/v2/xhttp"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrp-go/v3/wrpcontext"
+)
+
+type testHeadererError struct {
+	error
+}
+
+func (testHeadererError) Headers() http.Header {
+	return http.Header{"X-Test": []string{"value"}}
+}
+
+func (e testHeadererError) Unwrap() error {
+	return e.error
+}
+
+func TestErrorStatusCode(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{
+			name:         "device not found",
+			err:          device.ErrorDeviceNotFound,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "wrapped device error",
+			err:          fmt.Errorf("fanout failed: %w", device.ErrorInvalidDeviceName),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "missing transaction",
+			err:          device.ErrorNoSuchTransactionKey,
+			expectedCode: http.StatusBadGateway,
+		},
+		{
+			name:         "bascule error",
+			err:          fmt.Errorf("parse failed: %w", bascule.ErrBadCredentials),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "status coder",
+			err:          fmt.Errorf("check failed: %w", ErrPIDMismatch),
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "unknown error",
+			err:          errors.New("unknown"),
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedCode, errorStatusCode(tt.err))
+		})
+	}
+}
+
+func TestMarshalError(t *testing.T) {
+	msg := &wrp.Message{Destination: "mac:112233445566/config", TransactionUUID: "DEADBEEF"}
+
+	tests := []struct {
+		name                string
+		accept              string
+		expectedContentType string
+	}{
+		{
+			name:                "default",
+			expectedContentType: "application/json",
+		},
+		{
+			name:                "problem",
+			accept:              problemContentType,
+			expectedContentType: problemContentType,
+		},
+		{
+			name:                "msgpack",
+			accept:              wrp.Msgpack.ContentType(),
+			expectedContentType: wrp.Msgpack.ContentType(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			ctx := context.WithValue(context.Background(), errorDetailsKey{}, &errorDetails{accept: tt.accept})
+			ctx = wrpcontext.SetMessage(ctx, msg)
+
+			contentType, body, err := marshalError(ctx, http.StatusNotFound, "device not found")
+			require.NoError(err)
+			assert.Equal(tt.expectedContentType, contentType)
+
+			switch tt.accept {
+			case problemContentType:
+				var problem problemDetails
+				require.NoError(json.Unmarshal(body, &problem))
+				assert.Equal(problemDetails{
+					Type:            "about:blank",
+					Title:           http.StatusText(http.StatusNotFound),
+					Status:          http.StatusNotFound,
+					Detail:          "device not found",
+					DeviceID:        "mac:112233445566",
+					TransactionUUID: "DEADBEEF",
+				}, problem)
+			case wrp.Msgpack.ContentType():
+				var response errorResponse
+				require.NoError(wrp.NewDecoderBytes(body, wrp.Msgpack).Decode(&response))
+				assert.Equal(http.StatusNotFound, response.Code)
+				assert.Equal("DEADBEEF", response.TransactionUUID)
+			default:
+				var response errorResponse
+				require.NoError(json.Unmarshal(body, &response))
+				assert.Equal(errorResponse{
+					Code:            http.StatusNotFound,
+					Message:         "device not found",
+					DeviceID:        "mac:112233445566",
+					TransactionUUID: "DEADBEEF",
+				}, response)
+			}
+		})
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var (
+		router   = mux.NewRouter()
+		response = httptest.NewRecorder()
+	)
+
+	router.Use(withErrorDetails)
+	router.HandleFunc("/api/v3/device/{deviceID}/stat", func(w http.ResponseWriter, r *http.Request) {
+		encodeError(r.Context(), testHeadererError{fmt.Errorf("stat failed: %w", device.ErrorDeviceNotFound)}, w)
+	})
+
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/v3/device/mac:112233445566/stat", nil))
+
+	assert.Equal(http.StatusNotFound, response.Code)
+	assert.Equal("value", response.Header().Get("X-Test"))
+	assert.Equal("application/json", response.Header().Get("Content-Type"))
+	assert.Contains(response.Header().Get("X-Xmidt-Error"), device.ErrorDeviceNotFound.Error())
+
+	var body errorResponse
+	require.NoError(json.NewDecoder(response.Body).Decode(&body))
+	assert.Equal(http.StatusNotFound, body.Code)
+	assert.Equal("mac:112233445566", body.DeviceID)
+}
+
+func TestWriteRequestError(t *testing.T) {
+	assert := assert.New(t)
+
+	request := httptest.NewRequest(http.MethodGet, "/unknown", nil)
+	request.Header.Set(device.DeviceNameHeader, "mac:112233445566")
+	request.Header.Set("Accept", problemContentType)
+
+	response := httptest.NewRecorder()
+	writeRequestError(response, request, http.StatusBadRequest, "Invalid endpoint")
+
+	assert.Equal(http.StatusBadRequest, response.Code)
+	assert.Equal(problemContentType, response.Header().Get("Content-Type"))
+
+	var problem problemDetails
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&problem))
+	assert.Equal(http.StatusBadRequest, problem.Status)
+	assert.Equal("Invalid endpoint", problem.Detail)
+	assert.Equal("mac:112233445566", problem.DeviceID)
+}
+
+func TestAuthErrorStatusCode(t *testing.T) {
+	assert := assert.New(t)
+
+	current := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/v3/device", nil), map[string]string{"version": apiVersion})
+	previous := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/v2/device", nil), map[string]string{"version": prevAPIVersion})
+
+	assert.Equal(http.StatusUnauthorized, authErrorStatusCode(current, bascule.ErrMissingCredentials))
+	assert.Equal(http.StatusForbidden, authErrorStatusCode(current, fmt.Errorf("denied: %w", bascule.ErrUnauthorized)))
+	assert.Equal(http.StatusForbidden, authErrorStatusCode(previous, bascule.ErrMissingCredentials))
+	assert.Equal(http.StatusBadRequest, authErrorStatusCode(previous, bascule.ErrInvalidCredentials))
+	assert.Equal(http.StatusServiceUnavailable, authErrorStatusCode(nil, &xhttp.Error{Code: http.StatusServiceUnavailable}))
+}
@@ -50,6 +50,10 @@ type CapabilityConfig struct {
 	Prefix          string
 	AcceptAllMethod string
 	EndpointBuckets []string
+
+	// PolicyFile is a YAML or JSON CapabilityPolicyConfig that replaces the regex capability
+	// check.
+	PolicyFile string
 }
 
 // scytale is the driver function for Scytale.  It performs everything main() would do,
@@ -105,7 +109,7 @@ func scytale(arguments []string) int {
 		e.Register()
 	}
 
-	primaryHandler, err := NewPrimaryHandler(logger, v, metricsRegistry, e, tracing)
+	primaryHandler, stopPrimaryHandler, err := NewPrimaryHandler(logger, v, metricsRegistry, e, tracing)
 	if err != nil {
 		logger.Error("unable to create primary handler", zap.Error(err))
 		return 2
@@ -140,6 +144,7 @@ func scytale(arguments []string) int {
 
 	close(shutdown)
 	waitGroup.Wait()
+	stopPrimaryHandler()
 	return 0
 }
 
@@ -182,5 +187,10 @@ func printVersionInfo(writer io.Writer) {
 }
 
 func main() {
+	// the explain subcommand is a client of a running scytale, so it skips the server setup
+	if len(os.Args) > 1 && os.Args[1] == authzExplainCommand {
+		os.Exit(explainCLI(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	os.Exit(scytale(os.Args))
 }
@@ -11,8 +11,21 @@ import (
 
 // Names for our metrics
 const (
-	ReceivedWRPMessageCount  = "received_wrp_message_total"
-	AuthCapabilityCheckCount = "auth_capability_check"
+	ReceivedWRPMessageCount   = "received_wrp_message_total"
+	AuthCapabilityCheckCount  = "auth_capability_check"
+	JWTValidationFailureCount = "jwt_validation_failure_total"
+	AuthConfigReloadCount     = "auth_config_reload_total"
+	RateLimitedCount          = "rate_limited_total"
+	ThrottledSendCount        = "throttled_send_total"
+	DuplicateSendCount        = "duplicate_send_total"
+	AsyncSendCount            = "async_send_total"
+	ScheduledDeliveryCount    = "scheduled_delivery_total"
+	PendingScheduledDelivery  = "scheduled_delivery_pending"
+	AuditRecordCount          = "audit_record_total"
+	BufferedAuditRecords      = "audit_buffered_records"
+
+	// WRPValidationFailureCount is created through the touchstone factory rather than the xmetrics registry
+	WRPValidationFailureCount = "wrp_validation_failure_total"
 )
 
 // labels
@@ -22,6 +35,7 @@ const (
 	ReasonLabel    = "reason"
 	PartnerIDLabel = "partnerid"
 	EndpointLabel  = "endpoint"
+	ValidatorLabel = "validator"
 )
 
 // label values
@@ -43,6 +57,31 @@ const (
 	UndeterminedCapabilities = "undetermined_capabilities"
 	EmptyCapabilitiesList    = "empty_capabilities_list"
 	NoCapabilitiesMatch      = "no_capabilities_match"
+	PolicyDenied             = "policy_denied"
+	NoPolicyRuleMatch        = "no_policy_rule_match"
+
+	JWTTimeClaimsInvalid = "jwt_time_claims_invalid"
+	JWTIssuerMismatch    = "jwt_issuer_mismatch"
+	JWTAudienceMismatch  = "jwt_audience_mismatch"
+
+	PrincipalRateLimited = "principal_rate_limited"
+	PartnerRateLimited   = "partner_rate_limited"
+	DeviceRateLimited    = "device_rate_limited"
+
+	TooManyInFlight  = "too_many_in_flight"
+	TooManyPerMinute = "too_many_per_minute"
+	Delayed          = "delayed"
+
+	DuplicateReplayed = "replayed"
+	DuplicateInFlight = "in_flight"
+
+	Queued    = "queued"
+	Scheduled = "scheduled"
+	Fired     = "fired"
+
+	Written = "written"
+	Dropped = "dropped"
+	Failed  = "failed"
 )
 
 // Metrics returns the metrics relevant to this package
@@ -60,6 +99,64 @@ func Metrics() []xmetrics.Metric {
 			Help:       "Counter for capability checks with outcome information by client, partner, and endpoint.",
 			LabelNames: []string{OutcomeLabel, ReasonLabel, ClientIDLabel, PartnerIDLabel, EndpointLabel},
 		},
+		{
+			Name:       JWTValidationFailureCount,
+			Type:       xmetrics.CounterType,
+			Help:       "Number of validly signed JWTs rejected because of their claims, by reason.",
+			LabelNames: []string{ReasonLabel},
+		},
+		{
+			Name:       AuthConfigReloadCount,
+			Type:       xmetrics.CounterType,
+			Help:       "Number of authentication and authorization configuration reloads, by outcome.",
+			LabelNames: []string{OutcomeLabel},
+		},
+		{
+			Name:       RateLimitedCount,
+			Type:       xmetrics.CounterType,
+			Help:       "Number of requests rejected by rate limits, by client, partner, and endpoint.",
+			LabelNames: []string{OutcomeLabel, ReasonLabel, ClientIDLabel, PartnerIDLabel, EndpointLabel},
+		},
+		{
+			Name:       ThrottledSendCount,
+			Type:       xmetrics.CounterType,
+			Help:       "Number of messages to a device that were delayed or rejected by the device throttle.",
+			LabelNames: []string{OutcomeLabel, ReasonLabel},
+		},
+		{
+			Name:       DuplicateSendCount,
+			Type:       xmetrics.CounterType,
+			Help:       "Number of duplicate sends answered with the outcome of the original send, by whether the original was stored or in flight.",
+			LabelNames: []string{ReasonLabel},
+		},
+		{
+			Name:       AsyncSendCount,
+			Type:       xmetrics.CounterType,
+			Help:       "Number of asynchronous sends queued or rejected, and of queued sends delivered, failed, expired or canceled.",
+			LabelNames: []string{OutcomeLabel},
+		},
+		{
+			Name:       ScheduledDeliveryCount,
+			Type:       xmetrics.CounterType,
+			Help:       "Number of deliveries scheduled, and of scheduled deliveries fired, expired or canceled before they fired.",
+			LabelNames: []string{OutcomeLabel},
+		},
+		{
+			Name: PendingScheduledDelivery,
+			Type: xmetrics.GaugeType,
+			Help: "Number of scheduled deliveries waiting for their time.",
+		},
+		{
+			Name:       AuditRecordCount,
+			Type:       xmetrics.CounterType,
+			Help:       "Number of audit records written, dropped because the audit buffer was full, or failed to be written.",
+			LabelNames: []string{OutcomeLabel},
+		},
+		{
+			Name: BufferedAuditRecords,
+			Type: xmetrics.GaugeType,
+			Help: "Number of audit records buffered for the audit sink.",
+		},
 	}
 }
 
@@ -72,3 +169,43 @@ func NewReceivedWRPCounter(r xmetrics.Registry) metrics.Counter {
 func NewAuthCapabilityCounter(r xmetrics.Registry) metrics.Counter {
 	return r.NewCounter(AuthCapabilityCheckCount)
 }
+
+func NewJWTValidationFailureCounter(r xmetrics.Registry) metrics.Counter {
+	return r.NewCounter(JWTValidationFailureCount)
+}
+
+func NewAuthConfigReloadCounter(r xmetrics.Registry) metrics.Counter {
+	return r.NewCounter(AuthConfigReloadCount)
+}
+
+func NewRateLimitedCounter(r xmetrics.Registry) metrics.Counter {
+	return r.NewCounter(RateLimitedCount)
+}
+
+func NewThrottledSendCounter(r xmetrics.Registry) metrics.Counter {
+	return r.NewCounter(ThrottledSendCount)
+}
+
+func NewDuplicateSendCounter(r xmetrics.Registry) metrics.Counter {
+	return r.NewCounter(DuplicateSendCount)
+}
+
+func NewAsyncSendCounter(r xmetrics.Registry) metrics.Counter {
+	return r.NewCounter(AsyncSendCount)
+}
+
+func NewScheduledDeliveryCounter(r xmetrics.Registry) metrics.Counter {
+	return r.NewCounter(ScheduledDeliveryCount)
+}
+
+func NewPendingScheduledDeliveryGauge(r xmetrics.Registry) metrics.Gauge {
+	return r.NewGauge(PendingScheduledDelivery)
+}
+
+func NewAuditRecordCounter(r xmetrics.Registry) metrics.Counter {
+	return r.NewCounter(AuditRecordCount)
+}
+
+func NewBufferedAuditRecordsGauge(r xmetrics.Registry) metrics.Gauge {
+	return r.NewGauge(BufferedAuditRecords)
+}
@@ -0,0 +1,306 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/xmidt-org/bascule"
+	"go.uber.org/zap"
+)
+
+const (
+	mtlsAuthConfigKey = "mtlsAuth"
+	mtlsTokenType     = "mtls"
+)
+
+// certificate attributes a principal or a mapping can be taken from
+const (
+	certificateCN     = "cn"
+	certificateO      = "o"
+	certificateOU     = "ou"
+	certificateDNS    = "dns"
+	certificateURI    = "uri"
+	certificateSPIFFE = "spiffe"
+)
+
+var (
+	// defaultCertificatePrincipal is the order in which certificate attributes are tried for the
+	// principal, from the most to the least specific.
+	defaultCertificatePrincipal = []string{certificateSPIFFE, certificateURI, certificateDNS, certificateCN}
+
+	errNoCertificatePrincipal   = errors.New("client certificate has none of the principal attributes")
+	errUnverifiedCertificate    = errors.New("client certificate was not verified")
+	errUnknownCertificateSource = errors.New("unknown certificate attribute")
+)
+
+// MTLSAuthConfig authenticates clients by their TLS client certificates through the mtlsAuth
+// configuration.  The primary server has to ask for client certificates.  Requests with an
+// Authorization header are authenticated by it instead.
+type MTLSAuthConfig struct {
+	// Principal lists the certificate attributes tried in order for the principal: cn, dns, uri or
+	// spiffe.  Defaults to spiffe, uri, dns, cn.
+	Principal []string
+
+	// ClientCAFile is a PEM file of the CAs that verify the client certificates the server did
+	// not verify itself.  Without it, only certificates verified by the server are accepted.
+	ClientCAFile string
+
+	// Mappings give the certificates the allowed partners and capabilities a JWT would carry.
+	// A certificate gets the partners and capabilities of every mapping it matches.
+	Mappings []CertificateMapping
+}
+
+// CertificateMapping is the allowed partners and capabilities of the certificates whose attribute
+// matches the pattern.
+type CertificateMapping struct {
+	// Attribute is the certificate attribute matched: cn, o, ou, dns, uri or spiffe.
+	Attribute string
+
+	// Pattern is a regular expression matched against the whole attribute value.  A certificate
+	// matches when one of the attribute's values does.
+	Pattern string
+
+	Partners     []string
+	Capabilities []string
+}
+
+// certificateMapping is a compiled CertificateMapping.
+type certificateMapping struct {
+	attribute    string
+	pattern      *regexp.Regexp
+	partners     []string
+	capabilities []string
+}
+
+// certificateSettings is the configured mTLS authentication.
+type certificateSettings struct {
+	principal []string
+	roots     *x509.CertPool
+	mappings  []certificateMapping
+}
+
+// loadCertificateSettings reads the mtlsAuth configuration, returning nil when it is not set.  When
+// strict is false, invalid mappings are logged and skipped, otherwise they are returned as errors.
+func loadCertificateSettings(v *viper.Viper, logger *zap.Logger, strict bool) (*certificateSettings, error) {
+	if !v.IsSet(mtlsAuthConfigKey) {
+		return nil, nil
+	}
+
+	var cfg MTLSAuthConfig
+	if err := v.UnmarshalKey(mtlsAuthConfigKey, &cfg); err != nil {
+		return nil, err
+	}
+
+	settings := &certificateSettings{principal: cfg.Principal}
+	if len(settings.principal) == 0 {
+		settings.principal = defaultCertificatePrincipal
+	}
+
+	for _, attribute := range settings.principal {
+		if attribute == certificateO || attribute == certificateOU || !validCertificateAttribute(attribute) {
+			return nil, fmt.Errorf("invalid certificate principal attribute [%s]: %w", attribute, errUnknownCertificateSource)
+		}
+	}
+
+	if len(cfg.ClientCAFile) > 0 {
+		data, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+
+		settings.roots = x509.NewCertPool()
+		if !settings.roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in client CA file [%s]", cfg.ClientCAFile)
+		}
+	}
+
+	for i, m := range cfg.Mappings {
+		mapping, err := newCertificateMapping(m)
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("invalid certificate mapping [%d]: %w", i, err)
+			}
+
+			logger.Error("skipping invalid certificate mapping", zap.Int("index", i), zap.Error(err))
+			continue
+		}
+
+		settings.mappings = append(settings.mappings, mapping)
+	}
+
+	return settings, nil
+}
+
+func newCertificateMapping(m CertificateMapping) (certificateMapping, error) {
+	if !validCertificateAttribute(m.Attribute) {
+		return certificateMapping{}, fmt.Errorf("%w [%s]", errUnknownCertificateSource, m.Attribute)
+	}
+
+	pattern, err := regexp.Compile("^(?:" + m.Pattern + ")$")
+	if err != nil {
+		return certificateMapping{}, fmt.Errorf("invalid pattern [%s]: %w", m.Pattern, err)
+	}
+
+	return certificateMapping{
+		attribute:    m.Attribute,
+		pattern:      pattern,
+		partners:     m.Partners,
+		capabilities: m.Capabilities,
+	}, nil
+}
+
+func validCertificateAttribute(attribute string) bool {
+	switch attribute {
+	case certificateCN, certificateO, certificateOU, certificateDNS, certificateURI, certificateSPIFFE:
+		return true
+	}
+
+	return false
+}
+
+// certificateAttribute returns the values of an attribute of the certificate.
+func certificateAttribute(cert *x509.Certificate, attribute string) []string {
+	var values []string
+	switch attribute {
+	case certificateCN:
+		if len(cert.Subject.CommonName) > 0 {
+			values = append(values, cert.Subject.CommonName)
+		}
+	case certificateO:
+		values = cert.Subject.Organization
+	case certificateOU:
+		values = cert.Subject.OrganizationalUnit
+	case certificateDNS:
+		values = cert.DNSNames
+	case certificateURI, certificateSPIFFE:
+		for _, u := range cert.URIs {
+			if attribute == certificateURI {
+				values = append(values, u.String())
+			} else if u.Scheme == "spiffe" && len(u.Host) > 0 {
+				values = append(values, u.String())
+			}
+		}
+	}
+
+	return values
+}
+
+// certificateToken is the token of a client authenticated by its certificate.  Its mapped partners
+// and capabilities are exposed as the claims a JWT would carry.
+type certificateToken struct {
+	principal string
+	claims    map[string]any
+}
+
+func (t *certificateToken) Principal() string {
+	return t.principal
+}
+
+func (t *certificateToken) Get(key string) (any, bool) {
+	value, ok := t.claims[key]
+	return value, ok
+}
+
+func (t *certificateToken) TokenType() string {
+	return mtlsTokenType
+}
+
+// parse creates the token of the request's client certificate.  Requests without a certificate
+// are missing credentials, so that other token parsers can be tried.
+func (s *certificateSettings) parse(r *http.Request, now time.Time) (bascule.Token, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, bascule.ErrMissingCredentials
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	if len(r.TLS.VerifiedChains) == 0 {
+		if s.roots == nil {
+			return nil, fmt.Errorf("%w: %w", bascule.ErrInvalidCredentials, errUnverifiedCertificate)
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, c := range r.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+
+		if _, err := cert.Verify(x509.VerifyOptions{
+			Roots:         s.roots,
+			Intermediates: intermediates,
+			CurrentTime:   now,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}); err != nil {
+			return nil, fmt.Errorf("%w: %w", bascule.ErrInvalidCredentials, err)
+		}
+	}
+
+	token := new(certificateToken)
+	for _, attribute := range s.principal {
+		if values := certificateAttribute(cert, attribute); len(values) > 0 {
+			token.principal = values[0]
+			break
+		}
+	}
+
+	if len(token.principal) == 0 {
+		return nil, fmt.Errorf("%w: %w", bascule.ErrInvalidCredentials, errNoCertificatePrincipal)
+	}
+
+	var partners, capabilities []string
+	for _, m := range s.mappings {
+		if !m.matches(cert) {
+			continue
+		}
+
+		partners = appendMissing(partners, m.partners...)
+		capabilities = appendMissing(capabilities, m.capabilities...)
+	}
+
+	token.claims = mappedClaims(partners, capabilities)
+	return token, nil
+}
+
+func (m certificateMapping) matches(cert *x509.Certificate) bool {
+	for _, value := range certificateAttribute(cert, m.attribute) {
+		if m.pattern.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// appendMissing appends the values that are not in the list yet.
+func appendMissing(list []string, values ...string) []string {
+	for _, value := range values {
+		if !contains(list, value) {
+			list = append(list, value)
+		}
+	}
+
+	return list
+}
+
+// certificateTokenParser authenticates requests by their client certificates with the live
+// mtlsAuth settings.
+type certificateTokenParser struct {
+	auth *authReloader
+}
+
+func (p certificateTokenParser) Parse(_ context.Context, r *http.Request) (bascule.Token, error) {
+	settings := p.auth.load().certificates
+	if settings == nil {
+		return nil, bascule.ErrMissingCredentials
+	}
+
+	return settings.parse(r, time.Now())
+}
@@ -0,0 +1,334 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	"go.uber.org/zap"
+)
+
+// testCertificateAuthority issues client certificates for tests.
+type testCertificateAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCertificateAuthority(t *testing.T) *testCertificateAuthority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCertificateAuthority{cert: cert, key: key}
+}
+
+// issue signs a client certificate with the subject and SANs of the template.
+func (ca *testCertificateAuthority) issue(t *testing.T, template x509.Certificate) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(2)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+// file writes the CA certificate to a PEM file.
+func (ca *testCertificateAuthority) file(t *testing.T) string {
+	filename := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600))
+	return filename
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u
+}
+
+func TestLoadCertificateSettings(t *testing.T) {
+	ca := newTestCertificateAuthority(t)
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(empty, nil, 0600))
+
+	tests := []struct {
+		name              string
+		cfg               map[string]any
+		strict            bool
+		expectedNil       bool
+		expectedPrincipal []string
+		expectedMappings  int
+		expectedRoots     bool
+		expectedErr       bool
+	}{
+		{
+			name:        "not configured",
+			expectedNil: true,
+		},
+		{
+			name:              "defaults",
+			cfg:               map[string]any{"mappings": []map[string]any{{"attribute": "cn", "pattern": "svc-.*"}}},
+			expectedPrincipal: defaultCertificatePrincipal,
+			expectedMappings:  1,
+		},
+		{
+			name: "client CA",
+			cfg: map[string]any{
+				"principal":    []string{"cn"},
+				"clientCAFile": ca.file(t),
+			},
+			expectedPrincipal: []string{"cn"},
+			expectedRoots:     true,
+		},
+		{
+			name:        "organization principal",
+			cfg:         map[string]any{"principal": []string{"o"}},
+			expectedErr: true,
+		},
+		{
+			name:        "missing client CA file",
+			cfg:         map[string]any{"clientCAFile": filepath.Join(t.TempDir(), "missing.pem")},
+			expectedErr: true,
+		},
+		{
+			name:        "empty client CA file",
+			cfg:         map[string]any{"clientCAFile": empty},
+			expectedErr: true,
+		},
+		{
+			name: "invalid mappings skipped",
+			cfg: map[string]any{"mappings": []map[string]any{
+				{"attribute": "serial", "pattern": ".*"},
+				{"attribute": "cn", "pattern": "("},
+				{"attribute": "ou", "pattern": "backend"},
+			}},
+			expectedPrincipal: defaultCertificatePrincipal,
+			expectedMappings:  1,
+		},
+		{
+			name:        "invalid mapping",
+			cfg:         map[string]any{"mappings": []map[string]any{{"attribute": "cn", "pattern": "("}}},
+			strict:      true,
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			v := viper.New()
+			if tt.cfg != nil {
+				v.Set(mtlsAuthConfigKey, tt.cfg)
+			}
+
+			settings, err := loadCertificateSettings(v, zap.NewNop(), tt.strict)
+			if tt.expectedErr {
+				assert.Error(err)
+				return
+			}
+
+			require.NoError(t, err)
+			if tt.expectedNil {
+				assert.Nil(settings)
+				return
+			}
+
+			require.NotNil(t, settings)
+			assert.Equal(tt.expectedPrincipal, settings.principal)
+			assert.Len(settings.mappings, tt.expectedMappings)
+			assert.Equal(tt.expectedRoots, settings.roots != nil)
+		})
+	}
+}
+
+func TestCertificateSettingsParse(t *testing.T) {
+	var (
+		ca    = newTestCertificateAuthority(t)
+		other = newTestCertificateAuthority(t)
+		roots = x509.NewCertPool()
+
+		spiffe = ca.issue(t, x509.Certificate{
+			Subject:  pkix.Name{CommonName: "device-manager", OrganizationalUnit: []string{"backend"}},
+			DNSNames: []string{"manager.example.com"},
+			URIs:     []*url.URL{mustParseURL(t, "https://example.com/manager"), mustParseURL(t, "spiffe://example.com/ns/prod/sa/manager")},
+		})
+
+		dns = ca.issue(t, x509.Certificate{
+			Subject:  pkix.Name{CommonName: "config-writer"},
+			DNSNames: []string{"writer.example.com"},
+		})
+
+		noPrincipal = ca.issue(t, x509.Certificate{
+			Subject: pkix.Name{Organization: []string{"example"}},
+		})
+
+		untrusted = other.issue(t, x509.Certificate{
+			Subject: pkix.Name{CommonName: "intruder"},
+		})
+	)
+
+	roots.AddCert(ca.cert)
+	mappings := []certificateMapping{}
+	for _, m := range []CertificateMapping{
+		{Attribute: certificateOU, Pattern: "backend", Partners: []string{"comcast"}, Capabilities: []string{"x1:webpa:api:device/.*/stat:get"}},
+		{Attribute: certificateSPIFFE, Pattern: "spiffe://example.com/ns/prod/.*", Partners: []string{"comcast", "sky"}, Capabilities: []string{"x1:webpa:api:device:post"}},
+		{Attribute: certificateDNS, Pattern: ".*\\.example\\.com", Capabilities: []string{"x1:webpa:api:device/.*/stat:get"}},
+	} {
+		mapping, err := newCertificateMapping(m)
+		require.NoError(t, err)
+		mappings = append(mappings, mapping)
+	}
+
+	tests := []struct {
+		name              string
+		state             *tls.ConnectionState
+		roots             *x509.CertPool
+		principal         []string
+		expectedPrincipal string
+		expectedClaims    map[string]any
+		expectedErr       error
+	}{
+		{
+			name:        "no TLS",
+			expectedErr: bascule.ErrMissingCredentials,
+		},
+		{
+			name:        "no client certificate",
+			state:       &tls.ConnectionState{},
+			expectedErr: bascule.ErrMissingCredentials,
+		},
+		{
+			name: "verified by the server",
+			state: &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{spiffe},
+				VerifiedChains:   [][]*x509.Certificate{{spiffe, ca.cert}},
+			},
+			expectedPrincipal: "spiffe://example.com/ns/prod/sa/manager",
+			expectedClaims: map[string]any{
+				"allowedResources": map[string]any{"allowedPartners": []string{"comcast", "sky"}},
+				"capabilities":     []string{"x1:webpa:api:device/.*/stat:get", "x1:webpa:api:device:post"},
+			},
+		},
+		{
+			name:              "verified with the client CA",
+			state:             &tls.ConnectionState{PeerCertificates: []*x509.Certificate{dns}},
+			roots:             roots,
+			expectedPrincipal: "writer.example.com",
+			expectedClaims: map[string]any{
+				"capabilities": []string{"x1:webpa:api:device/.*/stat:get"},
+			},
+		},
+		{
+			name:              "common name principal",
+			state:             &tls.ConnectionState{PeerCertificates: []*x509.Certificate{spiffe}},
+			roots:             roots,
+			principal:         []string{certificateCN},
+			expectedPrincipal: "device-manager",
+			expectedClaims: map[string]any{
+				"allowedResources": map[string]any{"allowedPartners": []string{"comcast", "sky"}},
+				"capabilities":     []string{"x1:webpa:api:device/.*/stat:get", "x1:webpa:api:device:post"},
+			},
+		},
+		{
+			name:        "unverified",
+			state:       &tls.ConnectionState{PeerCertificates: []*x509.Certificate{dns}},
+			expectedErr: bascule.ErrInvalidCredentials,
+		},
+		{
+			name:        "untrusted",
+			state:       &tls.ConnectionState{PeerCertificates: []*x509.Certificate{untrusted}},
+			roots:       roots,
+			expectedErr: bascule.ErrInvalidCredentials,
+		},
+		{
+			name:        "no principal",
+			state:       &tls.ConnectionState{PeerCertificates: []*x509.Certificate{noPrincipal}},
+			roots:       roots,
+			expectedErr: bascule.ErrInvalidCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			settings := &certificateSettings{
+				principal: tt.principal,
+				roots:     tt.roots,
+				mappings:  mappings,
+			}
+
+			if len(settings.principal) == 0 {
+				settings.principal = defaultCertificatePrincipal
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v3/device/mac:112233445566/stat", nil)
+			r.TLS = tt.state
+
+			token, err := settings.parse(r, time.Now())
+			if tt.expectedErr != nil {
+				assert.ErrorIs(err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(tt.expectedPrincipal, token.Principal())
+			assert.Equal(mtlsTokenType, tokenTypeOf(token))
+			assert.True(carriesClaims(token))
+			assert.Equal(tt.expectedClaims, token.(*certificateToken).claims)
+		})
+	}
+}
+
+func TestCertificateTokenParser(t *testing.T) {
+	v := viper.New()
+	auth := newAuthReloader(v, zap.NewNop(), &authSettings{}, newTestCounter())
+	p := certificateTokenParser{auth: auth}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v3/device/mac:112233445566/stat", nil)
+	_, err := p.Parse(r.Context(), r)
+	assert.ErrorIs(t, err, bascule.ErrMissingCredentials)
+
+	auth.settings.Store(&authSettings{certificates: &certificateSettings{principal: defaultCertificatePrincipal}})
+	_, err = p.Parse(r.Context(), r)
+	assert.ErrorIs(t, err, bascule.ErrMissingCredentials)
+}
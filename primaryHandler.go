@@ -4,16 +4,13 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
-	"regexp"
 	"strings"
 	"syscall"
 
@@ -22,7 +19,6 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/justinas/alice"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 	"github.com/xmidt-org/bascule"
 	"github.com/xmidt-org/bascule/basculehttp"
@@ -57,14 +53,19 @@ const (
 	prevAPIBase        = "api/" + prevAPIVersion
 	apiBaseDualVersion = "api/{version:" + apiVersion + "|" + prevAPIVersion + "}"
 
-	basicAuthConfigKey    = "authHeader"
-	jwtAuthConfigKey      = "jwtValidator"
-	wrpCheckConfigKey     = "WRPCheck"
-	wrpValidatorConfigKey = "wrpValidators"
+	basicAuthConfigKey     = "authHeader"
+	jwtAuthConfigKey       = "jwtValidator"
+	wrpCheckConfigKey      = "WRPCheck"
+	wrpValidatorConfigKey  = "wrpValidators"
+	syncResponseConfigKey  = "syncResponse"
+	batchSendConfigKey     = "batchSend"
+	broadcastConfigKey     = "broadcast"
+	talariaRoutesConfigKey = "talariaRoutes"
 
 	deviceID = "deviceID"
 
 	enforceCheck = "enforce"
+	monitorCheck = "monitor"
 )
 
 // Default values
@@ -76,39 +77,52 @@ var (
 	errNoDeviceName = errors.New("no device name")
 )
 
-func authChain(v *viper.Viper, logger *zap.Logger, registry xmetrics.Registry, tf *touchstone.Factory) (alice.Chain, error) {
+// authChain builds the authentication and authorization middleware.  The returned explainer
+// holds the authority that finishes the capability policy decisions waiting for the request's
+// WRP messages.
+func authChain(v *viper.Viper, logger *zap.Logger, registry xmetrics.Registry, tf *touchstone.Factory) (alice.Chain, *authzExplainer, error) {
 	if registry == nil {
-		return alice.Chain{}, errors.New("nil registry")
+		return alice.Chain{}, nil, errors.New("nil registry")
 	}
 
-	basicAllowed := make(map[string]string)
-	basicAuth := v.GetStringSlice(basicAuthConfigKey)
-	for _, a := range basicAuth {
-		decoded, err := base64.StdEncoding.DecodeString(a)
-		if err != nil {
-			logger.Info("failed to decode auth header", zap.Any("authHeader", a))
-			logger.Error(err.Error())
-			continue
-		}
+	settings, err := loadAuthSettings(v, logger, false)
+	if err != nil {
+		return alice.Chain{}, nil, emperror.With(err, "failed to load auth configuration")
+	}
 
-		i := bytes.IndexByte(decoded, ':')
-		logger.Debug("decoded string", zap.Any("string", decoded), zap.Int("i", i))
-		if i > 0 {
-			basicAllowed[string(decoded[:i])] = string(decoded[i+1:])
-		}
+	var reloadConfig AuthReloadConfig
+	if err := v.UnmarshalKey(authReloadConfigKey, &reloadConfig); err != nil {
+		return alice.Chain{}, nil, err
 	}
-	logger.Debug("Created list of allowed basic auths", zap.Any("allowed", basicAllowed), zap.Any("config", basicAuth))
+
+	auth := newAuthReloader(v, logger, settings, NewAuthConfigReloadCounter(registry))
+	auth.watch(reloadConfig)
 
 	var jwtVal JWTValidator
 	// Get jwt configuration, including clortho's configuration
 	v.UnmarshalKey(jwtAuthConfigKey, &jwtVal)
+	jwtAlgorithms, err := newJWTAlgorithms(jwtVal.Algorithms)
+	if err != nil {
+		return alice.Chain{}, nil, err
+	}
+
+	jwtIssuers, err := newClaimMatcher(jwtVal.Issuers)
+	if err != nil {
+		return alice.Chain{}, nil, emperror.With(err, "failed to create jwt issuer check")
+	}
+
+	jwtAudiences, err := newClaimMatcher(jwtVal.Audiences)
+	if err != nil {
+		return alice.Chain{}, nil, emperror.With(err, "failed to create jwt audience check")
+	}
+
 	// Instantiate a keyring for refresher and resolver to share
 	kr := clortho.NewKeyRing()
 
 	// Instantiate a fetcher for refresher and resolver to share
 	f, err := clortho.NewFetcher()
 	if err != nil {
-		return alice.Chain{}, emperror.With(err, "failed to create clortho fetcher")
+		return alice.Chain{}, nil, emperror.With(err, "failed to create clortho fetcher")
 	}
 
 	ref, err := clortho.NewRefresher(
@@ -116,7 +130,7 @@ func authChain(v *viper.Viper, logger *zap.Logger, registry xmetrics.Registry, t
 		clortho.WithFetcher(f),
 	)
 	if err != nil {
-		return alice.Chain{}, emperror.With(err, "failed to create clortho refresher")
+		return alice.Chain{}, nil, emperror.With(err, "failed to create clortho refresher")
 	}
 
 	resolver, err := clortho.NewResolver(
@@ -125,13 +139,13 @@ func authChain(v *viper.Viper, logger *zap.Logger, registry xmetrics.Registry, t
 		clortho.WithFetcher(f),
 	)
 	if err != nil {
-		return alice.Chain{}, emperror.With(err, "failed to create clortho resolver")
+		return alice.Chain{}, nil, emperror.With(err, "failed to create clortho resolver")
 	}
 
 	// Instantiate a metric listener for refresher and resolver to share
 	cml, err := clorthometrics.NewListener(clorthometrics.WithFactory(tf))
 	if err != nil {
-		return alice.Chain{}, emperror.With(err, "failed to create clortho metrics listener")
+		return alice.Chain{}, nil, emperror.With(err, "failed to create clortho metrics listener")
 	}
 
 	// Instantiate a logging listener for refresher and resolver to share
@@ -139,7 +153,7 @@ func authChain(v *viper.Viper, logger *zap.Logger, registry xmetrics.Registry, t
 		clorthozap.WithLogger(logger),
 	)
 	if err != nil {
-		return alice.Chain{}, emperror.With(err, "failed to create clortho zap logger listener")
+		return alice.Chain{}, nil, emperror.With(err, "failed to create clortho zap logger listener")
 	}
 
 	resolver.AddListener(cml)
@@ -159,195 +173,131 @@ func authChain(v *viper.Viper, logger *zap.Logger, registry xmetrics.Registry, t
 	}()
 
 	authParserOptions := []basculehttp.AuthorizationParserOption{
-		basculehttp.WithScheme(basculehttp.SchemeBearer, &jwtTokenParser{resolver: resolver, logger: logger, leeway: jwtVal.Leeway}),
+		basculehttp.WithScheme(basculehttp.SchemeBearer, &jwtTokenParser{
+			resolver:   resolver,
+			logger:     logger,
+			leeway:     jwtVal.Leeway,
+			algorithms: jwtAlgorithms,
+			issuers:    jwtIssuers,
+			audiences:  jwtAudiences,
+			failures:   NewJWTValidationFailureCounter(registry),
+		}),
 	}
-	if len(basicAllowed) > 0 {
-		authParserOptions = append(authParserOptions, basculehttp.WithScheme(basculehttp.SchemeBasic, basicAllowedTokenParser{allowed: basicAllowed}))
+	// when reloading is enabled, basic auth credentials may be added after startup
+	if len(settings.basicAllowed) > 0 || reloadConfig.enabled() {
+		authParserOptions = append(authParserOptions, basculehttp.WithScheme(basculehttp.SchemeBasic, reloadableBasicTokenParser{auth: auth}))
 	}
 
 	authParser, err := basculehttp.NewAuthorizationParser(authParserOptions...)
 	if err != nil {
-		return alice.Chain{}, emperror.With(err, "failed to create authorization parser")
-	}
-
-	validators := bascule.Validators[*http.Request]{
-		basculehttp.AsValidator(func(_ context.Context, token bascule.Token) error {
-			if token.Principal() == "" {
-				return errors.New("empty token principal")
-			}
-
-			return nil
-		}),
-		basculehttp.AsValidator(requirePartnersJWTClaim),
+		return alice.Chain{}, nil, emperror.With(err, "failed to create authorization parser")
 	}
 
-	// only add capability check if the configuration is set
-	var capabilityCheck CapabilityConfig
-	v.UnmarshalKey("capabilityCheck", &capabilityCheck)
-	if capabilityCheck.Type == enforceCheck || capabilityCheck.Type == "monitor" {
-		ec, err := newEndpointRegexCheck(capabilityCheck.Prefix, capabilityCheck.AcceptAllMethod)
-		if err != nil {
-			return alice.Chain{}, emperror.With(err, "failed to create capability check")
-		}
-
-		endpointBuckets := make([]*regexp.Regexp, 0, len(capabilityCheck.EndpointBuckets))
-		for _, pattern := range capabilityCheck.EndpointBuckets {
-			re, err := regexp.Compile(pattern)
-			if err != nil {
-				logger.Error("failed to compile endpoint bucket regex", zap.String("regex", pattern), zap.Error(err))
-				continue
-			}
-
-			endpointBuckets = append(endpointBuckets, re)
-		}
-
-		capabilityCheckCounter := NewAuthCapabilityCounter(registry)
-
-		enforce := capabilityCheck.Type == enforceCheck
-		validators = append(validators, basculehttp.AsValidator(func(_ context.Context, request *http.Request, token bascule.Token) error {
-			tt, ok := token.(tokenType)
-			if !ok || tt.TokenType() != jwtTokenType {
-				return nil
-			}
-
-			clientID := token.Principal()
-			requestPath := trimVersionPrefix(request.URL.EscapedPath())
-			endpointBucket := determineEndpointMetric(endpointBuckets, requestPath)
-			failureOutcome := Accepted
-			if enforce {
-				failureOutcome = Rejected
-			}
-
-			reportFailure := func(reason string, authErr error) error {
-				capabilityCheckCounter.With(
-					OutcomeLabel, failureOutcome,
-					ReasonLabel, reason,
-					ClientIDLabel, clientID,
-					PartnerIDLabel, "undetermined",
-					EndpointLabel, endpointBucket,
-				).Add(1)
-
-				if enforce {
-					return authErr
+	// the explain endpoint reports on these validators by name
+	tokenValidators := []authzValidator{
+		{
+			name: "principal",
+			validate: func(_ context.Context, _ *http.Request, token bascule.Token) error {
+				if token.Principal() == "" {
+					return errors.New("empty token principal")
 				}
 
 				return nil
-			}
-
-			accessor, ok := token.(bascule.AttributesAccessor)
-			if !ok {
-				return reportFailure(UndeterminedCapabilities, bascule.ErrUnauthorized)
-			}
-
-			// nolint: goconst
-			partnerID := "none"
-			if partnerVal, ok := bascule.GetAttribute[any](accessor, partnerKeys...); ok {
-				if partners, err := cast.ToStringSliceE(partnerVal); err == nil {
-					partnerID = determinePartnerMetric(partners)
-				}
-			}
-
-			rawCapabilities, ok := bascule.GetAttribute[any](accessor, "capabilities")
-			if !ok {
-				capabilityCheckCounter.With(
-					OutcomeLabel, failureOutcome,
-					ReasonLabel, UndeterminedCapabilities,
-					ClientIDLabel, clientID,
-					PartnerIDLabel, partnerID,
-					EndpointLabel, endpointBucket,
-				).Add(1)
-
-				if enforce {
-					return bascule.ErrUnauthorized
-				}
+			},
+		},
+		{
+			name: "partnersClaim",
+			validate: func(ctx context.Context, _ *http.Request, token bascule.Token) error {
+				return requirePartnersJWTClaim(ctx, token)
+			},
+		},
+	}
 
-				return nil
-			}
+	validators := make(bascule.Validators[*http.Request], 0, len(tokenValidators)+1)
+	for _, tv := range tokenValidators {
+		validators = append(validators, basculehttp.AsValidator(tv.validate))
+	}
 
-			capabilities, ok := bascule.GetCapabilities(rawCapabilities)
-			if !ok || len(capabilities) < 1 {
-				capabilityCheckCounter.With(
-					OutcomeLabel, failureOutcome,
-					ReasonLabel, EmptyCapabilitiesList,
-					ClientIDLabel, clientID,
-					PartnerIDLabel, partnerID,
-					EndpointLabel, endpointBucket,
-				).Add(1)
-
-				if enforce {
-					return bascule.ErrUnauthorized
-				}
+	capabilityCheckCounter := NewAuthCapabilityCounter(registry)
+	validators = append(validators, basculehttp.AsValidator(func(_ context.Context, request *http.Request, token bascule.Token) error {
+		// explaining the caller's own token only needs authentication, since it explains the
+		// capability check.  Explaining other tokens goes through the check.
+		if trimVersionPrefix(request.URL.EscapedPath()) == authzExplainPath {
+			return nil
+		}
 
-				return nil
-			}
+		// capability checks are only run when the configuration is set
+		if cs := auth.load().capabilities; cs != nil {
+			return cs.authorize(request, token, capabilityCheckCounter)
+		}
 
-			for _, capability := range capabilities {
-				if ec.authorized(capability, requestPath, request.Method) {
-					capabilityCheckCounter.With(
-						OutcomeLabel, Accepted,
-						ReasonLabel, "",
-						ClientIDLabel, clientID,
-						PartnerIDLabel, partnerID,
-						EndpointLabel, endpointBucket,
-					).Add(1)
-
-					return nil
-				}
-			}
+		return nil
+	}))
 
-			return reportFailure(NoCapabilitiesMatch, bascule.ErrUnauthorized)
-		}))
+	// client certificates are only used by requests without an Authorization header
+	tokenParsers := []bascule.TokenParser[*http.Request]{authParser}
+	if settings.certificates != nil || reloadConfig.enabled() {
+		tokenParsers = append(tokenParsers, certificateTokenParser{auth: auth})
 	}
 
 	authenticator, err := basculehttp.NewAuthenticator(
-		bascule.WithTokenParsers(authParser),
+		bascule.WithTokenParsers(tokenParsers...),
 		bascule.WithValidators[*http.Request](validators...),
 	)
 	if err != nil {
-		return alice.Chain{}, emperror.With(err, "failed to create authenticator")
+		return alice.Chain{}, nil, emperror.With(err, "failed to create authenticator")
 	}
 
 	authMiddleware, err := basculehttp.NewMiddleware(
 		basculehttp.WithAuthenticator(authenticator),
-		basculehttp.WithErrorStatusCoder(func(request *http.Request, err error) int {
-			if request != nil {
-				if vars := mux.Vars(request); vars != nil && vars["version"] == prevAPIVersion {
-					if errors.Is(err, bascule.ErrInvalidCredentials) {
-						return http.StatusBadRequest
-					}
+		basculehttp.WithErrorStatusCoder(authErrorStatusCode),
+		basculehttp.WithErrorMarshaler(func(request *http.Request, err error) (string, []byte, error) {
+			return marshalError(
+				context.WithValue(request.Context(), errorDetailsKey{}, newErrorDetails(request)),
+				authErrorStatusCode(request, err),
+				err.Error(),
+			)
+		}),
+	)
+	if err != nil {
+		return alice.Chain{}, nil, emperror.With(err, "failed to create auth middleware")
+	}
 
-					return http.StatusForbidden
-				}
-			}
+	explainer := &authzExplainer{
+		parser:     authParser,
+		validators: tokenValidators,
+		auth:       auth,
+		policy:     &capabilityPolicyAccess{auth: auth, counter: capabilityCheckCounter},
+	}
+
+	return alice.New(setLogger(logger), trackCapability, authMiddleware.Then, populateContextValues), explainer, nil
+}
 
-			switch {
-			case errors.Is(err, bascule.ErrMissingCredentials):
-				return http.StatusUnauthorized
-			case errors.Is(err, bascule.ErrBadCredentials):
-				return http.StatusUnauthorized
-			case errors.Is(err, bascule.ErrInvalidCredentials):
+// authErrorStatusCode returns the status code for an authentication or authorization failure.
+// Requests to the previous API version keep that version's status codes.
+func authErrorStatusCode(request *http.Request, err error) int {
+	if request != nil {
+		if vars := mux.Vars(request); vars != nil && vars["version"] == prevAPIVersion {
+			if errors.Is(err, bascule.ErrInvalidCredentials) {
 				return http.StatusBadRequest
-			case errors.Is(err, bascule.ErrUnauthorized):
-				return http.StatusForbidden
-			default:
-				return http.StatusInternalServerError
 			}
-		}),
-	)
-	if err != nil {
-		return alice.Chain{}, emperror.With(err, "failed to create auth middleware")
+
+			return http.StatusForbidden
+		}
 	}
 
-	return alice.New(setLogger(logger), authMiddleware.Then), nil
+	return errorStatusCode(err)
 }
 
 // createEndpoints examines the configuration and produces an appropriate fanout.Endpoints, either using the configured
-// endpoints or service discovery.
+// endpoints or service discovery.  The second fanout.Endpoints returned reaches every talaria rather than the one
+// owning a device.  With configured endpoints, both are the configured endpoints.
 // nolint:govet
-func createEndpoints(logger *zap.Logger, cfg *fanout.Configuration, registry xmetrics.Registry, e service.Environment, b multiaccessor.Builder, vnodeCount int) (fanout.Endpoints, error) {
+func createEndpoints(logger *zap.Logger, cfg *fanout.Configuration, registry xmetrics.Registry, e service.Environment, b multiaccessor.Builder, vnodeCount int) (fanout.Endpoints, fanout.Endpoints, error) {
 	if len(cfg.Endpoints) > 0 {
 		logger.Info("using configured endpoints for fanout", zap.Any("endpoints", cfg.Endpoints))
-		return fanout.ParseURLs(cfg.Endpoints...)
+		endpoints, err := fanout.ParseURLs(cfg.Endpoints...)
+		return endpoints, endpoints, err
 	} else if e != nil {
 		logger.Info("using service discovery for fanout")
 		endpoints := fanout.NewServiceEndpoints(
@@ -374,6 +324,7 @@ func createEndpoints(logger *zap.Logger, cfg *fanout.Configuration, registry xme
 			}),
 		)
 
+		all := newAllEndpoints(logger)
 		_, err := monitor.New(
 			monitor.WithLogger(logger),
 			monitor.WithFilter(monitor.NewNormalizeFilter(e.DefaultScheme())),
@@ -381,19 +332,23 @@ func createEndpoints(logger *zap.Logger, cfg *fanout.Configuration, registry xme
 			monitor.WithListeners(
 				monitor.NewMetricsListener(registry),
 				endpoints,
+				all,
 			),
 		)
 
-		return endpoints, err
+		return endpoints, all, err
 	}
 
-	return nil, fmt.Errorf("unable to create endpoints")
+	return nil, nil, fmt.Errorf("unable to create endpoints")
 }
 
-func NewPrimaryHandler(logger *zap.Logger, v *viper.Viper, registry xmetrics.Registry, e service.Environment, tracing candlelight.Tracing) (http.Handler, error) {
+// NewPrimaryHandler creates the handler of the primary server.  The returned function must be
+// called once the servers have stopped, so that the work still running in the background is
+// finished before exiting.
+func NewPrimaryHandler(logger *zap.Logger, v *viper.Viper, registry xmetrics.Registry, e service.Environment, tracing candlelight.Tracing) (http.Handler, func(), error) {
 	var cfg fanout.Configuration
 	if err := v.UnmarshalKey("fanout", &cfg); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	fanoutPrefix := v.GetString("fanout.pathPrefix")
 	logger.Info("creating primary handler")
@@ -401,33 +356,67 @@ func NewPrimaryHandler(logger *zap.Logger, v *viper.Viper, registry xmetrics.Reg
 
 	var o servicecfg.Options
 	if err := v.UnmarshalKey("service", &o); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var b multiaccessor.Builder
 	if s, err := json.Marshal(v.Get("service")); err != nil {
-		return nil, err
+		return nil, nil, err
 	} else if err := json.Unmarshal(s, &b); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	endpoints, err := createEndpoints(logger, &cfg, registry, e, b, o.VnodeCount)
+	endpoints, allEndpoints, err := createEndpoints(logger, &cfg, registry, e, b, o.VnodeCount)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	promReg, ok := registry.(prometheus.Registerer)
 	if !ok {
-		return nil, errors.New("failed to get prometheus registerer")
+		return nil, nil, errors.New("failed to get prometheus registerer")
 	}
 
 	var tsConfig touchstone.Config
 	// Get touchstone & zap configurations
 	v.UnmarshalKey("touchstone", &tsConfig)
 	tf := touchstone.NewFactory(tsConfig, logger, promReg)
-	authChain, err := authChain(v, logger, registry, tf)
+	authChain, explainer, err := authChain(v, logger, registry, tf)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	var (
+		rateLimitConfig RateLimitConfig
+		capabilityCheck CapabilityConfig
+	)
+
+	if err := v.UnmarshalKey(rateLimitConfigKey, &rateLimitConfig); err != nil {
+		return nil, nil, err
+	}
+
+	// nolint:errcheck
+	v.UnmarshalKey(capabilityCheckConfigKey, &capabilityCheck)
+	limiter, err := newRateLimiter(rateLimitConfig, capabilityCheck.EndpointBuckets, NewRateLimitedCounter(registry))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create rate limiter: %w", err)
+	}
+
+	authChain = authChain.Append(limiter.limitClients)
+
+	var auditConfig AuditConfig
+	if err := v.UnmarshalKey(auditConfigKey, &auditConfig); err != nil {
+		return nil, nil, err
+	}
+
+	auditor, err := newAuditLog(auditConfig, logger, NewAuditRecordCounter(registry), NewBufferedAuditRecordsGauge(registry))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create audit log: %w", err)
+	}
+
+	auditor.start()
+	// the buffered records are written when the servers have stopped
+	stop := func() {
+		auditor.stop()
 	}
 
 	var (
@@ -435,47 +424,7 @@ func NewPrimaryHandler(logger *zap.Logger, v *viper.Viper, registry xmetrics.Reg
 		transactor = fanout.NewTransactor(&cfg)
 		options    = []fanout.Option{
 			fanout.WithTransactor(transactor),
-			fanout.WithErrorEncoder(func(ctx context.Context, err error, w http.ResponseWriter) {
-				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-				w.Header().Set("X-Xmidt-Error", err.Error())
-				// nolint:errorlint
-				if headerer, ok := err.(gokithttp.Headerer); ok {
-					for k, values := range headerer.Headers() {
-						// nolint: gosec
-						for _, v := range values {
-							w.Header().Add(k, v)
-						}
-					}
-				}
-				code := http.StatusInternalServerError
-				// nolint:errorlint
-				switch err {
-				case device.ErrorInvalidDeviceName:
-					code = http.StatusBadRequest
-				case device.ErrorDeviceNotFound:
-					code = http.StatusNotFound
-				case device.ErrorNonUniqueID:
-					code = http.StatusBadRequest
-				case device.ErrorInvalidTransactionKey:
-					code = http.StatusBadRequest
-				case device.ErrorTransactionAlreadyRegistered:
-					code = http.StatusBadRequest
-				case device.ErrorMissingPathVars:
-					code = http.StatusBadRequest
-				case device.ErrorNoSuchTransactionKey:
-					code = http.StatusBadGateway
-				case device.ErrorMissingDeviceNameHeader:
-					code = http.StatusBadRequest
-				case errNoDeviceName:
-					code = http.StatusBadRequest
-				}
-
-				// nolint:errorlint
-				if sc, ok := err.(gokithttp.StatusCoder); ok {
-					code = sc.StatusCode()
-				}
-				w.WriteHeader(code)
-			}),
+			fanout.WithErrorEncoder(encodeError),
 		}
 	)
 
@@ -504,16 +453,18 @@ func NewPrimaryHandler(logger *zap.Logger, v *viper.Viper, registry xmetrics.Reg
 
 	valWRP, err := validateWRP(v, logger, tf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get wrp validators: %w", err)
+		return nil, nil, fmt.Errorf("failed to get wrp validators: %w", err)
 	}
 
-	router.Use(otelmux.Middleware("mainSpan", otelMuxOptions...), candlelight.EchoFirstTraceNodeInfo(tracing, true), valWRP)
+	router.Use(otelmux.Middleware("mainSpan", otelMuxOptions...), candlelight.EchoFirstTraceNodeInfo(tracing, true), withErrorDetails)
 
-	router.NotFoundHandler = http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
-		xhttp.WriteError(response, http.StatusBadRequest, "Invalid endpoint")
+	router.NotFoundHandler = http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		writeRequestError(response, request, http.StatusBadRequest, "Invalid endpoint")
 	})
+	// device limits are applied before the fanout's concurrency limit, so that requests to one
+	// device cannot hold up the requests to others
 	// nolint:govet
-	fanoutChain := fanout.NewChain(&cfg)
+	fanoutChain := alice.New(limiter.limitDevices).Extend(fanout.NewChain(&cfg))
 
 	HTTPFanoutHandler := fanoutChain.Then(
 		fanout.New(
@@ -539,6 +490,7 @@ func NewPrimaryHandler(logger *zap.Logger, v *viper.Viper, registry xmetrics.Reg
 
 						return context.WithValue(ctx, ContextKeyWRP, m), nil
 					},
+					forwardContextValues,
 					fanout.ForwardHeaders("Content-Type", "X-Webpa-Device-Name"),
 					fanout.UsePath(fmt.Sprintf("%s/device/send", fanoutPrefix)),
 					func(ctx context.Context, _, fanout *http.Request, body []byte) (context.Context, error) {
@@ -546,9 +498,11 @@ func NewPrimaryHandler(logger *zap.Logger, v *viper.Viper, registry xmetrics.Reg
 						fanout.ContentLength = int64(len(body))
 						return ctx, nil
 					},
+					auditor.startFanout,
 				),
 				fanout.WithFanoutFailure(
 					fanout.ReturnHeadersWithPrefix("X-"),
+					auditor.fanoutResult,
 				),
 				fanout.WithFanoutAfter(
 					fanout.ReturnHeadersWithPrefix("X-"),
@@ -576,51 +530,161 @@ func NewPrimaryHandler(logger *zap.Logger, v *viper.Viper, registry xmetrics.Reg
 						}
 						return ctx
 					},
+					auditor.fanoutResult,
 				),
 			)...,
 		))
 
 	var (
 		wrpCheckConfig   WRPCheckConfig
+		wrpAccess        wrpAccessAuthority
 		WRPFanoutHandler wrphttp.Handler
 	)
 
-	if v.IsSet(wrpCheckConfigKey) {
-		if v.IsSet(basicAuthConfigKey) {
-			return nil, errors.New("WRP PartnerID checks cannot be enabled with basic authentication")
-		}
+	// basic auth users are only partner restricted when they are mapped to partners, and are
+	// rejected by enforced checks otherwise
+	if v.IsSet(wrpCheckConfigKey) && !v.IsSet(jwtAuthConfigKey) && !v.IsSet(basicCredentialsConfigKey+".mappings") && !v.IsSet(mtlsAuthConfigKey) {
+		return nil, nil, errors.New("WRP PartnerID checks require JWT authentication, basic auth mappings or mTLS authentication to be enabled")
+	}
 
-		if !v.IsSet(jwtAuthConfigKey) {
-			return nil, errors.New("WRP PartnerID checks require JWT authentication to be enabled")
-		}
+	if err := v.UnmarshalKey(wrpCheckConfigKey, &wrpCheckConfig); err != nil {
+		return nil, nil, err
+	}
+
+	var throttleConfig DeviceThrottleConfig
+	if err := v.UnmarshalKey(deviceThrottleConfigKey, &throttleConfig); err != nil {
+		return nil, nil, err
+	}
+
+	throttle, err := newDeviceThrottle(throttleConfig, NewThrottledSendCounter(registry))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create device throttle: %w", err)
+	}
+
+	var dedupConfig SendDedupConfig
+	if err := v.UnmarshalKey(sendDedupConfigKey, &dedupConfig); err != nil {
+		return nil, nil, err
+	}
+
+	dedup, err := newSendDeduplicator(dedupConfig, nil, logger, NewDuplicateSendCounter(registry))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create send de-duplication: %w", err)
+	}
+
+	var asyncConfig AsyncSendConfig
+	if err := v.UnmarshalKey(asyncSendConfigKey, &asyncConfig); err != nil {
+		return nil, nil, err
+	}
+
+	// every message sent to a device, whether alone, in a batch, in a broadcast or from the
+	// async queue, is throttled.
+	deliverHandler := throttle.then(HTTPFanoutHandler)
+	jobsPath := fmt.Sprintf("%s/jobs", urlPrefix)
+	queue, err := newAsyncSendQueue(asyncConfig, deliverHandler, jobsPath, logger,
+		NewAsyncSendCounter(registry), NewScheduledDeliveryCounter(registry), NewPendingScheduledDeliveryGauge(registry))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create async send queue: %w", err)
 	}
 
-	v.UnmarshalKey(wrpCheckConfigKey, &wrpCheckConfig)
+	// sends are de-duplicated before they are queued or use up the throttle.  Only single sends
+	// can be queued.
+	sendFanoutHandler := dedup.then(deliverHandler)
+	singleSendHandler := dedup.then(queue.then(deliverHandler))
 
-	if wrpCheckConfig.Type == enforceCheck || wrpCheckConfig.Type == "monitor" {
-		WRPFanoutHandler = newWRPFanoutHandlerWithPIDCheck(
-			HTTPFanoutHandler,
-			&wrpPartnersAccess{
-				strict:                  wrpCheckConfig.Type == enforceCheck,
-				receivedWRPMessageCount: NewReceivedWRPCounter(registry),
-			})
-	} else {
-		WRPFanoutHandler = newWRPFanoutHandler(HTTPFanoutHandler)
+	var enrichConfig WRPEnrichConfig
+	if err := v.UnmarshalKey(wrpEnrichConfigKey, &enrichConfig); err != nil {
+		return nil, nil, err
 	}
 
+	enricher, err := newWRPEnricher(enrichConfig, v.GetString("server"), v.GetString("region"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create wrp enrichment: %w", err)
+	}
+
+	// the capability policy is checked first, since it can be reloaded in at any time
+	authorities := wrpAccessAuthorities{explainer.policy}
+	if wrpCheckConfig.Type == enforceCheck || wrpCheckConfig.Type == monitorCheck {
+		partnersAccess, err := newWRPPartnersAccess(wrpCheckConfig, NewReceivedWRPCounter(registry))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create wrp partner ID policy: %w", err)
+		}
+
+		authorities = append(authorities, partnersAccess)
+		explainer.partners = partnersAccess
+	}
+
+	wrpAccess = authorities
+	WRPFanoutHandler = newWRPFanoutHandlerWithPIDCheck(singleSendHandler, wrpAccess)
+
 	sendWRPHandler := wrphttp.NewHTTPHandler(WRPFanoutHandler,
-		wrphttp.WithDecoder(wrphttp.DecodeEntityFromSources(wrp.Msgpack, true)),
-		wrphttp.WithNewResponseWriter(nonWRPResponseWriterFactory))
+		wrphttp.WithDecoder(sendWRPDecoder),
+		wrphttp.WithNewResponseWriter(newWRPResponseWriterFactory(v.GetBool(syncResponseConfigKey), queue)))
+
+	// messages are enriched before they are validated and before the response writer is chosen
+	sendChain := authChain.Append(enricher.then, valWRP.then(logger))
 
 	sendSubrouter.Headers(
 		wrphttp.MessageTypeHeader, "").
-		Handler(authChain.Then(sendWRPHandler))
+		Handler(sendChain.Then(sendWRPHandler))
 
 	sendSubrouter.Headers("Content-Type", wrp.Msgpack.ContentType()).
-		Handler(authChain.Then(sendWRPHandler))
+		Handler(sendChain.Then(sendWRPHandler))
 
 	sendSubrouter.Headers("Content-Type", wrp.JSON.ContentType()).
-		Handler(authChain.Then(sendWRPHandler))
+		Handler(sendChain.Then(sendWRPHandler))
+
+	var batchConfig BatchConfig
+	if err := v.UnmarshalKey(batchSendConfigKey, &batchConfig); err != nil {
+		return nil, nil, err
+	}
+
+	var broadcastConfig BroadcastConfig
+	if err := v.UnmarshalKey(broadcastConfigKey, &broadcastConfig); err != nil {
+		return nil, nil, err
+	}
+
+	sender := &wrpSender{
+		fanoutHandler: sendFanoutHandler,
+		validators:    valWRP,
+		access:        wrpAccess,
+		enricher:      enricher,
+		concurrency:   batchConfig.sendConcurrency(cfg.Concurrency),
+	}
+
+	router.Handle(
+		fmt.Sprintf("%s/devices/batch", urlPrefix),
+		authChain.Then(&batchSendHandler{
+			sender:      sender,
+			maxMessages: batchConfig.MaxMessages,
+			maxBodySize: batchConfig.maxBodySize(),
+		}),
+	).Methods("POST")
+
+	router.Handle(
+		fmt.Sprintf("%s/devices/broadcast", urlPrefix),
+		authChain.Then(&broadcastHandler{
+			sender:      sender,
+			maxDevices:  broadcastConfig.MaxDevices,
+			maxBodySize: broadcastConfig.maxBodySize(),
+		}),
+	).Methods("POST")
+
+	router.Handle(fmt.Sprintf("%s%s", urlPrefix, authzExplainPath), authChain.Then(explainer)).Methods("POST")
+	router.Handle(fmt.Sprintf("%s%s", urlPrefix, authzExplainTokenPath), authChain.Then(explainer.forOtherTokens())).Methods("POST")
+
+	if queue != nil {
+		router.Handle(jobsPath, authChain.Then(queue.listHandler())).Methods("GET")
+		router.Handle(fmt.Sprintf("%s/{id}", jobsPath), authChain.Then(queue.jobsHandler())).Methods("GET")
+		router.Handle(fmt.Sprintf("%s/{id}", jobsPath), authChain.Then(queue.cancelHandler())).Methods("DELETE")
+		queue.start()
+		// in-flight attempts finish and store their outcome when the servers have stopped.  The
+		// queue goes before the audit log, since its deliveries are audited.
+		stopAuditor := stop
+		stop = func() {
+			queue.stop()
+			stopAuditor()
+		}
+	}
 
 	router.Handle(
 		fmt.Sprintf("%s/device/{%s}/stat", urlPrefix, deviceID),
@@ -640,19 +704,47 @@ func NewPrimaryHandler(logger *zap.Logger, v *viper.Viper, registry xmetrics.Reg
 							fanout.URL.RawPath = ""
 							return ctx, nil
 						},
+						forwardContextValues,
+						auditor.startFanout,
 					),
 					fanout.WithFanoutFailure(
 						fanout.ReturnHeadersWithPrefix("X-"),
+						auditor.fanoutResult,
 					),
 					fanout.WithFanoutAfter(
 						fanout.ReturnHeadersWithPrefix("X-"),
+						auditor.fanoutResult,
 					),
 				)...,
 			),
 		),
 	).Methods("GET")
 
-	return router, nil
+	var routeConfigs []TalariaRouteConfig
+	if err := v.UnmarshalKey(talariaRoutesConfigKey, &routeConfigs); err != nil {
+		return nil, nil, err
+	}
+
+	routes, err := newTalariaRoutes(routeConfigs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create talaria routes: %w", err)
+	}
+
+	routeTable := &talariaRouteTable{
+		routes:          routes,
+		urlPrefix:       urlPrefix,
+		fanoutPrefix:    fanoutPrefix,
+		authChain:       authChain,
+		fanoutChain:     fanoutChain,
+		deviceEndpoints: endpoints,
+		allEndpoints:    allEndpoints,
+		options:         options,
+		transactor:      transactor,
+		authorization:   cfg.Authorization,
+	}
+	routeTable.register(router, logger)
+
+	return router, stop, nil
 }
 
 // validateDeviceID checks the device ID in the URL to make sure it is good before fanout.
@@ -662,15 +754,7 @@ func validateDeviceID() alice.Chain {
 			vars := mux.Vars(r)
 			_, err := device.ParseID(vars[deviceID])
 			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
-
-				fmt.Fprintf(
-					w,
-					`{"code": %d, "message": "%s"}`,
-					http.StatusBadRequest,
-					fmt.Sprintf("failed to extract device ID: %s", err),
-				)
+				writeRequestError(w, r, http.StatusBadRequest, fmt.Sprintf("failed to extract device ID: %s", err))
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -678,16 +762,23 @@ func validateDeviceID() alice.Chain {
 	})
 }
 
-func validateWRP(v *viper.Viper, logger *zap.Logger, tf *touchstone.Factory) (func(http.Handler) http.Handler, error) {
-	_ = tf
+// validateWRP builds the WRP validators for the send paths from the wrpValidators configuration.
+// When no validators are configured, nil is returned and no validation is done.
+func validateWRP(v *viper.Viper, logger *zap.Logger, tf *touchstone.Factory) (*wrpValidators, error) {
+	var configs []WRPValidatorConfig
+	if err := v.UnmarshalKey(wrpValidatorConfigKey, &configs); err != nil {
+		return nil, err
+	}
 
-	if v.Get(wrpValidatorConfigKey) != nil {
-		logger.Warn("wrpValidators are configured but ignored because the current wrp-go dependency does not provide validator middleware")
+	if len(configs) == 0 {
+		return nil, nil
 	}
 
-	return func(delegate http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			delegate.ServeHTTP(w, r)
-		})
-	}, nil
+	vs, err := newWRPValidators(configs, tf)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("wrp validators enabled", zap.Any("validators", configs))
+	return vs, nil
 }
@@ -0,0 +1,341 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/gorilla/mux"
+	"github.com/spf13/cast"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/webpa-common/v2/device"
+	"golang.org/x/time/rate"
+)
+
+const (
+	rateLimitConfigKey = "rateLimit"
+
+	defaultRateLimitIdleTimeout = 10 * time.Minute
+)
+
+// RateLimit is a token bucket limit.
+type RateLimit struct {
+	// Rate is the number of requests allowed per second.  Zero means there is no limit.
+	Rate float64
+
+	// Burst is the number of requests allowed at once.  It defaults to the Rate, rounded up.
+	Burst int
+}
+
+// RateLimitOverride replaces the default RateLimit for one key.
+type RateLimitOverride struct {
+	// Key is the principal, partner ID or device ID the override applies to.
+	Key string
+
+	RateLimit `mapstructure:",squash"`
+}
+
+// RateLimitDimensionConfig is the limit applied to each key of one dimension, such as each
+// principal, with overrides for particular keys.
+type RateLimitDimensionConfig struct {
+	RateLimit `mapstructure:",squash"`
+
+	Overrides []RateLimitOverride
+}
+
+// RateLimitConfig drives the rate limiting of requests through the rateLimit configuration.
+type RateLimitConfig struct {
+	// Principal limits the requests of each authenticated principal.
+	Principal RateLimitDimensionConfig
+
+	// Partner limits the requests of each partner ID of a JWT.  A request from a token with
+	// several partner IDs counts against each of them.  The wildcard partner is not limited.
+	Partner RateLimitDimensionConfig
+
+	// Device limits the requests and messages sent to each device.
+	Device RateLimitDimensionConfig
+
+	// IdleTimeout is how long the limiter of a key is kept after its last use.
+	IdleTimeout time.Duration
+}
+
+// keyedLimiter is a token bucket per key.  Limiters idle for longer than the idle timeout are
+// dropped, so the number of limiters is bounded by the number of recently active keys.
+type keyedLimiter struct {
+	reason      string
+	limit       RateLimit
+	overrides   map[string]RateLimit
+	idleTimeout time.Duration
+
+	lock      sync.Mutex
+	limiters  map[string]*keyedLimiterEntry
+	lastSweep time.Time
+}
+
+type keyedLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+func newKeyedLimiter(reason string, cfg RateLimitDimensionConfig, idleTimeout time.Duration) (*keyedLimiter, error) {
+	kl := &keyedLimiter{
+		reason:      reason,
+		limit:       cfg.RateLimit,
+		overrides:   make(map[string]RateLimit, len(cfg.Overrides)),
+		idleTimeout: idleTimeout,
+		limiters:    make(map[string]*keyedLimiterEntry),
+	}
+
+	if err := validateRateLimit(cfg.RateLimit); err != nil {
+		return nil, err
+	}
+
+	for _, o := range cfg.Overrides {
+		if len(o.Key) == 0 {
+			return nil, fmt.Errorf("rate limit override is missing its key")
+		}
+
+		if err := validateRateLimit(o.RateLimit); err != nil {
+			return nil, fmt.Errorf("invalid rate limit override [%s]: %w", o.Key, err)
+		}
+
+		kl.overrides[o.Key] = o.RateLimit
+	}
+
+	if kl.limit.Rate == 0 && len(kl.overrides) == 0 {
+		return nil, nil
+	}
+
+	return kl, nil
+}
+
+func validateRateLimit(l RateLimit) error {
+	if l.Rate < 0 || l.Burst < 0 {
+		return fmt.Errorf("rate [%v] and burst [%d] cannot be negative", l.Rate, l.Burst)
+	}
+
+	return nil
+}
+
+// reserve takes a token from the key's bucket.  A nil reservation means the key is not limited.
+func (kl *keyedLimiter) reserve(key string, now time.Time) *rate.Reservation {
+	if kl == nil || len(key) == 0 {
+		return nil
+	}
+
+	limit, ok := kl.overrides[key]
+	if !ok {
+		limit = kl.limit
+	}
+
+	if limit.Rate == 0 {
+		return nil
+	}
+
+	kl.lock.Lock()
+	defer kl.lock.Unlock()
+
+	if now.Sub(kl.lastSweep) > kl.idleTimeout {
+		for k, e := range kl.limiters {
+			if now.Sub(e.lastUsed) > kl.idleTimeout {
+				delete(kl.limiters, k)
+			}
+		}
+
+		kl.lastSweep = now
+	}
+
+	e, ok := kl.limiters[key]
+	if !ok {
+		burst := limit.Burst
+		if burst == 0 {
+			burst = int(math.Ceil(limit.Rate))
+		}
+
+		e = &keyedLimiterEntry{limiter: rate.NewLimiter(rate.Limit(limit.Rate), burst)}
+		kl.limiters[key] = e
+	}
+
+	e.lastUsed = now
+	return e.limiter.ReserveN(now, 1)
+}
+
+// rateLimiter applies the principal, partner and device limits to requests.
+type rateLimiter struct {
+	principal       *keyedLimiter
+	partner         *keyedLimiter
+	device          *keyedLimiter
+	endpointBuckets []*regexp.Regexp
+	rateLimited     metrics.Counter
+	now             func() time.Time
+}
+
+// newRateLimiter creates the rateLimiter for the configuration, or nil if no limits are configured.
+// The endpoint buckets group requests for the endpoint label of the rate limited metric, the same
+// way they do for the capability check metric.
+func newRateLimiter(cfg RateLimitConfig, endpointBuckets []string, rateLimited metrics.Counter) (*rateLimiter, error) {
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaultRateLimitIdleTimeout
+	}
+
+	var (
+		rl  = &rateLimiter{rateLimited: rateLimited, now: time.Now}
+		err error
+	)
+
+	if rl.principal, err = newKeyedLimiter(PrincipalRateLimited, cfg.Principal, cfg.IdleTimeout); err != nil {
+		return nil, fmt.Errorf("invalid principal rate limit: %w", err)
+	}
+
+	if rl.partner, err = newKeyedLimiter(PartnerRateLimited, cfg.Partner, cfg.IdleTimeout); err != nil {
+		return nil, fmt.Errorf("invalid partner rate limit: %w", err)
+	}
+
+	if rl.device, err = newKeyedLimiter(DeviceRateLimited, cfg.Device, cfg.IdleTimeout); err != nil {
+		return nil, fmt.Errorf("invalid device rate limit: %w", err)
+	}
+
+	if rl.principal == nil && rl.partner == nil && rl.device == nil {
+		return nil, nil
+	}
+
+	// invalid buckets have already been reported by the capability check configuration
+	for _, pattern := range endpointBuckets {
+		if re, err := regexp.Compile(pattern); err == nil {
+			rl.endpointBuckets = append(rl.endpointBuckets, re)
+		}
+	}
+
+	return rl, nil
+}
+
+// limitClients is middleware, run after authentication, that applies the principal and partner limits.
+func (rl *rateLimiter) limitClients(delegate http.Handler) http.Handler {
+	if rl == nil || (rl.principal == nil && rl.partner == nil) {
+		return delegate
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			now                 = rl.now()
+			principal, partners = requestClient(r)
+			limits              = []*keyedLimiter{rl.principal}
+			keys                = []string{principal}
+		)
+
+		for _, partner := range partners {
+			if partner == "*" {
+				continue
+			}
+
+			limits = append(limits, rl.partner)
+			keys = append(keys, partner)
+		}
+
+		if !rl.allow(w, r, now, limits, keys) {
+			return
+		}
+
+		delegate.ServeHTTP(w, r)
+	})
+}
+
+// limitDevices is middleware, run before the fanout, that applies the device limits.  The device
+// is taken from the URL or from the device name header, the same way the fanout routes requests.
+func (rl *rateLimiter) limitDevices(delegate http.Handler) http.Handler {
+	if rl == nil || rl.device == nil {
+		return delegate
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(device.DeviceNameHeader)
+		if id := mux.Vars(r)[deviceID]; len(id) > 0 {
+			name = id
+		}
+
+		// requests without a valid device are rejected by the fanout
+		if id, err := device.ParseID(name); err == nil {
+			if !rl.allow(w, r, rl.now(), []*keyedLimiter{rl.device}, []string{string(id)}) {
+				return
+			}
+		}
+
+		delegate.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token for each key, and writes a 429 when any of them is exhausted.  Tokens are
+// only taken when the request is allowed, so a rejected request does not use up the other limits.
+func (rl *rateLimiter) allow(w http.ResponseWriter, r *http.Request, now time.Time, limits []*keyedLimiter, keys []string) bool {
+	var (
+		reservations = make([]*rate.Reservation, 0, len(keys))
+		wait         time.Duration
+		reason       string
+	)
+
+	for i, key := range keys {
+		reservation := limits[i].reserve(key, now)
+		if reservation == nil {
+			continue
+		}
+
+		reservations = append(reservations, reservation)
+		delay := rate.InfDuration
+		if reservation.OK() {
+			delay = reservation.DelayFrom(now)
+		}
+
+		if delay > wait {
+			wait, reason = delay, limits[i].reason
+		}
+	}
+
+	if wait == 0 {
+		return true
+	}
+
+	for _, reservation := range reservations {
+		reservation.CancelAt(now)
+	}
+
+	principal, partners := requestClient(r)
+	rl.rateLimited.With(
+		OutcomeLabel, Rejected,
+		ReasonLabel, reason,
+		ClientIDLabel, principal,
+		PartnerIDLabel, determinePartnerMetric(partners),
+		EndpointLabel, determineEndpointMetric(rl.endpointBuckets, trimVersionPrefix(r.URL.EscapedPath())),
+	).Add(1)
+
+	if wait != rate.InfDuration {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+
+	writeRequestError(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+	return false
+}
+
+// requestClient returns the principal and partner IDs of the request's token.
+func requestClient(r *http.Request) (string, []string) {
+	token, ok := bascule.Get(r.Context())
+	if !ok {
+		return "", nil
+	}
+
+	var partners []string
+	if accessor, ok := token.(bascule.AttributesAccessor); ok {
+		if partnerVal, ok := bascule.GetAttribute[any](accessor, partnerKeys...); ok {
+			partners, _ = cast.ToStringSliceE(partnerVal)
+		}
+	}
+
+	return token.Principal(), partners
+}
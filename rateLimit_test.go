@@ -0,0 +1,221 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/webpa-common/v2/device"
+)
+
+func newTestRateLimiter(t *testing.T, cfg RateLimitConfig, counter *testCounter, now *time.Time) *rateLimiter {
+	rl, err := newRateLimiter(cfg, []string{`/device/.*/stat\b`}, counter)
+	require.NoError(t, err)
+	require.NotNil(t, rl)
+	rl.now = func() time.Time { return *now }
+	return rl
+}
+
+func newRateLimitedRequest(principal string, partners ...string) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, "/api/v3/device", nil)
+	claims := map[string]any{}
+	if len(partners) > 0 {
+		claims["allowedResources"] = map[string]any{"allowedPartners": partners}
+	}
+
+	return request.WithContext(bascule.WithToken(request.Context(), &jwtToken{principal: principal, claims: claims}))
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         RateLimitConfig
+		expectedNil bool
+		expectedErr bool
+	}{
+		{
+			name:        "not configured",
+			expectedNil: true,
+		},
+		{
+			name: "override only",
+			cfg: RateLimitConfig{
+				Partner: RateLimitDimensionConfig{
+					Overrides: []RateLimitOverride{{Key: "comcast", RateLimit: RateLimit{Rate: 10}}},
+				},
+			},
+		},
+		{
+			name: "negative rate",
+			cfg: RateLimitConfig{
+				Principal: RateLimitDimensionConfig{RateLimit: RateLimit{Rate: -1}},
+			},
+			expectedErr: true,
+		},
+		{
+			name: "override without key",
+			cfg: RateLimitConfig{
+				Device: RateLimitDimensionConfig{
+					Overrides: []RateLimitOverride{{RateLimit: RateLimit{Rate: 10}}},
+				},
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, err := newRateLimiter(tt.cfg, nil, newTestCounter())
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedNil, rl == nil)
+		})
+	}
+}
+
+func TestRateLimiterLimitClients(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		now     = time.Now()
+		counter = newTestCounter()
+		rl      = newTestRateLimiter(t, RateLimitConfig{
+			Principal: RateLimitDimensionConfig{
+				RateLimit: RateLimit{Rate: 1, Burst: 2},
+				Overrides: []RateLimitOverride{{Key: "unlimited"}},
+			},
+			Partner: RateLimitDimensionConfig{
+				RateLimit: RateLimit{Rate: 1, Burst: 3},
+			},
+		}, counter, &now)
+
+		handler = rl.limitClients(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		}))
+	)
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, r)
+		return response
+	}
+
+	// the principal's burst is used up first
+	assert.Equal(http.StatusAccepted, serve(newRateLimitedRequest("a", "comcast")).Code)
+	assert.Equal(http.StatusAccepted, serve(newRateLimitedRequest("a", "comcast")).Code)
+
+	response := serve(newRateLimitedRequest("a", "comcast"))
+	assert.Equal(http.StatusTooManyRequests, response.Code)
+	assert.Equal("1", response.Header().Get("Retry-After"))
+	assert.Equal(float64(1), counter.count)
+	assert.Equal(map[string]string{
+		OutcomeLabel:   Rejected,
+		ReasonLabel:    PrincipalRateLimited,
+		ClientIDLabel:  "a",
+		PartnerIDLabel: "comcast",
+		EndpointLabel:  "not_recognized",
+	}, counter.labelPairs)
+
+	// the rejected request did not use up the partner's tokens, so one remains
+	assert.Equal(http.StatusAccepted, serve(newRateLimitedRequest("b", "comcast")).Code)
+	response = serve(newRateLimitedRequest("c", "comcast"))
+	assert.Equal(http.StatusTooManyRequests, response.Code)
+	assert.Equal(PartnerRateLimited, counter.labelPairs[ReasonLabel])
+
+	// other partners, wildcard partners and overridden principals are not affected
+	assert.Equal(http.StatusAccepted, serve(newRateLimitedRequest("c", "other")).Code)
+	assert.Equal(http.StatusAccepted, serve(newRateLimitedRequest("d", "*")).Code)
+	for i := 0; i < 5; i++ {
+		assert.Equal(http.StatusAccepted, serve(newRateLimitedRequest("unlimited")).Code)
+	}
+
+	// tokens are refilled over time
+	now = now.Add(2 * time.Second)
+	assert.Equal(http.StatusAccepted, serve(newRateLimitedRequest("a", "comcast")).Code)
+}
+
+func TestRateLimiterLimitDevices(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		now     = time.Now()
+		counter = newTestCounter()
+		rl      = newTestRateLimiter(t, RateLimitConfig{
+			Device: RateLimitDimensionConfig{
+				RateLimit: RateLimit{Rate: 0.5},
+			},
+		}, counter, &now)
+
+		router = mux.NewRouter()
+	)
+
+	router.Handle("/api/v3/device/{deviceID}/stat", rl.limitDevices(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	router.Handle("/api/v3/device", rl.limitDevices(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})))
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, r)
+		return response
+	}
+
+	stat := func(id string) *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/api/v3/device/"+id+"/stat", nil)
+	}
+
+	send := func(name string) *http.Request {
+		request := newRateLimitedRequest("a", "comcast")
+		request.Header.Set(device.DeviceNameHeader, name)
+		return request
+	}
+
+	assert.Equal(http.StatusOK, serve(stat("mac:112233445566")).Code)
+
+	// the device name header is keyed by device ID, so the service does not matter
+	response := serve(send("mac:112233445566/config"))
+	assert.Equal(http.StatusTooManyRequests, response.Code)
+	assert.Equal("2", response.Header().Get("Retry-After"))
+	assert.Equal(DeviceRateLimited, counter.labelPairs[ReasonLabel])
+	assert.Equal("a", counter.labelPairs[ClientIDLabel])
+
+	response = serve(stat("mac:112233445566"))
+	assert.Equal(http.StatusTooManyRequests, response.Code)
+	assert.Equal(`/device/.*/stat\b`, counter.labelPairs[EndpointLabel])
+
+	// other devices and requests without a valid device are not limited
+	assert.Equal(http.StatusAccepted, serve(send("mac:665544332211")).Code)
+	assert.Equal(http.StatusAccepted, serve(send("")).Code)
+	assert.Equal(http.StatusAccepted, serve(send("")).Code)
+}
+
+func TestKeyedLimiterIdle(t *testing.T) {
+	assert := assert.New(t)
+
+	kl, err := newKeyedLimiter(DeviceRateLimited, RateLimitDimensionConfig{RateLimit: RateLimit{Rate: 1}}, time.Minute)
+	require.NoError(t, err)
+
+	now := time.Now()
+	assert.NotNil(kl.reserve("a", now))
+	assert.NotNil(kl.reserve("b", now.Add(30*time.Second)))
+	assert.Len(kl.limiters, 2)
+
+	assert.NotNil(kl.reserve("b", now.Add(90*time.Second)))
+	assert.Len(kl.limiters, 1)
+	assert.Contains(kl.limiters, "b")
+}
@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// deliverAtHeader schedules a single send for delivery at an RFC 3339 time.  The time carries
+	// its own offset, so a delivery at a device's local time is scheduled with the device's
+	// offset.  The time is only taken from this header: the WRP message has no field for it, and
+	// its metadata is delivered to the device.
+	deliverAtHeader = "X-Xmidt-Deliver-At"
+
+	// deliverAtSkew is how far in the past a delivery time is still accepted, to allow for the
+	// clocks of the client and scytale being apart.
+	deliverAtSkew = 30 * time.Second
+)
+
+var (
+	errJobNotFound   = errors.New("job not found")
+	errJobNotPending = errors.New("job is no longer pending")
+)
+
+// parseDeliverAt parses the value of the deliverAtHeader.  It returns the zero time when the
+// header is not set.
+func parseDeliverAt(value string, now time.Time, maxDelay time.Duration) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+
+	deliverAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s header, it must be an RFC 3339 time: %w", deliverAtHeader, err)
+	}
+
+	if now.Sub(deliverAt) > deliverAtSkew {
+		return time.Time{}, fmt.Errorf("%s cannot be in the past", deliverAtHeader)
+	}
+
+	if deliverAt.Sub(now) > maxDelay {
+		return time.Time{}, fmt.Errorf("%s cannot be more than %s in the future", deliverAtHeader, maxDelay)
+	}
+
+	return deliverAt, nil
+}
+
+// refuseScheduledDelivery is the middleware used in place of the asyncSendQueue when there is
+// none, so that scheduled deliveries are not sent straight away.
+func refuseScheduledDelivery(delegate http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.Header.Get(deliverAtHeader)) > 0 {
+			writeRequestError(w, r, http.StatusBadRequest, "scheduled delivery is not enabled")
+			return
+		}
+
+		delegate.ServeHTTP(w, r)
+	})
+}
+
+// list returns the status of the principal's jobs, oldest first.
+func (q *asyncSendQueue) list(principal string) []sendJobStatus {
+	q.lock.Lock()
+	jobs := make([]sendJobStatus, 0)
+	for _, job := range q.jobs {
+		if job.Principal == principal {
+			jobs = append(jobs, job.snapshot().sendJobStatus)
+		}
+	}
+	q.lock.Unlock()
+
+	slices.SortFunc(jobs, func(a, b sendJobStatus) int {
+		return a.Created.Compare(b.Created)
+	})
+
+	return jobs
+}
+
+// cancel cancels one of the principal's jobs, as long as it is pending and not being delivered.
+func (q *asyncSendQueue) cancel(id, principal string) (*sendJob, error) {
+	q.lock.Lock()
+	job, ok := q.jobs[id]
+	if !ok || job.Principal != principal {
+		q.lock.Unlock()
+		return nil, errJobNotFound
+	}
+
+	if job.Status != jobPending || job.delivering {
+		q.lock.Unlock()
+		return nil, errJobNotPending
+	}
+
+	job.finish(jobCanceled, q.now())
+	snapshot := job.snapshot()
+	q.lock.Unlock()
+
+	q.sends.With(OutcomeLabel, jobCanceled).Add(1)
+	if snapshot.scheduled() && len(snapshot.Attempts) == 0 {
+		q.scheduled.With(OutcomeLabel, jobCanceled).Add(1)
+	}
+
+	q.save(snapshot)
+	return snapshot, nil
+}
+
+// listHandler serves the status of the jobs queued by the principal.
+func (q *asyncSendQueue) listHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := requestClient(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		// nolint:errchkjson
+		_ = json.NewEncoder(w).Encode(q.list(principal))
+	})
+}
+
+// cancelHandler cancels one of the jobs queued by the principal.
+func (q *asyncSendQueue) cancelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := requestClient(r)
+		job, err := q.cancel(mux.Vars(r)["id"], principal)
+		switch {
+		case errors.Is(err, errJobNotFound):
+			writeRequestError(w, r, http.StatusNotFound, err.Error())
+		case errors.Is(err, errJobNotPending):
+			writeRequestError(w, r, http.StatusConflict, err.Error())
+		default:
+			writeJobStatus(w, http.StatusOK, &job.sendJobStatus)
+		}
+	})
+}
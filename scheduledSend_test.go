@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func newScheduledRequest(t *testing.T, principal string, deliverAt time.Time) *http.Request {
+	request := newDedupRequest(t, principal, wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:test",
+		Destination: "mac:112233445566/config",
+	})
+
+	request.Header.Set(deliverAtHeader, deliverAt.Format(time.RFC3339))
+	return request
+}
+
+func TestParseDeliverAt(t *testing.T) {
+	now := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		value       string
+		expected    time.Time
+		expectedErr bool
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:     "device local time",
+			value:    "2026-01-01T02:00:00-05:00",
+			expected: time.Date(2026, time.January, 1, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "within the clock skew",
+			value:    "2025-12-31T23:59:50Z",
+			expected: time.Date(2025, time.December, 31, 23, 59, 50, 0, time.UTC),
+		},
+		{
+			name:        "past",
+			value:       "2025-12-31T00:00:00Z",
+			expectedErr: true,
+		},
+		{
+			name:        "invalid",
+			value:       "tomorrow",
+			expectedErr: true,
+		},
+		{
+			name:        "too far",
+			value:       "2026-02-01T00:00:00Z",
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := parseDeliverAt(tt.value, now, 24*time.Hour)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.True(t, tt.expected.Equal(actual))
+		})
+	}
+}
+
+func TestRefuseScheduledDelivery(t *testing.T) {
+	var q *asyncSendQueue
+	handler := q.then(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, newScheduledRequest(t, "client", time.Now().Add(time.Hour)))
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, newDedupRequest(t, "client", wrp.Message{Destination: "mac:112233445566"}))
+	assert.Equal(t, http.StatusAccepted, response.Code)
+}
+
+func TestScheduledDelivery(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	q, now, delivered := newTestAsyncSendQueue(t, AsyncSendConfig{}, http.StatusAccepted)
+	scheduled := newTestCounter()
+	q.scheduled = scheduled
+	pending := generic.NewGauge("pending")
+	q.pending = pending
+
+	deliverAt := now.Add(time.Hour)
+	response := httptest.NewRecorder()
+	q.then(http.NotFoundHandler()).ServeHTTP(response, newScheduledRequest(t, "client", deliverAt))
+	require.Equal(http.StatusAccepted, response.Code)
+	assert.Equal(Scheduled, scheduled.labelPairs[OutcomeLabel])
+
+	var status sendJobStatus
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &status))
+	assert.True(deliverAt.Equal(status.DeliverAt))
+	assert.True(deliverAt.Equal(status.NextAttempt))
+	assert.True(deliverAt.Add(q.expiry).Equal(status.Expires))
+
+	// the delivery waits for its time
+	runDue(q)
+	assert.Empty(*delivered)
+	assert.Equal(float64(1), pending.Value())
+
+	*now = deliverAt
+	runDue(q)
+	assert.Len(*delivered, 1)
+	assert.Equal(float64(0), pending.Value())
+	assert.Equal(Fired, scheduled.labelPairs[OutcomeLabel])
+
+	job, ok := q.get(status.ID, "client")
+	require.True(ok)
+	assert.Equal(jobDelivered, job.Status)
+}
+
+func TestAsyncSendQueueListAndCancel(t *testing.T) {
+	q, now, delivered := newTestAsyncSendQueue(t, AsyncSendConfig{}, http.StatusAccepted)
+
+	var ids []string
+	for _, principal := range []string{"client", "client", "other"} {
+		*now = now.Add(time.Second)
+		response := httptest.NewRecorder()
+		q.then(http.NotFoundHandler()).ServeHTTP(response, newScheduledRequest(t, principal, now.Add(time.Hour)))
+
+		var status sendJobStatus
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &status))
+		ids = append(ids, status.ID)
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/api/v3/jobs", q.listHandler()).Methods("GET")
+	router.Handle("/api/v3/jobs/{id}", q.cancelHandler()).Methods("DELETE")
+
+	serve := func(method, path, principal string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, nil)
+		request = request.WithContext(bascule.WithToken(request.Context(), &jwtToken{principal: principal}))
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	response := serve(http.MethodGet, "/api/v3/jobs", "client")
+	require.Equal(t, http.StatusOK, response.Code)
+
+	var listed []sendJobStatus
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &listed))
+	require.Len(t, listed, 2)
+	assert.Equal(t, ids[0], listed[0].ID)
+	assert.Equal(t, ids[1], listed[1].ID)
+
+	tests := []struct {
+		name           string
+		id             string
+		principal      string
+		expectedCode   int
+		expectedStatus string
+	}{
+		{
+			name:           "canceled",
+			id:             ids[0],
+			principal:      "client",
+			expectedCode:   http.StatusOK,
+			expectedStatus: jobCanceled,
+		},
+		{
+			name:         "already canceled",
+			id:           ids[0],
+			principal:    "client",
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name:         "other principal",
+			id:           ids[2],
+			principal:    "client",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := serve(http.MethodDelete, "/api/v3/jobs/"+tt.id, tt.principal)
+			assert.Equal(t, tt.expectedCode, response.Code)
+			if tt.expectedCode == http.StatusOK {
+				var status sendJobStatus
+				require.NoError(t, json.Unmarshal(response.Body.Bytes(), &status))
+				assert.Equal(t, tt.expectedStatus, status.Status)
+			}
+		})
+	}
+
+	// canceled jobs are not delivered
+	*now = now.Add(time.Hour)
+	runDue(q)
+	assert.Len(t, *delivered, 2)
+}
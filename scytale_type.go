@@ -15,6 +15,20 @@ type JWTValidator struct {
 	// Leeway is used to set the amount of time buffer should be given to JWT
 	// time values, such as nbf
 	Leeway Leeway `json:"leeway" mapstructure:"leeway"`
+
+	// Algorithms is the allowlist of JWT signing algorithms, e.g. RS256, PS256, ES256 or EdDSA.
+	// When empty, only the RSA algorithms RS256, RS384 and RS512 are accepted; RSA-PSS, ECDSA
+	// and EdDSA algorithms must be listed to be accepted.
+	Algorithms []string `json:"algorithms" mapstructure:"algorithms"`
+
+	// Issuers is the list of accepted iss claim values.  A '*' in a value matches any
+	// sequence of characters.  When empty, the issuer is not checked.
+	Issuers []string `json:"issuers" mapstructure:"issuers"`
+
+	// Audiences is the list of accepted aud claim values, at least one of which must be
+	// present in the JWT.  A '*' in a value matches any sequence of characters.  When empty,
+	// the audience is not checked.
+	Audiences []string `json:"audiences" mapstructure:"audiences"`
 }
 
 type Leeway struct {
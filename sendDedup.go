@@ -0,0 +1,277 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/webpa-common/v2/xhttp"
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	sendDedupConfigKey = "sendDedup"
+
+	defaultSendDedupMaxEntries = 100000
+)
+
+// SendDedupConfig drives the de-duplication of sends through the sendDedup configuration.
+type SendDedupConfig struct {
+	// TTL is how long the outcome of a send is kept to answer its duplicates.  Zero disables
+	// de-duplication.
+	TTL time.Duration
+
+	// MaxEntries is the number of outcomes the in-memory store keeps.  When more are stored,
+	// the least recently used outcome is dropped.
+	MaxEntries int
+}
+
+// sendOutcome is the response of a send, kept to answer the duplicates of the send.
+type sendOutcome struct {
+	Code   int
+	Header http.Header
+	Body   []byte
+}
+
+func (o *sendOutcome) write(w http.ResponseWriter) {
+	for k, values := range o.Header {
+		w.Header()[k] = append([]string(nil), values...)
+	}
+
+	w.WriteHeader(o.Code)
+	// nolint:errcheck
+	w.Write(o.Body)
+}
+
+// sendStore holds the outcomes of sends.  The in-memory store is used by default, while a shared
+// store lets several scytale instances answer the duplicates of each other's sends.
+type sendStore interface {
+	// Get returns the outcome stored for the key, if any.
+	Get(ctx context.Context, key string) (*sendOutcome, bool, error)
+
+	// Set stores the outcome for the key for the ttl.
+	Set(ctx context.Context, key string, outcome *sendOutcome, ttl time.Duration) error
+}
+
+type memorySendStoreEntry struct {
+	outcome *sendOutcome
+	expires time.Time
+}
+
+// memorySendStore is a sendStore kept in an LRU.
+type memorySendStore struct {
+	entries *lru.Cache
+	now     func() time.Time
+}
+
+func newMemorySendStore(maxEntries int) (*memorySendStore, error) {
+	entries, err := lru.New(maxEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	return &memorySendStore{entries: entries, now: time.Now}, nil
+}
+
+func (s *memorySendStore) Get(_ context.Context, key string) (*sendOutcome, bool, error) {
+	v, ok := s.entries.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := v.(memorySendStoreEntry)
+	if !s.now().Before(entry.expires) {
+		s.entries.Remove(key)
+		return nil, false, nil
+	}
+
+	return entry.outcome, true, nil
+}
+
+func (s *memorySendStore) Set(_ context.Context, key string, outcome *sendOutcome, ttl time.Duration) error {
+	s.entries.Add(key, memorySendStoreEntry{outcome: outcome, expires: s.now().Add(ttl)})
+	return nil
+}
+
+// pendingSend is a send in progress, which duplicates arriving in the meantime wait for.  The
+// outcome is nil if the send did not complete.
+type pendingSend struct {
+	done    chan struct{}
+	outcome *sendOutcome
+}
+
+// sendDeduplicator answers a send that repeats an earlier one, by principal, Destination and
+// TransactionUUID, with the outcome of the earlier send instead of sending the message again.
+// Messages without a TransactionUUID are always sent.
+type sendDeduplicator struct {
+	store      sendStore
+	ttl        time.Duration
+	logger     *zap.Logger
+	duplicates metrics.Counter
+
+	lock    sync.Mutex
+	pending map[string]*pendingSend
+}
+
+// newSendDeduplicator creates the sendDeduplicator for the configuration, or nil if it is disabled.
+// A nil store uses an in-memory store.
+func newSendDeduplicator(cfg SendDedupConfig, store sendStore, logger *zap.Logger, duplicates metrics.Counter) (*sendDeduplicator, error) {
+	if cfg.TTL < 0 || cfg.MaxEntries < 0 {
+		return nil, fmt.Errorf("send de-duplication ttl and maxEntries cannot be negative")
+	}
+
+	if cfg.TTL == 0 {
+		return nil, nil
+	}
+
+	if store == nil {
+		if cfg.MaxEntries == 0 {
+			cfg.MaxEntries = defaultSendDedupMaxEntries
+		}
+
+		var err error
+		if store, err = newMemorySendStore(cfg.MaxEntries); err != nil {
+			return nil, err
+		}
+	}
+
+	return &sendDeduplicator{
+		store:      store,
+		ttl:        cfg.TTL,
+		logger:     logger,
+		duplicates: duplicates,
+		pending:    make(map[string]*pendingSend),
+	}, nil
+}
+
+// sendKey is the key of a send, hashed so that the key does not carry the device or client.
+func sendKey(principal string, msg *wrp.Message) string {
+	h := sha256.New()
+	for _, part := range []string{principal, msg.Destination, msg.TransactionUUID} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cacheable reports whether a send's outcome answers its duplicates.  Only successful sends are
+// remembered: failures, such as an offline device, are left for the client to retry.
+func cacheable(code int) bool {
+	return code >= http.StatusOK && code < http.StatusMultipleChoices
+}
+
+// then is middleware, run for each message sent, that de-duplicates the sends.  The message is
+// read from the fanout request's body, as prepared by fanoutPrep.
+func (sd *sendDeduplicator) then(delegate http.Handler) http.Handler {
+	if sd == nil {
+		return delegate
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := sd.key(r)
+		if !ok {
+			delegate.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		sd.lock.Lock()
+		if p, ok := sd.pending[key]; ok {
+			sd.lock.Unlock()
+			select {
+			case <-p.done:
+				if p.outcome == nil {
+					writeRequestError(w, r, http.StatusInternalServerError, "the original send did not complete")
+					return
+				}
+
+				sd.duplicates.With(ReasonLabel, DuplicateInFlight).Add(1)
+				p.outcome.write(w)
+			case <-ctx.Done():
+				writeRequestError(w, r, http.StatusGatewayTimeout, "timed out waiting for the original send")
+			}
+
+			return
+		}
+
+		p := &pendingSend{done: make(chan struct{})}
+		sd.pending[key] = p
+		sd.lock.Unlock()
+
+		defer func() {
+			sd.lock.Lock()
+			delete(sd.pending, key)
+			sd.lock.Unlock()
+			close(p.done)
+		}()
+
+		// the send is pending before the store is read, and its outcome is stored before it stops
+		// pending, so a duplicate either waits for it or finds its outcome in the store
+		outcome, ok, err := sd.store.Get(ctx, key)
+		if err != nil {
+			sd.logger.Error("failed to get send outcome, sending the message", zap.Error(err))
+		}
+
+		if ok {
+			sd.duplicates.With(ReasonLabel, DuplicateReplayed).Add(1)
+			p.outcome = outcome
+			outcome.write(w)
+			return
+		}
+
+		response := newBufferedResponse()
+		delegate.ServeHTTP(response, r)
+		p.outcome = &sendOutcome{
+			Code:   response.code,
+			Header: response.header,
+			Body:   response.body.Bytes(),
+		}
+
+		if cacheable(p.outcome.Code) {
+			if err := sd.store.Set(ctx, key, p.outcome, sd.ttl); err != nil {
+				sd.logger.Error("failed to store send outcome", zap.Error(err))
+			}
+		}
+
+		p.outcome.write(w)
+	})
+}
+
+// key returns the key of the message being sent, if it has a TransactionUUID.
+func (sd *sendDeduplicator) key(r *http.Request) (string, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", false
+	}
+
+	r.Body, r.GetBody = xhttp.NewRewindBytes(body)
+	format, err := wrp.FormatFromContentType(r.Header.Get("Content-Type"), wrp.Msgpack)
+	if err != nil {
+		return "", false
+	}
+
+	var msg wrp.Message
+	if err := wrp.NewDecoderBytes(body, format).Decode(&msg); err != nil || len(msg.TransactionUUID) == 0 {
+		return "", false
+	}
+
+	var principal string
+	if token, ok := bascule.Get(r.Context()); ok {
+		principal = token.Principal()
+	}
+
+	return sendKey(principal, &msg), true
+}
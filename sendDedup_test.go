@@ -0,0 +1,378 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrp-go/v3/wrphttp"
+	"go.uber.org/zap"
+)
+
+type errSendStore struct{}
+
+func (errSendStore) Get(context.Context, string) (*sendOutcome, bool, error) {
+	return nil, false, errors.New("unavailable")
+}
+
+func (errSendStore) Set(context.Context, string, *sendOutcome, time.Duration) error {
+	return errors.New("unavailable")
+}
+
+// hookedSendStore is a memorySendStore that calls afterGet after each Get.
+type hookedSendStore struct {
+	*memorySendStore
+	afterGet func(int)
+	gets     atomic.Int32
+}
+
+func (h *hookedSendStore) Get(ctx context.Context, key string) (*sendOutcome, bool, error) {
+	outcome, ok, err := h.memorySendStore.Get(ctx, key)
+	h.afterGet(int(h.gets.Add(1)))
+	return outcome, ok, err
+}
+
+func newDedupRequest(t *testing.T, principal string, msg wrp.Message) *http.Request {
+	var body []byte
+	require.NoError(t, wrp.NewEncoderBytes(&body, wrp.Msgpack).Encode(&msg))
+
+	request := httptest.NewRequest(http.MethodPost, "/api/v3/device", nil)
+	fanoutPrep(request, body, &wrphttp.Entity{Message: msg, Format: wrp.Msgpack})
+	return request.WithContext(bascule.WithToken(request.Context(), &jwtToken{principal: principal}))
+}
+
+func TestNewSendDeduplicator(t *testing.T) {
+	assert := assert.New(t)
+
+	sd, err := newSendDeduplicator(SendDedupConfig{}, nil, zap.NewNop(), newTestCounter())
+	assert.NoError(err)
+	assert.Nil(sd)
+
+	_, err = newSendDeduplicator(SendDedupConfig{TTL: -time.Second}, nil, zap.NewNop(), newTestCounter())
+	assert.Error(err)
+
+	sd, err = newSendDeduplicator(SendDedupConfig{TTL: time.Minute}, nil, zap.NewNop(), newTestCounter())
+	assert.NoError(err)
+	assert.IsType(&memorySendStore{}, sd.store)
+}
+
+func TestSendDeduplicator(t *testing.T) {
+	msg := wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "dns:test",
+		Destination:     "mac:112233445566/config",
+		TransactionUUID: "DEADBEEF",
+	}
+
+	otherDestination := msg
+	otherDestination.Destination = "mac:665544332211/config"
+
+	noTransaction := msg
+	noTransaction.TransactionUUID = ""
+
+	tests := []struct {
+		name          string
+		status        int
+		store         sendStore
+		first         *http.Request
+		second        *http.Request
+		expectedSends int32
+		expectedCount float64
+	}{
+		{
+			name:          "duplicate",
+			status:        http.StatusAccepted,
+			first:         newDedupRequest(t, "client", msg),
+			second:        newDedupRequest(t, "client", msg),
+			expectedSends: 1,
+			expectedCount: 1,
+		},
+		{
+			name:          "other principal",
+			status:        http.StatusAccepted,
+			first:         newDedupRequest(t, "client", msg),
+			second:        newDedupRequest(t, "other", msg),
+			expectedSends: 2,
+		},
+		{
+			name:          "other destination",
+			status:        http.StatusAccepted,
+			first:         newDedupRequest(t, "client", msg),
+			second:        newDedupRequest(t, "client", otherDestination),
+			expectedSends: 2,
+		},
+		{
+			name:          "no transaction",
+			status:        http.StatusAccepted,
+			first:         newDedupRequest(t, "client", noTransaction),
+			second:        newDedupRequest(t, "client", noTransaction),
+			expectedSends: 2,
+		},
+		{
+			name:          "throttled",
+			status:        http.StatusTooManyRequests,
+			first:         newDedupRequest(t, "client", msg),
+			second:        newDedupRequest(t, "client", msg),
+			expectedSends: 2,
+		},
+		{
+			name:          "device offline",
+			status:        http.StatusNotFound,
+			first:         newDedupRequest(t, "client", msg),
+			second:        newDedupRequest(t, "client", msg),
+			expectedSends: 2,
+		},
+		{
+			name:          "talaria failure",
+			status:        http.StatusBadGateway,
+			first:         newDedupRequest(t, "client", msg),
+			second:        newDedupRequest(t, "client", msg),
+			expectedSends: 2,
+		},
+		{
+			name:          "store unavailable",
+			status:        http.StatusAccepted,
+			store:         errSendStore{},
+			first:         newDedupRequest(t, "client", msg),
+			second:        newDedupRequest(t, "client", msg),
+			expectedSends: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			counter := newTestCounter()
+			sd, err := newSendDeduplicator(SendDedupConfig{TTL: time.Minute}, tt.store, zap.NewNop(), counter)
+			require.NoError(t, err)
+
+			var sends atomic.Int32
+			handler := sd.then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sends.Add(1)
+				w.Header().Set("X-Xmidt-Test", r.Header.Get("X-Webpa-Device-Name"))
+				w.WriteHeader(tt.status)
+				// nolint:errcheck
+				w.Write([]byte("response"))
+			}))
+
+			first := httptest.NewRecorder()
+			handler.ServeHTTP(first, tt.first)
+			second := httptest.NewRecorder()
+			handler.ServeHTTP(second, tt.second)
+
+			assert.Equal(tt.expectedSends, sends.Load())
+			assert.Equal(tt.expectedCount, counter.count)
+			assert.Equal(tt.status, second.Code)
+			assert.Equal("response", second.Body.String())
+			assert.Equal(tt.second.Header.Get("X-Webpa-Device-Name"), second.Header().Get("X-Xmidt-Test"))
+			if tt.expectedCount > 0 {
+				assert.Equal(DuplicateReplayed, counter.labelPairs[ReasonLabel])
+			}
+		})
+	}
+}
+
+func TestSendDeduplicatorInFlight(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	counter := newTestCounter()
+	sd, err := newSendDeduplicator(SendDedupConfig{TTL: time.Minute}, nil, zap.NewNop(), counter)
+	require.NoError(err)
+
+	var (
+		sends   atomic.Int32
+		entered = make(chan struct{})
+		finish  = make(chan struct{})
+		handler = sd.then(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			sends.Add(1)
+			close(entered)
+			<-finish
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		msg = wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Destination:     "mac:112233445566/config",
+			TransactionUUID: "DEADBEEF",
+		}
+
+		wg        sync.WaitGroup
+		responses [2]*httptest.ResponseRecorder
+	)
+
+	for i := range responses {
+		responses[i] = httptest.NewRecorder()
+		request := newDedupRequest(t, "client", msg)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(responses[i], request)
+		}()
+
+		if i == 0 {
+			<-entered
+		}
+	}
+
+	// give the duplicate time to find the original in flight
+	time.Sleep(10 * time.Millisecond)
+	close(finish)
+	wg.Wait()
+
+	assert.Equal(int32(1), sends.Load())
+	assert.Equal(http.StatusOK, responses[0].Code)
+	assert.Equal(http.StatusOK, responses[1].Code)
+	assert.Equal(DuplicateInFlight, counter.labelPairs[ReasonLabel])
+}
+
+func TestSendDeduplicatorOriginalCompletesDuringGet(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	memory, err := newMemorySendStore(10)
+	require.NoError(err)
+
+	var (
+		sends      atomic.Int32
+		entered    = make(chan struct{})
+		finish     = make(chan struct{})
+		release    = sync.OnceFunc(func() { close(finish) })
+		firstDone  = make(chan struct{})
+		store      = &hookedSendStore{memorySendStore: memory}
+		duplicates sync.WaitGroup
+	)
+
+	// the original completes between the duplicate's read of the store and its check of the
+	// pending sends
+	store.afterGet = func(gets int) {
+		if gets > 1 {
+			release()
+			<-firstDone
+		}
+	}
+
+	sd, err := newSendDeduplicator(SendDedupConfig{TTL: time.Minute}, store, zap.NewNop(), newTestCounter())
+	require.NoError(err)
+
+	handler := sd.then(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if sends.Add(1) == 1 {
+			close(entered)
+			<-finish
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	msg := wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Destination:     "mac:112233445566/config",
+		TransactionUUID: "DEADBEEF",
+	}
+
+	original := newDedupRequest(t, "client", msg)
+	go func() {
+		defer close(firstDone)
+		handler.ServeHTTP(httptest.NewRecorder(), original)
+	}()
+
+	<-entered
+	duplicate := httptest.NewRecorder()
+	duplicateRequest := newDedupRequest(t, "client", msg)
+	duplicates.Add(1)
+	go func() {
+		defer duplicates.Done()
+		handler.ServeHTTP(duplicate, duplicateRequest)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	release()
+	duplicates.Wait()
+	<-firstDone
+
+	assert.Equal(int32(1), sends.Load())
+	assert.Equal(http.StatusOK, duplicate.Code)
+}
+
+func TestSendDeduplicatorRetriesFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	counter := newTestCounter()
+	sd, err := newSendDeduplicator(SendDedupConfig{TTL: time.Minute}, nil, zap.NewNop(), counter)
+	require.NoError(t, err)
+
+	msg := wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Destination:     "mac:112233445566/config",
+		TransactionUUID: "DEADBEEF",
+	}
+
+	// the device is offline for the first send, and has reconnected for the retry
+	statuses := []int{http.StatusNotFound, http.StatusOK}
+	var sends int
+	handler := sd.then(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(statuses[sends])
+		sends++
+	}))
+
+	offline := httptest.NewRecorder()
+	handler.ServeHTTP(offline, newDedupRequest(t, "client", msg))
+	assert.Equal(http.StatusNotFound, offline.Code)
+
+	retry := httptest.NewRecorder()
+	handler.ServeHTTP(retry, newDedupRequest(t, "client", msg))
+	assert.Equal(http.StatusOK, retry.Code)
+	assert.Equal(2, sends)
+	assert.Zero(counter.count)
+
+	// the successful outcome answers the next duplicate
+	duplicate := httptest.NewRecorder()
+	handler.ServeHTTP(duplicate, newDedupRequest(t, "client", msg))
+	assert.Equal(http.StatusOK, duplicate.Code)
+	assert.Equal(2, sends)
+	assert.Equal(DuplicateReplayed, counter.labelPairs[ReasonLabel])
+}
+
+func TestMemorySendStore(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	store, err := newMemorySendStore(1)
+	require.NoError(err)
+
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	outcome := &sendOutcome{Code: http.StatusOK}
+	require.NoError(store.Set(t.Context(), "a", outcome, time.Minute))
+
+	actual, ok, err := store.Get(t.Context(), "a")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(outcome, actual)
+
+	// outcomes expire after their ttl
+	now = now.Add(time.Minute)
+	_, ok, _ = store.Get(t.Context(), "a")
+	assert.False(ok)
+
+	// the least recently used outcome is dropped
+	require.NoError(store.Set(t.Context(), "a", outcome, time.Minute))
+	require.NoError(store.Set(t.Context(), "b", outcome, time.Minute))
+	_, ok, _ = store.Get(t.Context(), "a")
+	assert.False(ok)
+	_, ok, _ = store.Get(t.Context(), "b")
+	assert.True(ok)
+}
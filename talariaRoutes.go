@@ -0,0 +1,293 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/xmidt-org/webpa-common/v2/service/monitor"
+	"github.com/xmidt-org/webpa-common/v2/xhttp"
+	"github.com/xmidt-org/webpa-common/v2/xhttp/fanout"
+	"go.uber.org/zap"
+)
+
+// talaria route targets
+const (
+	deviceRouteTarget    = "device"
+	allRouteTarget       = "all"
+	aggregateRouteTarget = "aggregate"
+)
+
+var (
+	errNoTalarias = &xhttp.Error{Code: http.StatusServiceUnavailable, Text: "No talaria instances are available"}
+
+	routeVariablePattern = regexp.MustCompile(`\{([^{}:]+)(:[^{}]*)?\}`)
+)
+
+// TalariaRouteConfig drives a single proxied talaria route through the talariaRoutes configuration.
+type TalariaRouteConfig struct {
+	// Path is the mux path template of the route, relative to the api base.  For example,
+	// /device/{deviceID}/config.
+	Path string
+
+	// Methods are the HTTP methods accepted by the route.  Defaults to GET.
+	Methods []string
+
+	// UpstreamPath is the path template used on talaria, relative to fanout.pathPrefix.
+	// Variables of Path may be used.  Defaults to Path.
+	UpstreamPath string
+
+	// Target is "device", which routes the request to the talaria owning the deviceID
+	// path variable, "all", which sends the request to every talaria and returns the first
+	// successful response, or "aggregate", which merges the JSON responses of every talaria.
+	// Defaults to device.
+	Target string
+
+	// SumFields are the names of the count fields an aggregate route sums across talarias,
+	// wherever they are in the responses.  Every other number keeps the first talaria's value.
+	// Defaults to count.
+	SumFields []string
+}
+
+// defaultSumFields are the fields an aggregate route sums when none are configured.
+var defaultSumFields = []string{"count"}
+
+// talariaRoute is a validated TalariaRouteConfig.
+type talariaRoute struct {
+	path         string
+	methods      []string
+	upstreamPath string
+	target       string
+	sumFields    []string
+}
+
+func newTalariaRoute(c TalariaRouteConfig) (talariaRoute, error) {
+	r := talariaRoute{
+		path:         c.Path,
+		upstreamPath: c.UpstreamPath,
+		target:       c.Target,
+	}
+
+	if !strings.HasPrefix(r.path, "/") {
+		return talariaRoute{}, fmt.Errorf("talaria route path [%s] must start with /", r.path)
+	}
+
+	if len(r.upstreamPath) == 0 {
+		r.upstreamPath = routeVariablePattern.ReplaceAllString(r.path, "{$1}")
+	}
+
+	if len(r.target) == 0 {
+		r.target = deviceRouteTarget
+	}
+
+	if len(c.SumFields) > 0 && r.target != aggregateRouteTarget {
+		return talariaRoute{}, fmt.Errorf("talaria route [%s] only sums fields with the %s target", r.path, aggregateRouteTarget)
+	}
+
+	switch r.target {
+	case deviceRouteTarget:
+		if !hasRouteVariable(r.path, deviceID) {
+			return talariaRoute{}, fmt.Errorf("talaria route [%s] targets a device but has no {%s} variable", r.path, deviceID)
+		}
+	case allRouteTarget:
+	case aggregateRouteTarget:
+		r.sumFields = c.SumFields
+		if len(r.sumFields) == 0 {
+			r.sumFields = defaultSumFields
+		}
+	default:
+		return talariaRoute{}, fmt.Errorf("invalid target [%s] for talaria route [%s]", r.target, r.path)
+	}
+
+	for _, match := range routeVariablePattern.FindAllStringSubmatch(r.upstreamPath, -1) {
+		if !hasRouteVariable(r.path, match[1]) {
+			return talariaRoute{}, fmt.Errorf("talaria route [%s] upstream path uses unknown variable {%s}", r.path, match[1])
+		}
+	}
+
+	for _, m := range c.Methods {
+		r.methods = append(r.methods, strings.ToUpper(m))
+	}
+
+	if len(r.methods) == 0 {
+		r.methods = []string{http.MethodGet}
+	}
+
+	return r, nil
+}
+
+func hasRouteVariable(path, name string) bool {
+	for _, match := range routeVariablePattern.FindAllStringSubmatch(path, -1) {
+		if match[1] == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expandUpstreamPath substitutes the request's path variables into the upstream path template.
+func expandUpstreamPath(template string, vars map[string]string) string {
+	return routeVariablePattern.ReplaceAllStringFunc(template, func(v string) string {
+		return vars[routeVariablePattern.FindStringSubmatch(v)[1]]
+	})
+}
+
+// talariaRouteTable holds everything needed to proxy the configured talaria routes.
+type talariaRouteTable struct {
+	routes          []talariaRoute
+	urlPrefix       string
+	fanoutPrefix    string
+	authChain       alice.Chain
+	fanoutChain     alice.Chain
+	deviceEndpoints fanout.Endpoints
+	allEndpoints    fanout.Endpoints
+	options         []fanout.Option
+
+	// transactor and authorization are used by aggregate routes, which query each talaria
+	// directly rather than through a fanout.Handler.
+	transactor    fanout.Transactor
+	authorization string
+}
+
+func newTalariaRoutes(configs []TalariaRouteConfig) ([]talariaRoute, error) {
+	routes := make([]talariaRoute, 0, len(configs))
+	for _, c := range configs {
+		r, err := newTalariaRoute(c)
+		if err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, r)
+	}
+
+	return routes, nil
+}
+
+// register adds a handler for each route to the router.  Every route is covered by the auth chain.
+func (t *talariaRouteTable) register(router *mux.Router, logger *zap.Logger) {
+	for _, r := range t.routes {
+		logger.Info("proxying talaria route",
+			zap.String("path", r.path),
+			zap.Strings("methods", r.methods),
+			zap.String("upstreamPath", r.upstreamPath),
+			zap.String("target", r.target))
+
+		router.Handle(t.urlPrefix+r.path, t.handler(r)).Methods(r.methods...)
+	}
+}
+
+// upstreamPath returns the path on talaria for a request to the route.
+func (t *talariaRouteTable) upstreamPath(r talariaRoute, original *http.Request) string {
+	return t.fanoutPrefix + expandUpstreamPath(r.upstreamPath, mux.Vars(original))
+}
+
+func (t *talariaRouteTable) handler(r talariaRoute) http.Handler {
+	if r.target == aggregateRouteTarget {
+		return t.authChain.Extend(t.fanoutChain).Then(&aggregateHandler{
+			endpoints:     t.allEndpoints,
+			transactor:    t.transactor,
+			authorization: t.authorization,
+			sumFields:     newSumFields(r.sumFields),
+			upstreamPath: func(original *http.Request) string {
+				return t.upstreamPath(r, original)
+			},
+		})
+	}
+
+	var (
+		chain     = t.authChain.Extend(t.fanoutChain)
+		endpoints = t.allEndpoints
+		before    []fanout.FanoutRequestFunc
+	)
+
+	if r.target == deviceRouteTarget {
+		chain = chain.Extend(validateDeviceID())
+		endpoints = t.deviceEndpoints
+		// required for petasos
+		before = append(before, fanout.ForwardVariableAsHeader(deviceID, "X-Webpa-Device-Name"))
+	}
+
+	before = append(before,
+		forwardContextValues,
+		fanout.ForwardHeaders("Content-Type", "Accept"),
+		func(ctx context.Context, original, fanout *http.Request, body []byte) (context.Context, error) {
+			fanout.URL.Path = t.upstreamPath(r, original)
+			fanout.URL.RawPath = ""
+			fanout.URL.RawQuery = original.URL.RawQuery
+			fanout.Body, fanout.GetBody = xhttp.NewRewindBytes(body)
+			fanout.ContentLength = int64(len(body))
+			return ctx, nil
+		},
+	)
+
+	return chain.Then(
+		fanout.New(
+			endpoints,
+			append(
+				t.options,
+				fanout.WithFanoutBefore(before...),
+				fanout.WithFanoutFailure(
+					fanout.ReturnHeadersWithPrefix("X-"),
+				),
+				fanout.WithFanoutAfter(
+					fanout.ReturnHeadersWithPrefix("X-"),
+				),
+			)...,
+		),
+	)
+}
+
+// allEndpoints is a fanout.Endpoints that returns every talaria known to service discovery,
+// for routes that have no single owning talaria.
+type allEndpoints struct {
+	logger *zap.Logger
+	lock   sync.RWMutex
+	urls   []*url.URL
+}
+
+func newAllEndpoints(logger *zap.Logger) *allEndpoints {
+	return &allEndpoints{logger: logger}
+}
+
+// MonitorEvent updates the endpoints with the instances of a service discovery event.
+func (a *allEndpoints) MonitorEvent(e monitor.Event) {
+	if e.Err != nil {
+		return
+	}
+
+	urls := make([]*url.URL, 0, len(e.Instances))
+	for _, instance := range e.Instances {
+		u, err := url.Parse(instance)
+		if err != nil {
+			a.logger.Error("skipping invalid talaria instance", zap.String("instance", instance), zap.Error(err))
+			continue
+		}
+
+		urls = append(urls, u)
+	}
+
+	a.lock.Lock()
+	a.urls = urls
+	a.lock.Unlock()
+}
+
+func (a *allEndpoints) FanoutURLs(*http.Request) ([]*url.URL, error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	if len(a.urls) == 0 {
+		return nil, errNoTalarias
+	}
+
+	return a.urls, nil
+}
@@ -0,0 +1,253 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/webpa-common/v2/service/monitor"
+	"github.com/xmidt-org/webpa-common/v2/xhttp/fanout"
+	"go.uber.org/zap"
+)
+
+func TestNewTalariaRoute(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        TalariaRouteConfig
+		expectedRoute talariaRoute
+		shouldErr     bool
+	}{
+		{
+			name:   "defaults",
+			config: TalariaRouteConfig{Path: "/device/{deviceID}/config"},
+			expectedRoute: talariaRoute{
+				path:         "/device/{deviceID}/config",
+				methods:      []string{http.MethodGet},
+				upstreamPath: "/device/{deviceID}/config",
+				target:       deviceRouteTarget,
+			},
+		},
+		{
+			name: "all talarias",
+			config: TalariaRouteConfig{
+				Path:         "/devices",
+				Methods:      []string{"get", "post"},
+				UpstreamPath: "/devices/list",
+				Target:       allRouteTarget,
+			},
+			expectedRoute: talariaRoute{
+				path:         "/devices",
+				methods:      []string{http.MethodGet, http.MethodPost},
+				upstreamPath: "/devices/list",
+				target:       allRouteTarget,
+			},
+		},
+		{
+			name:   "variable patterns are dropped from the upstream path",
+			config: TalariaRouteConfig{Path: "/device/{deviceID}/{section:[a-z]+}"},
+			expectedRoute: talariaRoute{
+				path:         "/device/{deviceID}/{section:[a-z]+}",
+				methods:      []string{http.MethodGet},
+				upstreamPath: "/device/{deviceID}/{section}",
+				target:       deviceRouteTarget,
+			},
+		},
+		{
+			name:   "aggregate",
+			config: TalariaRouteConfig{Path: "/devices/count", Target: aggregateRouteTarget},
+			expectedRoute: talariaRoute{
+				path:         "/devices/count",
+				methods:      []string{http.MethodGet},
+				upstreamPath: "/devices/count",
+				target:       aggregateRouteTarget,
+				sumFields:    defaultSumFields,
+			},
+		},
+		{
+			name: "aggregate sum fields",
+			config: TalariaRouteConfig{
+				Path:      "/devices/stats",
+				Target:    aggregateRouteTarget,
+				SumFields: []string{"connected", "messages"},
+			},
+			expectedRoute: talariaRoute{
+				path:         "/devices/stats",
+				methods:      []string{http.MethodGet},
+				upstreamPath: "/devices/stats",
+				target:       aggregateRouteTarget,
+				sumFields:    []string{"connected", "messages"},
+			},
+		},
+		{
+			name:      "sum fields without aggregate",
+			config:    TalariaRouteConfig{Path: "/devices", Target: allRouteTarget, SumFields: []string{"count"}},
+			shouldErr: true,
+		},
+		{
+			name:      "relative path",
+			config:    TalariaRouteConfig{Path: "device/{deviceID}/config"},
+			shouldErr: true,
+		},
+		{
+			name:      "device target without device id",
+			config:    TalariaRouteConfig{Path: "/devices"},
+			shouldErr: true,
+		},
+		{
+			name:      "unknown target",
+			config:    TalariaRouteConfig{Path: "/devices", Target: "some"},
+			shouldErr: true,
+		},
+		{
+			name:      "unknown upstream variable",
+			config:    TalariaRouteConfig{Path: "/device/{deviceID}", UpstreamPath: "/device/{id}"},
+			shouldErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newTalariaRoute(tt.config)
+			if tt.shouldErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedRoute, r)
+		})
+	}
+}
+
+func TestExpandUpstreamPath(t *testing.T) {
+	assert := assert.New(t)
+	vars := map[string]string{deviceID: "mac:112233445566", "section": "wifi"}
+
+	assert.Equal("/device/mac:112233445566/wifi", expandUpstreamPath("/device/{deviceID}/{section}", vars))
+	assert.Equal("/devices", expandUpstreamPath("/devices", vars))
+}
+
+func TestAllEndpoints(t *testing.T) {
+	assert := assert.New(t)
+	a := newAllEndpoints(zap.NewNop())
+
+	_, err := a.FanoutURLs(nil)
+	assert.ErrorIs(err, errNoTalarias)
+
+	a.MonitorEvent(monitor.Event{Instances: []string{"http://talaria-0:6200", "http://talaria-1:6200", ":bad"}})
+	urls, err := a.FanoutURLs(nil)
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	assert.Equal("talaria-0:6200", urls[0].Host)
+	assert.Equal("talaria-1:6200", urls[1].Host)
+
+	a.MonitorEvent(monitor.Event{Err: errors.New("discovery failed")})
+	urls, err = a.FanoutURLs(nil)
+	assert.NoError(err)
+	assert.Len(urls, 2)
+}
+
+func TestTalariaRouteTable(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var (
+		upstreamPath   string
+		upstreamQuery  string
+		upstreamDevice string
+	)
+
+	talaria := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		upstreamQuery = r.URL.RawQuery
+		upstreamDevice = r.Header.Get("X-Webpa-Device-Name")
+		w.Header().Set("X-Talaria-Build", "1")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer talaria.Close()
+
+	endpoints, err := fanout.ParseURLs(talaria.URL)
+	require.NoError(err)
+
+	routes, err := newTalariaRoutes([]TalariaRouteConfig{
+		{Path: "/device/{deviceID}/config", UpstreamPath: "/device/{deviceID}/cfg"},
+		{Path: "/devices", Target: allRouteTarget},
+	})
+	require.NoError(err)
+
+	router := mux.NewRouter()
+	table := &talariaRouteTable{
+		routes:          routes,
+		urlPrefix:       "/api/v3",
+		fanoutPrefix:    "/api/v2",
+		authChain:       alice.New(),
+		fanoutChain:     alice.New(),
+		deviceEndpoints: endpoints,
+		allEndpoints:    endpoints,
+	}
+	table.register(router, zap.NewNop())
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		expectedCode   int
+		expectedPath   string
+		expectedQuery  string
+		expectedDevice string
+	}{
+		{
+			name:           "device route",
+			method:         http.MethodGet,
+			path:           "/api/v3/device/mac:112233445566/config?fields=all",
+			expectedCode:   http.StatusOK,
+			expectedPath:   "/api/v2/device/mac:112233445566/cfg",
+			expectedQuery:  "fields=all",
+			expectedDevice: "mac:112233445566",
+		},
+		{
+			name:         "all route",
+			method:       http.MethodGet,
+			path:         "/api/v3/devices",
+			expectedCode: http.StatusOK,
+			expectedPath: "/api/v2/devices",
+		},
+		{
+			name:         "invalid device id",
+			method:       http.MethodGet,
+			path:         "/api/v3/device/112233445566/config",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "method not allowed",
+			method:       http.MethodDelete,
+			path:         "/api/v3/devices",
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamPath, upstreamQuery, upstreamDevice = "", "", ""
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, httptest.NewRequest(tt.method, tt.path, strings.NewReader("")))
+
+			assert.Equal(tt.expectedCode, response.Code)
+			assert.Equal(tt.expectedPath, upstreamPath)
+			assert.Equal(tt.expectedQuery, upstreamQuery)
+			assert.Equal(tt.expectedDevice, upstreamDevice)
+			if tt.expectedCode == http.StatusOK {
+				assert.Equal("1", response.Header().Get("X-Talaria-Build"))
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/candlelight"
+	"github.com/xmidt-org/webpa-common/v2/xhttp"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrp-go/v3/wrphttp"
+)
+
+const (
+	wrpEnrichConfigKey = "wrpEnrich"
+
+	// transactionUUIDHeader echoes the TransactionUUID scytale generated for a message.
+	transactionUUIDHeader = "X-Xmidt-Transaction-Uuid"
+
+	// deprecatedMessageTypeHeader is the older header wrphttp also decodes WRP headers for.
+	deprecatedMessageTypeHeader = "X-Midt-Msg-Type"
+)
+
+// The sources a metadata entry's value can be taken from.
+const (
+	metadataFromServer    = "server"
+	metadataFromRegion    = "region"
+	metadataFromPrincipal = "principal"
+	metadataFromTraceID   = "traceID"
+)
+
+// WRPMetadataConfig is one metadata entry added to the messages sent.  The value is either
+// the fixed Value or is taken From one of server, region, principal or traceID.
+type WRPMetadataConfig struct {
+	Key   string
+	Value string
+	From  string
+}
+
+// WRPEnrichConfig drives what scytale adds to the messages sent through the wrpEnrich
+// configuration.  Metadata is a list rather than a map as configuration keys are case insensitive.
+type WRPEnrichConfig struct {
+	// GenerateTransactionUUID gives messages without a TransactionUUID a generated one.
+	GenerateTransactionUUID bool
+
+	// Metadata is the entries added to the messages' metadata.  Entries the caller already set
+	// are left as is.
+	Metadata []WRPMetadataConfig
+}
+
+type wrpMetadataEntry struct {
+	key   string
+	value func(context.Context) string
+}
+
+// wrpEnricher adds scytale-side information to the messages sent to devices.
+type wrpEnricher struct {
+	generateTransactionUUID bool
+	newUUID                 func() string
+	metadata                []wrpMetadataEntry
+}
+
+// newWRPEnricher creates the wrpEnricher for the configuration, or nil if it adds nothing.  The
+// server and region are those of this scytale instance.
+func newWRPEnricher(cfg WRPEnrichConfig, server, region string) (*wrpEnricher, error) {
+	if !cfg.GenerateTransactionUUID && len(cfg.Metadata) == 0 {
+		return nil, nil
+	}
+
+	e := &wrpEnricher{
+		generateTransactionUUID: cfg.GenerateTransactionUUID,
+		newUUID:                 uuid.NewString,
+		metadata:                make([]wrpMetadataEntry, 0, len(cfg.Metadata)),
+	}
+
+	for i, m := range cfg.Metadata {
+		if len(m.Key) == 0 {
+			return nil, fmt.Errorf("wrp metadata entry %d is missing a key", i)
+		}
+
+		if len(m.Value) > 0 && len(m.From) > 0 {
+			return nil, fmt.Errorf("wrp metadata entry %s cannot have both a value and a from", m.Key)
+		}
+
+		entry := wrpMetadataEntry{key: m.Key}
+		switch m.From {
+		case "":
+			entry.value = fixedMetadata(m.Value)
+		case metadataFromServer:
+			entry.value = fixedMetadata(server)
+		case metadataFromRegion:
+			entry.value = fixedMetadata(region)
+		case metadataFromPrincipal:
+			entry.value = principalMetadata
+		case metadataFromTraceID:
+			entry.value = traceIDMetadata
+		default:
+			return nil, fmt.Errorf("wrp metadata entry %s has an unknown from %q", m.Key, m.From)
+		}
+
+		e.metadata = append(e.metadata, entry)
+	}
+
+	return e, nil
+}
+
+func fixedMetadata(value string) func(context.Context) string {
+	return func(context.Context) string {
+		return value
+	}
+}
+
+func principalMetadata(ctx context.Context) string {
+	if token, ok := bascule.Get(ctx); ok {
+		return token.Principal()
+	}
+
+	return ""
+}
+
+func traceIDMetadata(ctx context.Context) string {
+	traceID, _, _ := candlelight.ExtractTraceInfo(ctx)
+	return traceID
+}
+
+// enrich adds the TransactionUUID and metadata to the message.  It returns the generated
+// TransactionUUID, if any, and whether the message was modified.  Empty metadata values are
+// not added.  The metadata map is copied before it is changed, as copies of a message, such as
+// those of a broadcast, share it.
+func (e *wrpEnricher) enrich(ctx context.Context, msg *wrp.Message) (generated string, modified bool) {
+	if e == nil {
+		return "", false
+	}
+
+	if e.generateTransactionUUID && len(msg.TransactionUUID) == 0 {
+		generated = e.newUUID()
+		msg.TransactionUUID = generated
+		modified = true
+	}
+
+	copied := false
+	for _, m := range e.metadata {
+		if _, ok := msg.Metadata[m.key]; ok {
+			continue
+		}
+
+		value := m.value(ctx)
+		if len(value) == 0 {
+			continue
+		}
+
+		if !copied {
+			metadata := make(map[string]string, len(msg.Metadata)+len(e.metadata))
+			maps.Copy(metadata, msg.Metadata)
+			msg.Metadata = metadata
+			copied = true
+		}
+
+		msg.Metadata[m.key] = value
+		modified = true
+	}
+
+	return generated, modified
+}
+
+// then is middleware that enriches the message of a WRP send request before it is validated and
+// before the send handler decodes it, so that both see the enriched message.  A modified message
+// replaces the request body, encoded in the request's format, and a generated TransactionUUID is
+// echoed back in the response.  Requests that fail to decode are passed on as is, for the
+// validators or the send handler to reject.
+func (e *wrpEnricher) then(delegate http.Handler) http.Handler {
+	if e == nil {
+		return delegate
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeRequestError(w, r, http.StatusBadRequest, fmt.Sprintf("failed to read request body: %s", err))
+			return
+		}
+
+		r.Body, r.GetBody = xhttp.NewRewindBytes(body)
+		entity, err := sendWRPDecoder(r.Context(), r)
+		if err != nil {
+			r.Body, r.GetBody = xhttp.NewRewindBytes(body)
+			delegate.ServeHTTP(w, r)
+			return
+		}
+
+		generated, modified := e.enrich(r.Context(), &entity.Message)
+		if !modified {
+			r.Body, r.GetBody = xhttp.NewRewindBytes(body)
+			delegate.ServeHTTP(w, r)
+			return
+		}
+
+		var enriched []byte
+		if err := wrp.NewEncoderBytes(&enriched, entity.Format).Encode(entity.Message); err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+
+		// the enriched message is always sent in the body, even when the request carried it in
+		// WRP headers
+		r.Header.Del(wrphttp.MessageTypeHeader)
+		r.Header.Del(deprecatedMessageTypeHeader)
+		r.Header.Set("Content-Type", entity.Format.ContentType())
+		r.Body, r.GetBody = xhttp.NewRewindBytes(enriched)
+		r.ContentLength = int64(len(enriched))
+
+		if len(generated) > 0 {
+			w.Header().Set(transactionUUIDHeader, generated)
+		}
+
+		delegate.ServeHTTP(w, r)
+	})
+}
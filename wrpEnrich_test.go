@@ -0,0 +1,285 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrp-go/v3/wrphttp"
+	"go.uber.org/zap"
+)
+
+func TestNewWRPEnricher(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         WRPEnrichConfig
+		expectedNil bool
+		expectedErr bool
+	}{
+		{
+			name:        "not configured",
+			expectedNil: true,
+		},
+		{
+			name: "generate",
+			cfg:  WRPEnrichConfig{GenerateTransactionUUID: true},
+		},
+		{
+			name: "metadata",
+			cfg: WRPEnrichConfig{Metadata: []WRPMetadataConfig{
+				{Key: "/scytale/server", From: metadataFromServer},
+				{Key: "/scytale/env", Value: "test"},
+			}},
+		},
+		{
+			name:        "missing key",
+			cfg:         WRPEnrichConfig{Metadata: []WRPMetadataConfig{{Value: "test"}}},
+			expectedErr: true,
+		},
+		{
+			name:        "value and from",
+			cfg:         WRPEnrichConfig{Metadata: []WRPMetadataConfig{{Key: "/scytale/env", Value: "test", From: metadataFromRegion}}},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown from",
+			cfg:         WRPEnrichConfig{Metadata: []WRPMetadataConfig{{Key: "/scytale/env", From: "environment"}}},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := newWRPEnricher(tt.cfg, "scytale", "east")
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedNil, e == nil)
+		})
+	}
+}
+
+func TestWRPEnricherEnrich(t *testing.T) {
+	cfg := WRPEnrichConfig{
+		GenerateTransactionUUID: true,
+		Metadata: []WRPMetadataConfig{
+			{Key: "/scytale/server", From: metadataFromServer},
+			{Key: "/scytale/region", From: metadataFromRegion},
+			{Key: "/scytale/client-id", From: metadataFromPrincipal},
+			{Key: "/scytale/trace-id", From: metadataFromTraceID},
+			{Key: "/scytale/env", Value: "test"},
+		},
+	}
+
+	tests := []struct {
+		name              string
+		msg               wrp.Message
+		expectedGenerated string
+		expectedMetadata  map[string]string
+	}{
+		{
+			name:              "empty",
+			expectedGenerated: "generated",
+			expectedMetadata: map[string]string{
+				"/scytale/server":    "scytale",
+				"/scytale/region":    "east",
+				"/scytale/client-id": "client",
+				"/scytale/env":       "test",
+			},
+		},
+		{
+			name: "caller values kept",
+			msg: wrp.Message{
+				TransactionUUID: "DEADBEEF",
+				Metadata:        map[string]string{"/scytale/env": "prod", "/caller": "value"},
+			},
+			expectedMetadata: map[string]string{
+				"/scytale/server":    "scytale",
+				"/scytale/region":    "east",
+				"/scytale/client-id": "client",
+				"/scytale/env":       "prod",
+				"/caller":            "value",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			e, err := newWRPEnricher(cfg, "scytale", "east")
+			require.NoError(t, err)
+			e.newUUID = func() string { return "generated" }
+
+			original := maps.Clone(tt.msg.Metadata)
+			shared := tt.msg.Metadata
+			ctx := bascule.WithToken(t.Context(), &jwtToken{principal: "client"})
+			generated, modified := e.enrich(ctx, &tt.msg)
+			assert.True(modified)
+			assert.Equal(tt.expectedGenerated, generated)
+			assert.Equal(tt.expectedMetadata, tt.msg.Metadata)
+			assert.NotEmpty(tt.msg.TransactionUUID)
+
+			// the caller's map, which copies of the message may share, is left alone
+			assert.Equal(original, shared)
+		})
+	}
+}
+
+func TestWRPEnricherThen(t *testing.T) {
+	msg := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:test",
+		Destination: "mac:112233445566/config",
+	}
+
+	tests := []struct {
+		name              string
+		request           func() *http.Request
+		expectedGenerated string
+		expectedMetadata  map[string]string
+	}{
+		{
+			name: "msgpack body",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodPost, "/api/v3/device", bytes.NewReader(wrp.MustEncode(msg, wrp.Msgpack)))
+				r.Header.Set("Content-Type", wrp.Msgpack.ContentType())
+				return r
+			},
+			expectedGenerated: "generated",
+			expectedMetadata:  map[string]string{"/scytale/server": "scytale"},
+		},
+		{
+			name: "json body with a transaction uuid",
+			request: func() *http.Request {
+				m := msg
+				m.TransactionUUID = "DEADBEEF"
+				r := httptest.NewRequest(http.MethodPost, "/api/v3/device", bytes.NewReader(wrp.MustEncode(m, wrp.JSON)))
+				r.Header.Set("Content-Type", wrp.JSON.ContentType())
+				return r
+			},
+			expectedMetadata: map[string]string{"/scytale/server": "scytale"},
+		},
+		{
+			name: "wrp headers",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodPost, "/api/v3/device", strings.NewReader("payload"))
+				r.Header.Set(wrphttp.MessageTypeHeader, msg.Type.FriendlyName())
+				r.Header.Set(wrphttp.SourceHeader, msg.Source)
+				r.Header.Set(wrphttp.DestinationHeader, msg.Destination)
+				return r
+			},
+			expectedGenerated: "generated",
+			expectedMetadata:  map[string]string{"/scytale/server": "scytale"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			e, err := newWRPEnricher(WRPEnrichConfig{
+				GenerateTransactionUUID: true,
+				Metadata:                []WRPMetadataConfig{{Key: "/scytale/server", From: metadataFromServer}},
+			}, "scytale", "east")
+			require.NoError(err)
+			e.newUUID = func() string { return "generated" }
+
+			var sent *wrphttp.Entity
+			handler := e.then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sent, err = sendWRPDecoder(r.Context(), r)
+				require.NoError(err)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, tt.request())
+
+			assert.Equal(http.StatusAccepted, response.Code)
+			assert.Equal(tt.expectedGenerated, response.Header().Get(transactionUUIDHeader))
+			require.NotNil(sent)
+			assert.NotEmpty(sent.Message.TransactionUUID)
+			assert.Equal(tt.expectedMetadata, sent.Message.Metadata)
+		})
+	}
+}
+
+func TestWRPEnricherThenInvalidMessage(t *testing.T) {
+	e, err := newWRPEnricher(WRPEnrichConfig{GenerateTransactionUUID: true}, "scytale", "east")
+	require.NoError(t, err)
+
+	var body []byte
+	handler := e.then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err = io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v3/device", strings.NewReader("not a message"))
+	r.Header.Set("Content-Type", wrp.JSON.ContentType())
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, r)
+
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Equal(t, "not a message", string(body))
+	assert.Empty(t, response.Header().Get(transactionUUIDHeader))
+}
+
+// TestWRPEnricherOrdering checks that a message is enriched before it is validated and before
+// the send handler's response writer is chosen, as the send chain is put together.
+func TestWRPEnricherOrdering(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	e, err := newWRPEnricher(WRPEnrichConfig{GenerateTransactionUUID: true}, "scytale", "east")
+	require.NoError(err)
+	e.newUUID = func() string { return "generated" }
+
+	vs, err := newWRPValidators([]WRPValidatorConfig{{Type: transactionUUIDValidatorType, Level: enforceCheck}}, newTestFactory())
+	require.NoError(err)
+
+	var (
+		writer  wrphttp.ResponseWriter
+		message wrp.Message
+	)
+
+	sendWRPHandler := wrphttp.NewHTTPHandler(
+		wrphttp.HandlerFunc(func(w wrphttp.ResponseWriter, r *wrphttp.Request) {
+			writer = w
+			message = r.Entity.Message
+			w.WriteHeader(http.StatusOK)
+		}),
+		wrphttp.WithDecoder(sendWRPDecoder),
+		wrphttp.WithNewResponseWriter(newWRPResponseWriterFactory(true, nil)))
+
+	handler := e.then(vs.then(zap.NewNop())(sendWRPHandler))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v3/device", bytes.NewReader(wrp.MustEncode(wrp.Message{
+		Type:        wrp.SimpleRequestResponseMessageType,
+		Source:      "dns:test",
+		Destination: "mac:112233445566/config",
+	}, wrp.Msgpack)))
+	r.Header.Set("Content-Type", wrp.Msgpack.ContentType())
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, r)
+
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal("generated", response.Header().Get(transactionUUIDHeader))
+	assert.Equal("generated", message.TransactionUUID)
+	assert.IsType(&deviceResponseWriter{}, writer)
+}
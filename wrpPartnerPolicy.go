@@ -0,0 +1,259 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// How the message's PartnerIDs must match the token's allowed partners.
+const (
+	// matchSubset requires every PartnerID of the message to be allowed.
+	matchSubset = "subset"
+
+	// matchIntersection requires at least one PartnerID of the message to be allowed.
+	matchIntersection = "intersection"
+)
+
+// What is done with a message whose PartnerIDs are missing or do not match.
+const (
+	// policyReject rejects the message.  In monitor mode, the rejection is only counted.
+	policyReject = "reject"
+
+	// policyRewrite replaces the message's PartnerIDs with the allowed partners.
+	policyRewrite = "rewrite"
+
+	// policyStrip drops the PartnerIDs that are not allowed, rejecting the message when none
+	// are left.  It only applies to mismatches.
+	policyStrip = "strip"
+
+	// policyAllow sends the message as is.
+	policyAllow = "allow"
+)
+
+// WRPPartnerPolicyConfig is a partner ID policy.  Fields left empty take the value of the
+// default policy, and ultimately of the built-in policy: the allowedResources.allowedPartners
+// claim, subset matching, and rejecting in enforce mode or rewriting in monitor mode.
+type WRPPartnerPolicyConfig struct {
+	// Claims are the dot separated paths of the claims holding the allowed partners.  The
+	// first claim present in the token is used.
+	Claims []string
+
+	// Match is either subset or intersection.
+	Match string
+
+	// OnMissing is what is done with messages without PartnerIDs: reject, rewrite or allow.
+	OnMissing string
+
+	// OnMismatch is what is done with messages whose PartnerIDs do not match: reject, rewrite,
+	// strip or allow.
+	OnMismatch string
+}
+
+// WRPPartnerRuleConfig applies a policy to the messages of some types, sent through some
+// routes.  Empty MessageTypes or Routes match every message.
+type WRPPartnerRuleConfig struct {
+	// MessageTypes are the names of the message types the rule applies to.
+	MessageTypes []string
+
+	// Routes are regular expressions matched against the request path.
+	Routes []string
+
+	WRPPartnerPolicyConfig `mapstructure:",squash"`
+}
+
+// wrpPartnersPolicy is a resolved WRPPartnerPolicyConfig.  Empty fields are filled in by
+// withDefaults.
+type wrpPartnersPolicy struct {
+	claims     [][]string
+	match      string
+	onMissing  string
+	onMismatch string
+}
+
+func newWRPPartnersPolicy(cfg WRPPartnerPolicyConfig) (wrpPartnersPolicy, error) {
+	p := wrpPartnersPolicy{
+		match:      cfg.Match,
+		onMissing:  cfg.OnMissing,
+		onMismatch: cfg.OnMismatch,
+	}
+
+	for _, claim := range cfg.Claims {
+		if len(claim) == 0 {
+			return p, fmt.Errorf("partner ID claim paths cannot be empty")
+		}
+
+		p.claims = append(p.claims, strings.Split(claim, "."))
+	}
+
+	switch p.match {
+	case "", matchSubset, matchIntersection:
+	default:
+		return p, fmt.Errorf("invalid partner ID match [%s]", p.match)
+	}
+
+	switch p.onMissing {
+	case "", policyReject, policyRewrite, policyAllow:
+	default:
+		return p, fmt.Errorf("invalid partner ID onMissing action [%s]", p.onMissing)
+	}
+
+	switch p.onMismatch {
+	case "", policyReject, policyRewrite, policyStrip, policyAllow:
+	default:
+		return p, fmt.Errorf("invalid partner ID onMismatch action [%s]", p.onMismatch)
+	}
+
+	return p, nil
+}
+
+// merge returns the policy with its empty fields taken from base.
+func (p wrpPartnersPolicy) merge(base wrpPartnersPolicy) wrpPartnersPolicy {
+	if len(p.claims) == 0 {
+		p.claims = base.claims
+	}
+
+	if len(p.match) == 0 {
+		p.match = base.match
+	}
+
+	if len(p.onMissing) == 0 {
+		p.onMissing = base.onMissing
+	}
+
+	if len(p.onMismatch) == 0 {
+		p.onMismatch = base.onMismatch
+	}
+
+	return p
+}
+
+// withDefaults fills in the empty fields with the built-in policy.
+func (p wrpPartnersPolicy) withDefaults(strict bool) wrpPartnersPolicy {
+	action := policyRewrite
+	if strict {
+		action = policyReject
+	}
+
+	return p.merge(wrpPartnersPolicy{
+		claims:     [][]string{partnerKeys},
+		match:      matchSubset,
+		onMissing:  action,
+		onMismatch: action,
+	})
+}
+
+// matches reports whether the message's PartnerIDs are allowed by the policy.
+func (p wrpPartnersPolicy) matches(partnerIDs, allowed []string) bool {
+	if p.match == matchIntersection {
+		return slices.ContainsFunc(partnerIDs, func(id string) bool {
+			return contains(allowed, id)
+		})
+	}
+
+	return isSubset(partnerIDs, allowed)
+}
+
+type wrpPartnersRule struct {
+	messageTypes map[wrp.MessageType]bool
+	routes       []*regexp.Regexp
+	policy       wrpPartnersPolicy
+}
+
+func (r *wrpPartnersRule) applies(path string, msg *wrp.Message) bool {
+	if len(r.messageTypes) > 0 && !r.messageTypes[msg.Type] {
+		return false
+	}
+
+	if len(r.routes) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(r.routes, func(route *regexp.Regexp) bool {
+		return route.MatchString(path)
+	})
+}
+
+func newWRPPartnersRule(cfg WRPPartnerRuleConfig, base wrpPartnersPolicy) (wrpPartnersRule, error) {
+	var r wrpPartnersRule
+	policy, err := newWRPPartnersPolicy(cfg.WRPPartnerPolicyConfig)
+	if err != nil {
+		return r, err
+	}
+
+	r.policy = policy.merge(base)
+	if len(cfg.MessageTypes) > 0 {
+		r.messageTypes = make(map[wrp.MessageType]bool, len(cfg.MessageTypes))
+		for _, name := range cfg.MessageTypes {
+			mt := wrp.StringToMessageType(name)
+			if !validMessageType(mt) {
+				return r, fmt.Errorf("invalid message type [%s] in partner ID rule", name)
+			}
+
+			r.messageTypes[mt] = true
+		}
+	}
+
+	for _, route := range cfg.Routes {
+		re, err := regexp.Compile(route)
+		if err != nil {
+			return r, fmt.Errorf("invalid route [%s] in partner ID rule: %w", route, err)
+		}
+
+		r.routes = append(r.routes, re)
+	}
+
+	return r, nil
+}
+
+// newWRPPartnersAccess creates the partner ID access policy for the WRPCheck configuration.
+func newWRPPartnersAccess(cfg WRPCheckConfig, receivedWRPMessageCount metrics.Counter) (*wrpPartnersAccess, error) {
+	policy, err := newWRPPartnersPolicy(cfg.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &wrpPartnersAccess{
+		strict:                  cfg.Type == enforceCheck,
+		receivedWRPMessageCount: receivedWRPMessageCount,
+		policy:                  policy,
+	}
+
+	for i, ruleConfig := range cfg.Rules {
+		rule, err := newWRPPartnersRule(ruleConfig, policy)
+		if err != nil {
+			return nil, fmt.Errorf("partner ID rule %d: %w", i, err)
+		}
+
+		p.rules = append(p.rules, rule)
+	}
+
+	return p, nil
+}
+
+// policyFor returns the policy of the first rule that applies to the message, or the default
+// policy if none does.
+func (p *wrpPartnersAccess) policyFor(ctx context.Context, msg *wrp.Message) wrpPartnersPolicy {
+	var path string
+	if vals, ok := FromContext(ctx); ok {
+		path = vals.Path
+	}
+
+	policy := p.policy
+	for i := range p.rules {
+		if p.rules[i].applies(path, msg) {
+			policy = p.rules[i].policy
+			break
+		}
+	}
+
+	return policy.withDefaults(p.strict)
+}
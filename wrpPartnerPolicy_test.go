@@ -0,0 +1,293 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/bascule/basculehttp"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestNewWRPPartnersAccess(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         WRPCheckConfig
+		expectedErr bool
+	}{
+		{
+			name: "built-in",
+			cfg:  WRPCheckConfig{Type: enforceCheck},
+		},
+		{
+			name: "configured",
+			cfg: WRPCheckConfig{
+				Type:   enforceCheck,
+				Policy: WRPPartnerPolicyConfig{Claims: []string{"partners"}, Match: matchIntersection},
+				Rules: []WRPPartnerRuleConfig{
+					{
+						MessageTypes:           []string{"SimpleEvent"},
+						Routes:                 []string{"/api/v3/device"},
+						WRPPartnerPolicyConfig: WRPPartnerPolicyConfig{OnMismatch: policyStrip},
+					},
+				},
+			},
+		},
+		{
+			name:        "invalid match",
+			cfg:         WRPCheckConfig{Policy: WRPPartnerPolicyConfig{Match: "superset"}},
+			expectedErr: true,
+		},
+		{
+			name:        "strip missing",
+			cfg:         WRPCheckConfig{Policy: WRPPartnerPolicyConfig{OnMissing: policyStrip}},
+			expectedErr: true,
+		},
+		{
+			name:        "empty claim",
+			cfg:         WRPCheckConfig{Policy: WRPPartnerPolicyConfig{Claims: []string{""}}},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid message type",
+			cfg:         WRPCheckConfig{Rules: []WRPPartnerRuleConfig{{MessageTypes: []string{"Unknown"}}}},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid route",
+			cfg:         WRPCheckConfig{Rules: []WRPPartnerRuleConfig{{Routes: []string{"("}}}},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newWRPPartnersAccess(tt.cfg, newTestCounter())
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.cfg.Type == enforceCheck, p.strict)
+			assert.Len(t, p.rules, len(tt.cfg.Rules))
+		})
+	}
+}
+
+func TestWRPPartnersAccessPolicies(t *testing.T) {
+	claims := map[string]interface{}{
+		"allowedResources": map[string]interface{}{"allowedPartners": []string{"comcast"}},
+		"partners":         []string{"comcast", "sky"},
+	}
+
+	tests := []struct {
+		name               string
+		cfg                WRPCheckConfig
+		path               string
+		msgType            wrp.MessageType
+		partnerIDs         []string
+		expectedModified   bool
+		expectedErr        error
+		expectedPartnerIDs []string
+		expectedReason     string
+		expectedOutcome    string
+	}{
+		{
+			name:               "built-in",
+			cfg:                WRPCheckConfig{Type: enforceCheck},
+			partnerIDs:         []string{"comcast", "sky"},
+			expectedErr:        ErrPIDMismatch,
+			expectedPartnerIDs: []string{"comcast", "sky"},
+			expectedReason:     WRPPIDMismatch,
+			expectedOutcome:    Rejected,
+		},
+		{
+			name:               "other claim",
+			cfg:                WRPCheckConfig{Type: enforceCheck, Policy: WRPPartnerPolicyConfig{Claims: []string{"missing", "partners"}}},
+			partnerIDs:         []string{"comcast", "sky"},
+			expectedPartnerIDs: []string{"comcast", "sky"},
+			expectedReason:     WRPPIDMatch,
+			expectedOutcome:    Accepted,
+		},
+		{
+			name:               "intersection",
+			cfg:                WRPCheckConfig{Type: enforceCheck, Policy: WRPPartnerPolicyConfig{Match: matchIntersection}},
+			partnerIDs:         []string{"comcast", "sky"},
+			expectedPartnerIDs: []string{"comcast", "sky"},
+			expectedReason:     WRPPIDMatch,
+			expectedOutcome:    Accepted,
+		},
+		{
+			name:               "strip",
+			cfg:                WRPCheckConfig{Type: enforceCheck, Policy: WRPPartnerPolicyConfig{OnMismatch: policyStrip}},
+			partnerIDs:         []string{"comcast", "sky"},
+			expectedModified:   true,
+			expectedPartnerIDs: []string{"comcast"},
+			expectedReason:     WRPPIDMismatch,
+			expectedOutcome:    Accepted,
+		},
+		{
+			name:               "strip everything",
+			cfg:                WRPCheckConfig{Type: enforceCheck, Policy: WRPPartnerPolicyConfig{OnMismatch: policyStrip}},
+			partnerIDs:         []string{"sky"},
+			expectedErr:        ErrPIDMismatch,
+			expectedPartnerIDs: []string{"sky"},
+			expectedReason:     WRPPIDMismatch,
+			expectedOutcome:    Rejected,
+		},
+		{
+			name:               "allow missing",
+			cfg:                WRPCheckConfig{Type: enforceCheck, Policy: WRPPartnerPolicyConfig{OnMissing: policyAllow}},
+			expectedReason:     WRPPIDMissing,
+			expectedOutcome:    Accepted,
+			expectedPartnerIDs: nil,
+		},
+		{
+			name:               "reject in monitor mode",
+			cfg:                WRPCheckConfig{Type: monitorCheck, Policy: WRPPartnerPolicyConfig{OnMissing: policyReject}},
+			expectedReason:     WRPPIDMissing,
+			expectedOutcome:    Accepted,
+			expectedPartnerIDs: nil,
+		},
+		{
+			name:               "strip everything in monitor mode",
+			cfg:                WRPCheckConfig{Type: monitorCheck, Policy: WRPPartnerPolicyConfig{OnMismatch: policyStrip}},
+			partnerIDs:         []string{"sky"},
+			expectedPartnerIDs: []string{"sky"},
+			expectedReason:     WRPPIDMismatch,
+			expectedOutcome:    Accepted,
+		},
+		{
+			name: "message type rule",
+			cfg: WRPCheckConfig{
+				Type: enforceCheck,
+				Rules: []WRPPartnerRuleConfig{
+					{MessageTypes: []string{"SimpleEvent"}, WRPPartnerPolicyConfig: WRPPartnerPolicyConfig{OnMismatch: policyRewrite}},
+				},
+			},
+			msgType:            wrp.SimpleEventMessageType,
+			partnerIDs:         []string{"sky"},
+			expectedModified:   true,
+			expectedPartnerIDs: []string{"comcast"},
+			expectedReason:     WRPPIDMismatch,
+			expectedOutcome:    Accepted,
+		},
+		{
+			name: "message type rule does not apply",
+			cfg: WRPCheckConfig{
+				Type: enforceCheck,
+				Rules: []WRPPartnerRuleConfig{
+					{MessageTypes: []string{"SimpleEvent"}, WRPPartnerPolicyConfig: WRPPartnerPolicyConfig{OnMismatch: policyRewrite}},
+				},
+			},
+			msgType:            wrp.SimpleRequestResponseMessageType,
+			partnerIDs:         []string{"sky"},
+			expectedErr:        ErrPIDMismatch,
+			expectedPartnerIDs: []string{"sky"},
+			expectedReason:     WRPPIDMismatch,
+			expectedOutcome:    Rejected,
+		},
+		{
+			name: "route rule",
+			cfg: WRPCheckConfig{
+				Type:   enforceCheck,
+				Policy: WRPPartnerPolicyConfig{Claims: []string{"partners"}},
+				Rules: []WRPPartnerRuleConfig{
+					{Routes: []string{"/devices/broadcast$"}, WRPPartnerPolicyConfig: WRPPartnerPolicyConfig{Match: matchIntersection}},
+				},
+			},
+			path:               "/api/v3/devices/broadcast",
+			partnerIDs:         []string{"sky", "other"},
+			expectedPartnerIDs: []string{"sky", "other"},
+			expectedReason:     WRPPIDMatch,
+			expectedOutcome:    Accepted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			counter := newTestCounter()
+			p, err := newWRPPartnersAccess(tt.cfg, counter)
+			require.NoError(t, err)
+
+			ctx := bascule.WithToken(t.Context(), &jwtToken{principal: "client", claims: claims})
+			ctx = NewContextWithValue(ctx, &ContextValues{Path: tt.path})
+			msg := &wrp.Message{Type: tt.msgType, PartnerIDs: tt.partnerIDs}
+
+			modified, err := p.authorizeWRP(ctx, msg)
+			assert.Equal(tt.expectedModified, modified)
+			assert.Equal(tt.expectedErr, err)
+			assert.Equal(tt.expectedPartnerIDs, msg.PartnerIDs)
+			assert.Equal(tt.expectedReason, counter.labelPairs[ReasonLabel])
+			assert.Equal(tt.expectedOutcome, counter.labelPairs[OutcomeLabel])
+		})
+	}
+}
+
+func TestWRPPartnersAccessMappedBasicUser(t *testing.T) {
+	h, err := newSaltedPasswordHash("pass")
+	require.NoError(t, err)
+
+	parser := basicAllowedTokenParser{
+		allowed: map[string]passwordHash{"user": h, "unmapped": h},
+		claims: map[string]map[string]any{
+			"user": BasicUserMapping{User: "user", Partners: []string{"comcast"}}.claims(),
+		},
+	}
+
+	tests := []struct {
+		name            string
+		user            string
+		partnerIDs      []string
+		expectedErr     error
+		expectedReason  string
+		expectedOutcome string
+	}{
+		{
+			name:            "match",
+			user:            "user",
+			partnerIDs:      []string{"comcast"},
+			expectedReason:  WRPPIDMatch,
+			expectedOutcome: Accepted,
+		},
+		{
+			name:            "mismatch",
+			user:            "user",
+			partnerIDs:      []string{"sky"},
+			expectedErr:     ErrPIDMismatch,
+			expectedReason:  WRPPIDMismatch,
+			expectedOutcome: Rejected,
+		},
+		{
+			name:            "unmapped",
+			user:            "unmapped",
+			partnerIDs:      []string{"comcast"},
+			expectedErr:     ErrTokenTypeMismatch,
+			expectedReason:  TokenTypeMismatch,
+			expectedOutcome: Rejected,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			token, err := parser.Parse(t.Context(), basculehttp.BasicAuth(tt.user, "pass"))
+			require.NoError(t, err)
+
+			counter := newTestCounter()
+			p, err := newWRPPartnersAccess(WRPCheckConfig{Type: enforceCheck}, counter)
+			require.NoError(t, err)
+
+			_, err = p.authorizeWRP(bascule.WithToken(t.Context(), token), &wrp.Message{PartnerIDs: tt.partnerIDs})
+			assert.Equal(tt.expectedErr, err)
+			assert.Equal(tt.expectedReason, counter.labelPairs[ReasonLabel])
+			assert.Equal(tt.expectedOutcome, counter.labelPairs[OutcomeLabel])
+		})
+	}
+}
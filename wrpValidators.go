@@ -0,0 +1,292 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/xmidt-org/touchstone"
+	"github.com/xmidt-org/webpa-common/v2/xhttp"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrp-go/v3/wrpcontext"
+	"github.com/xmidt-org/wrp-go/v3/wrphttp"
+	"go.uber.org/zap"
+)
+
+// wrp validator types
+const (
+	sourceValidatorType          = "source"
+	destinationValidatorType     = "destination"
+	messageTypeValidatorType     = "messageType"
+	utf8ValidatorType            = "utf8"
+	transactionUUIDValidatorType = "transactionUUID"
+	partnerIDsValidatorType      = "partnerIDs"
+)
+
+var (
+	errSourceRequired          = errors.New("source is required")
+	errDestinationRequired     = errors.New("destination is required")
+	errDestinationNotDevice    = errors.New("destination must be a device locator")
+	errMessageTypeNotAllowed   = errors.New("message type is not allowed")
+	errTransactionUUIDRequired = errors.New("transaction_uuid is required for this message type")
+	errEmptyPartnerID          = errors.New("partner_ids must not contain empty values")
+	errInvalidPartnerID        = errors.New("partner_ids must not contain whitespace or control characters")
+)
+
+// sendWRPDecoder is the decoder used for WRP send requests, both by the validators and
+// the send handler itself.
+var sendWRPDecoder = wrphttp.DecodeEntityFromSources(wrp.Msgpack, true)
+
+// WRPValidatorConfig drives a single WRP validator through the wrpValidators configuration.
+type WRPValidatorConfig struct {
+	// Type is the check to run: source, destination, messageType, utf8,
+	// transactionUUID or partnerIDs.
+	Type string
+
+	// Level is either "monitor" or "enforce".  Failures of a monitored validator
+	// are only counted, failures of an enforced validator reject the request.
+	Level string
+
+	// MessageTypes is the allowlist of message types used by the messageType validator.
+	// When empty, every valid message type is allowed.
+	MessageTypes []string
+}
+
+// wrpValidator is a single named check run against inbound WRP messages.
+type wrpValidator struct {
+	name    string
+	enforce bool
+	check   func(*wrp.Message) error
+}
+
+// wrpValidationFailure describes a single failed check in a rejected request's body.
+type wrpValidationFailure struct {
+	Validator string `json:"validator"`
+	Message   string `json:"message"`
+}
+
+type wrpValidators struct {
+	validators []wrpValidator
+	failures   *prometheus.CounterVec
+}
+
+func newWRPValidators(configs []WRPValidatorConfig, tf *touchstone.Factory) (*wrpValidators, error) {
+	validators := make([]wrpValidator, 0, len(configs))
+	for _, c := range configs {
+		if c.Level != enforceCheck && c.Level != monitorCheck {
+			return nil, fmt.Errorf("invalid level [%s] for wrp validator [%s]", c.Level, c.Type)
+		}
+
+		check, err := newWRPCheck(c)
+		if err != nil {
+			return nil, err
+		}
+
+		validators = append(validators, wrpValidator{
+			name:    c.Type,
+			enforce: c.Level == enforceCheck,
+			check:   check,
+		})
+	}
+
+	failures, err := tf.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: WRPValidationFailureCount,
+			Help: "Number of WRP messages that failed a validator, by validator and outcome.",
+		},
+		ValidatorLabel, OutcomeLabel,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wrpValidators{
+		validators: validators,
+		failures:   failures,
+	}, nil
+}
+
+func newWRPCheck(c WRPValidatorConfig) (func(*wrp.Message) error, error) {
+	switch c.Type {
+	case sourceValidatorType:
+		return validateSource, nil
+	case destinationValidatorType:
+		return validateDestination, nil
+	case messageTypeValidatorType:
+		return newMessageTypeCheck(c.MessageTypes)
+	case utf8ValidatorType:
+		return validateUTF8, nil
+	case transactionUUIDValidatorType:
+		return validateTransactionUUID, nil
+	case partnerIDsValidatorType:
+		return validatePartnerIDs, nil
+	}
+
+	return nil, fmt.Errorf("unknown wrp validator type [%s]", c.Type)
+}
+
+// validate runs every validator against the message and returns the failures of
+// the enforced validators.  Failures of monitored validators are only counted.
+func (vs *wrpValidators) validate(msg *wrp.Message) []wrpValidationFailure {
+	if vs == nil {
+		return nil
+	}
+
+	var failures []wrpValidationFailure
+	for _, v := range vs.validators {
+		err := v.check(msg)
+		if err == nil {
+			continue
+		}
+
+		outcome := Accepted
+		if v.enforce {
+			outcome = Rejected
+			failures = append(failures, wrpValidationFailure{
+				Validator: v.name,
+				Message:   err.Error(),
+			})
+		}
+
+		vs.failures.With(prometheus.Labels{
+			ValidatorLabel: v.name,
+			OutcomeLabel:   outcome,
+		}).Inc()
+	}
+
+	return failures
+}
+
+// then returns middleware that decodes the inbound WRP message once, validates it and stores it
+// in the request context for the rest of the send path.  A nil wrpValidators returns middleware
+// that does nothing.
+func (vs *wrpValidators) then(logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(delegate http.Handler) http.Handler {
+		if vs == nil {
+			return delegate
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeRequestError(w, r, http.StatusBadRequest, fmt.Sprintf("failed to read request body: %s", err))
+				return
+			}
+
+			r.Body, r.GetBody = xhttp.NewRewindBytes(body)
+			entity, err := sendWRPDecoder(r.Context(), r)
+			if err != nil {
+				writeRequestError(w, r, http.StatusBadRequest, fmt.Sprintf("failed to decode wrp message: %s", err))
+				return
+			}
+
+			if failures := vs.validate(&entity.Message); len(failures) > 0 {
+				logger.Debug("wrp message rejected by validators",
+					zap.String("destination", entity.Message.Destination),
+					zap.String("transactionUUID", entity.Message.TransactionUUID),
+					zap.Any("failures", failures))
+				writeWRPValidationFailures(w, r, &entity.Message, failures)
+				return
+			}
+
+			ctx := wrpcontext.SetMessage(r.Context(), &entity.Message)
+			ctx = wrpcontext.SetContents(ctx, entity.Bytes)
+			r.Body, r.GetBody = xhttp.NewRewindBytes(body)
+			delegate.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// writeWRPValidationFailures writes the error body of a rejected message, listing the failed
+// checks alongside the message's device ID and transaction UUID.
+func writeWRPValidationFailures(w http.ResponseWriter, r *http.Request, msg *wrp.Message, failures []wrpValidationFailure) {
+	ctx := wrpcontext.SetMessage(r.Context(), msg)
+	ctx = context.WithValue(ctx, validationFailuresKey{}, failures)
+	writeRequestError(w, r.WithContext(ctx), http.StatusBadRequest, "invalid wrp message")
+}
+
+func validateSource(msg *wrp.Message) error {
+	if msg.Source == "" {
+		return errSourceRequired
+	}
+
+	_, err := wrp.ParseLocator(msg.Source)
+	return err
+}
+
+func validateDestination(msg *wrp.Message) error {
+	if msg.Destination == "" {
+		return errDestinationRequired
+	}
+
+	l, err := wrp.ParseLocator(msg.Destination)
+	if err != nil {
+		return err
+	}
+
+	if !l.HasDeviceID() || l.IsSelf() {
+		return errDestinationNotDevice
+	}
+
+	return nil
+}
+
+func newMessageTypeCheck(names []string) (func(*wrp.Message) error, error) {
+	allowed := make(map[wrp.MessageType]bool, len(names))
+	for _, name := range names {
+		mt := wrp.StringToMessageType(name)
+		if !validMessageType(mt) {
+			return nil, fmt.Errorf("invalid message type [%s] in wrp validator allowlist", name)
+		}
+
+		allowed[mt] = true
+	}
+
+	return func(msg *wrp.Message) error {
+		if !validMessageType(msg.Type) || (len(allowed) > 0 && !allowed[msg.Type]) {
+			return fmt.Errorf("%w: %s", errMessageTypeNotAllowed, msg.Type)
+		}
+
+		return nil
+	}, nil
+}
+
+func validMessageType(mt wrp.MessageType) bool {
+	return mt > wrp.Invalid1MessageType && mt < wrp.UnknownMessageType
+}
+
+func validateUTF8(msg *wrp.Message) error {
+	return wrp.UTF8(msg)
+}
+
+func validateTransactionUUID(msg *wrp.Message) error {
+	if msg.Type.RequiresTransaction() && msg.TransactionUUID == "" {
+		return errTransactionUUIDRequired
+	}
+
+	return nil
+}
+
+func validatePartnerIDs(msg *wrp.Message) error {
+	for _, id := range msg.PartnerIDs {
+		if id == "" {
+			return errEmptyPartnerID
+		}
+
+		if strings.IndexFunc(id, func(r rune) bool {
+			return unicode.IsSpace(r) || unicode.IsControl(r)
+		}) >= 0 {
+			return errInvalidPartnerID
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,301 @@
+// SPDX-FileCopyrightText: 2026 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/touchstone"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrp-go/v3/wrpcontext"
+	"go.uber.org/zap"
+)
+
+func newTestFactory() *touchstone.Factory {
+	return touchstone.NewFactory(touchstone.Config{}, zap.NewNop(), prometheus.NewPedanticRegistry())
+}
+
+func TestWRPChecks(t *testing.T) {
+	messageTypeCheck, err := newMessageTypeCheck([]string{"SimpleEvent"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		check     func(*wrp.Message) error
+		msg       wrp.Message
+		shouldErr bool
+	}{
+		{
+			name:  "valid source",
+			check: validateSource,
+			// nolint: goconst
+			msg: wrp.Message{Source: "dns:scytale.example.com"},
+		},
+		{
+			name:      "missing source",
+			check:     validateSource,
+			shouldErr: true,
+		},
+		{
+			name:      "invalid source",
+			check:     validateSource,
+			msg:       wrp.Message{Source: "scytale"},
+			shouldErr: true,
+		},
+		{
+			name:  "valid destination",
+			check: validateDestination,
+			// nolint: goconst
+			msg: wrp.Message{Destination: "mac:112233445566/config"},
+		},
+		{
+			name:      "missing destination",
+			check:     validateDestination,
+			shouldErr: true,
+		},
+		{
+			name:      "destination is not a device",
+			check:     validateDestination,
+			msg:       wrp.Message{Destination: "dns:talaria.example.com"},
+			shouldErr: true,
+		},
+		{
+			name:      "destination with bad mac",
+			check:     validateDestination,
+			msg:       wrp.Message{Destination: "mac:11223344"},
+			shouldErr: true,
+		},
+		{
+			name:  "allowed message type",
+			check: messageTypeCheck,
+			msg:   wrp.Message{Type: wrp.SimpleEventMessageType},
+		},
+		{
+			name:      "message type not in allowlist",
+			check:     messageTypeCheck,
+			msg:       wrp.Message{Type: wrp.SimpleRequestResponseMessageType},
+			shouldErr: true,
+		},
+		{
+			name:  "valid utf8",
+			check: validateUTF8,
+			msg:   wrp.Message{Source: "dns:scytale.example.com"},
+		},
+		{
+			name:      "invalid utf8",
+			check:     validateUTF8,
+			msg:       wrp.Message{Source: "dns:\xbd\xb2"},
+			shouldErr: true,
+		},
+		{
+			name:  "transaction uuid present",
+			check: validateTransactionUUID,
+			// nolint: goconst
+			msg: wrp.Message{Type: wrp.SimpleRequestResponseMessageType, TransactionUUID: "DEADBEEF"},
+		},
+		{
+			name:  "transaction uuid not required",
+			check: validateTransactionUUID,
+			msg:   wrp.Message{Type: wrp.SimpleEventMessageType},
+		},
+		{
+			name:      "transaction uuid missing",
+			check:     validateTransactionUUID,
+			msg:       wrp.Message{Type: wrp.SimpleRequestResponseMessageType},
+			shouldErr: true,
+		},
+		{
+			name:  "valid partner ids",
+			check: validatePartnerIDs,
+			msg:   wrp.Message{PartnerIDs: []string{"comcast", "*"}},
+		},
+		{
+			name:      "empty partner id",
+			check:     validatePartnerIDs,
+			msg:       wrp.Message{PartnerIDs: []string{"comcast", ""}},
+			shouldErr: true,
+		},
+		{
+			name:      "partner id with whitespace",
+			check:     validatePartnerIDs,
+			msg:       wrp.Message{PartnerIDs: []string{"com cast"}},
+			shouldErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check(&tt.msg)
+			if tt.shouldErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestNewWRPValidators(t *testing.T) {
+	tests := []struct {
+		name      string
+		configs   []WRPValidatorConfig
+		shouldErr bool
+	}{
+		{
+			name: "valid configuration",
+			configs: []WRPValidatorConfig{
+				{Type: destinationValidatorType, Level: enforceCheck},
+				{Type: messageTypeValidatorType, Level: monitorCheck, MessageTypes: []string{"SimpleRequestResponse", "event"}},
+			},
+		},
+		{
+			name:      "unknown type",
+			configs:   []WRPValidatorConfig{{Type: "unknown", Level: enforceCheck}},
+			shouldErr: true,
+		},
+		{
+			name:      "unknown level",
+			configs:   []WRPValidatorConfig{{Type: sourceValidatorType, Level: "warning"}},
+			shouldErr: true,
+		},
+		{
+			name:      "invalid message type in allowlist",
+			configs:   []WRPValidatorConfig{{Type: messageTypeValidatorType, Level: enforceCheck, MessageTypes: []string{"Bogus"}}},
+			shouldErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vs, err := newWRPValidators(tt.configs, newTestFactory())
+			if tt.shouldErr {
+				assert.Error(t, err)
+				assert.Nil(t, vs)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Len(t, vs.validators, len(tt.configs))
+		})
+	}
+}
+
+func TestWRPValidatorsMiddleware(t *testing.T) {
+	tests := []struct {
+		name             string
+		level            string
+		msg              wrp.Message
+		body             []byte
+		accept           string
+		expectedCode     int
+		expectedFailures int
+		expectedOutcome  string
+		expectedCount    float64
+	}{
+		{
+			name:            "valid message",
+			level:           enforceCheck,
+			msg:             wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "mac:112233445566"},
+			expectedCode:    http.StatusOK,
+			expectedOutcome: Rejected,
+		},
+		{
+			name:             "enforced failure",
+			level:            enforceCheck,
+			msg:              wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "dns:talaria.example.com"},
+			expectedCode:     http.StatusBadRequest,
+			expectedFailures: 1,
+			expectedOutcome:  Rejected,
+			expectedCount:    1,
+		},
+		{
+			name:             "enforced failure as problem details",
+			level:            enforceCheck,
+			msg:              wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "dns:talaria.example.com", TransactionUUID: "DEADBEEF"},
+			accept:           problemContentType,
+			expectedCode:     http.StatusBadRequest,
+			expectedFailures: 1,
+			expectedOutcome:  Rejected,
+			expectedCount:    1,
+		},
+		{
+			name:            "monitored failure",
+			level:           monitorCheck,
+			msg:             wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "dns:talaria.example.com"},
+			expectedCode:    http.StatusOK,
+			expectedOutcome: Accepted,
+			expectedCount:   1,
+		},
+		{
+			name:            "undecodable body",
+			level:           enforceCheck,
+			body:            []byte("not a wrp message"),
+			expectedCode:    http.StatusBadRequest,
+			expectedOutcome: Rejected,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			vs, err := newWRPValidators([]WRPValidatorConfig{{Type: destinationValidatorType, Level: tt.level}}, newTestFactory())
+			require.NoError(err)
+
+			body := tt.body
+			if body == nil {
+				body = wrp.MustEncode(tt.msg, wrp.JSON)
+			}
+
+			var decoded *wrp.Message
+			handler := vs.then(zap.NewNop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				decoded, _ = wrpcontext.GetMessage(r.Context())
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			request := httptest.NewRequest(http.MethodPost, "/api/v3/device", bytes.NewReader(body))
+			request.Header.Set("Content-Type", wrp.JSON.ContentType())
+			if len(tt.accept) > 0 {
+				request.Header.Set("Accept", tt.accept)
+			}
+
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+
+			assert.Equal(tt.expectedCode, response.Code)
+			assert.Equal(tt.expectedCount, testutil.ToFloat64(vs.failures.WithLabelValues(destinationValidatorType, tt.expectedOutcome)))
+			if tt.expectedCode != http.StatusOK {
+				assert.NotEmpty(response.Header().Get("X-Xmidt-Error"))
+				if tt.accept == problemContentType {
+					var resp problemDetails
+					assert.Equal(problemContentType, response.Header().Get("Content-Type"))
+					require.NoError(json.NewDecoder(response.Body).Decode(&resp))
+					assert.Equal(http.StatusBadRequest, resp.Status)
+					assert.Equal(tt.msg.TransactionUUID, resp.TransactionUUID)
+					assert.Len(resp.Failures, tt.expectedFailures)
+					return
+				}
+
+				var resp errorResponse
+				require.NoError(json.NewDecoder(response.Body).Decode(&resp))
+				assert.Equal(http.StatusBadRequest, resp.Code)
+				assert.Len(resp.Failures, tt.expectedFailures)
+				return
+			}
+
+			require.NotNil(decoded)
+			assert.Equal(tt.msg.Destination, decoded.Destination)
+		})
+	}
+}